@@ -0,0 +1,252 @@
+package SIREN
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vmihailenco/msgpack"
+	"go.etcd.io/bbolt"
+)
+
+const geometryBucket = "Geometry"
+
+// Bumped whenever the way alert geometry is calculated changes, so persisted entries
+// from an older build are recalculated instead of served
+const GeometryRevision = 3
+
+// Persisted entries not used for this long are dropped when the cache is loaded, alerts
+// don't last this long. Last use is only written back this often to keep hits cheap.
+const (
+	cacheMaxAge        = 7 * 24 * time.Hour
+	cacheTouchInterval = time.Hour
+)
+
+// Swapped out by tests
+var cacheClock = time.Now
+
+type cacheEntry struct {
+	Identifier string        `msgpack:"identifier"`
+	AreaHash   string        `msgpack:"areaHash"`
+	Geometry   AlertGeometry `msgpack:"geometry"`
+	LastUsed   int64         `msgpack:"lastUsed,omitempty"` // Unix seconds, entries from before it was kept have none and are dropped
+
+	persistedUse int64
+}
+
+// GeometryCache is a bounded LRU of computed alert geometry, keyed by the SIREN
+// identifier. An entry is only valid for the area set it was computed from, so
+// an alert that gains or loses areas gets recomputed while everything else is reused.
+type GeometryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Front is the most recently used
+	db       *bbolt.DB
+
+	hits   uint64
+	misses uint64
+}
+
+// NewGeometryCache creates a cache holding at most capacity alerts. If path is not
+// empty, entries are also persisted to a bbolt file there so a restart starts warm.
+// The most recently used entries are loaded back, the rest are deleted from the file.
+func NewGeometryCache(capacity int, path string) (*GeometryCache, error) {
+	cache := &GeometryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+
+	if path == "" {
+		return cache, nil
+	}
+
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	cache.db = db
+
+	var dropped int
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(geometryBucket))
+		if err != nil {
+			return err
+		}
+
+		var loaded []*cacheEntry
+		var stale [][]byte
+		oldest := cacheClock().Add(-cacheMaxAge).Unix()
+		err = b.ForEach(func(k, v []byte) error {
+			var entry cacheEntry
+			if err := msgpack.Unmarshal(v, &entry); err != nil {
+				log.Warn("Dropping unreadable cached geometry", "id", string(k), "err", err)
+				stale = append(stale, slices.Clone(k))
+				return nil
+			}
+			if entry.LastUsed < oldest {
+				stale = append(stale, slices.Clone(k))
+				return nil
+			}
+			entry.persistedUse = entry.LastUsed
+			loaded = append(loaded, &entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Most recently used first, so the LRU order survives the restart
+		sort.SliceStable(loaded, func(i, j int) bool { return loaded[i].LastUsed > loaded[j].LastUsed })
+		for _, entry := range loaded {
+			if cache.order.Len() < cache.capacity {
+				cache.entries[entry.Identifier] = cache.order.PushBack(entry)
+			} else {
+				stale = append(stale, []byte(entry.Identifier))
+			}
+		}
+
+		// Keys can't be deleted while iterating
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		dropped = len(stale)
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Info("Loaded geometry cache", "path", path, "entries", cache.order.Len(), "dropped", dropped)
+	return cache, nil
+}
+
+// Get returns the geometry for the alert if it was computed for the same area set.
+func (c *GeometryCache) Get(identifier string, areaHash string) (AlertGeometry, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[identifier]
+	if !ok || elem.Value.(*cacheEntry).AreaHash != areaHash {
+		c.misses++
+		c.mu.Unlock()
+		return AlertGeometry{}, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	entry.LastUsed = cacheClock().Unix()
+	var v []byte
+	touched := false
+	if c.db != nil && time.Duration(entry.LastUsed-entry.persistedUse)*time.Second >= cacheTouchInterval {
+		v, touched = c.encode(entry)
+	}
+	geometry := entry.Geometry
+	c.mu.Unlock()
+
+	// Keeps the last use on file up to date, so a restart keeps the entries in use
+	if touched {
+		c.write(identifier, v, nil)
+	}
+	return geometry, true
+}
+
+// Put stores the geometry for the alert, replacing whatever was cached for it before.
+func (c *GeometryCache) Put(identifier string, areaHash string, geometry AlertGeometry) {
+	entry := &cacheEntry{Identifier: identifier, AreaHash: areaHash, Geometry: geometry, LastUsed: cacheClock().Unix()}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[identifier]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[identifier] = c.order.PushFront(entry)
+	}
+
+	var evicted []string
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		id := oldest.Value.(*cacheEntry).Identifier
+		c.order.Remove(oldest)
+		delete(c.entries, id)
+		evicted = append(evicted, id)
+	}
+
+	var v []byte
+	ok := false
+	if c.db != nil {
+		v, ok = c.encode(entry)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.write(identifier, v, evicted)
+	}
+}
+
+// Encodes the entry for the file, the caller holds the lock since hits update it
+func (c *GeometryCache) encode(entry *cacheEntry) ([]byte, bool) {
+	v, err := msgpack.Marshal(entry)
+	if err != nil {
+		log.Warn("Failed to encode cached geometry", "id", entry.Identifier, "err", err)
+		return nil, false
+	}
+	entry.persistedUse = entry.LastUsed
+	return v, true
+}
+
+// Writes an encoded entry and deletes the evicted ones
+func (c *GeometryCache) write(identifier string, v []byte, evicted []string) {
+	err := c.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(geometryBucket))
+		for _, id := range evicted {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(identifier), v)
+	})
+	if err != nil {
+		log.Warn("Failed to persist cached geometry", "id", identifier, "err", err)
+	}
+}
+
+// Stats returns the number of cache hits and misses since startup
+func (c *GeometryCache) Stats() (uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *GeometryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *GeometryCache) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// AreaSetHash hashes a set of areas independent of their order. Anything else the
+// geometry depends on, like the dataset versions, can be mixed in with salt.
+func AreaSetHash(areas []string, salt string) string {
+	sorted := slices.Clone(areas)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	hash := sha256.Sum256([]byte(salt + "|" + strings.Join(sorted, ",")))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,126 @@
+package SIREN
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Moves the cache's clock to the given time for the rest of the test
+func setCacheClock(t *testing.T, now *time.Time) {
+	t.Helper()
+	previous := cacheClock
+	cacheClock = func() time.Time { return *now }
+	t.Cleanup(func() { cacheClock = previous })
+}
+
+func geometryFor(id string) AlertGeometry {
+	return AlertGeometry{Identifier: id, GeometryType: "MultiPolygon", Coordinates: AbstractGeom{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}}
+}
+
+// The identifiers persisted in the cache file
+func persistedIDs(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ids []string
+	db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(geometryBucket)).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	slices.Sort(ids)
+	return ids
+}
+
+func TestGeometryCacheReload(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	setCacheClock(t, &now)
+	path := filepath.Join(t.TempDir(), "geometry.db")
+
+	cache, err := NewGeometryCache(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is the oldest by insertion but used last, c is used least recently
+	for _, id := range []string{"a", "b", "c", "d"} {
+		cache.Put(id, "hash", geometryFor(id))
+		now = now.Add(time.Minute)
+	}
+	now = now.Add(2 * time.Hour)
+	for _, id := range []string{"b", "d", "a"} {
+		if _, ok := cache.Get(id, "hash"); !ok {
+			t.Fatalf("%s missing", id)
+		}
+		now = now.Add(time.Minute)
+	}
+	cache.Close()
+
+	t.Run("keeps the most recently used", func(t *testing.T) {
+		cache, err := NewGeometryCache(2, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cache.Close()
+		if cache.Len() != 2 {
+			t.Fatalf("loaded %d entries, want 2", cache.Len())
+		}
+		for _, id := range []string{"a", "d"} {
+			if _, ok := cache.Get(id, "hash"); !ok {
+				t.Errorf("%s, one of the two most recently used, wasn't loaded", id)
+			}
+		}
+	})
+
+	t.Run("skipped entries are deleted", func(t *testing.T) {
+		if ids := persistedIDs(t, path); !slices.Equal(ids, []string{"a", "d"}) {
+			t.Errorf("file has %v, want [a d]", ids)
+		}
+	})
+
+	t.Run("old entries are pruned", func(t *testing.T) {
+		now = now.Add(cacheMaxAge + time.Hour)
+		cache, err := NewGeometryCache(10, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Close()
+		if cache.Len() != 0 {
+			t.Errorf("loaded %d entries unused for over %s", cache.Len(), cacheMaxAge)
+		}
+		if ids := persistedIDs(t, path); len(ids) != 0 {
+			t.Errorf("file still has %v", ids)
+		}
+	})
+}
+
+func TestGeometryCacheEviction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geometry.db")
+	cache, err := NewGeometryCache(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("a", "hash", geometryFor("a"))
+	cache.Put("b", "hash", geometryFor("b"))
+	cache.Get("a", "hash")
+	cache.Put("c", "hash", geometryFor("c"))
+
+	if _, ok := cache.Get("b", "hash"); ok {
+		t.Error("b, the least recently used, wasn't evicted")
+	}
+	if _, ok := cache.Get("a", "other"); ok {
+		t.Error("a hit for a different area set")
+	}
+	cache.Close()
+
+	if ids := persistedIDs(t, path); !slices.Equal(ids, []string{"a", "c"}) {
+		t.Errorf("file has %v, want [a c]", ids)
+	}
+}
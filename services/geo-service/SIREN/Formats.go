@@ -0,0 +1,439 @@
+package SIREN
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonas-p/go-shp"
+	geojson "github.com/paulmach/go.geojson"
+	"github.com/rubenv/topojson"
+	"github.com/vmihailenco/msgpack"
+)
+
+// The formats the geometry endpoints can respond with
+type OutputFormat string
+
+const (
+	FormatMsgPack   OutputFormat = "msgpack" // TopoJSON encoded as MsgPack, what the web client uses
+	FormatTopoJSON  OutputFormat = "topojson"
+	FormatGeoJSON   OutputFormat = "geojson"
+	FormatKML       OutputFormat = "kml"
+	FormatShapefile OutputFormat = "shapefile"
+)
+
+var formatContentTypes = map[OutputFormat]string{
+	FormatMsgPack:   "application/msgpack",
+	FormatTopoJSON:  "application/json",
+	FormatGeoJSON:   "application/geo+json",
+	FormatKML:       "application/vnd.google-earth.kml+xml",
+	FormatShapefile: "application/zip",
+}
+
+var formatFileExtensions = map[OutputFormat]string{
+	FormatMsgPack:   "msgpack",
+	FormatTopoJSON:  "topojson",
+	FormatGeoJSON:   "geojson",
+	FormatKML:       "kml",
+	FormatShapefile: "zip",
+}
+
+// Media types we accept in the Accept header
+var acceptedMediaTypes = map[string]OutputFormat{
+	"application/msgpack":                  FormatMsgPack,
+	"application/x-msgpack":                FormatMsgPack,
+	"application/topo+json":                FormatTopoJSON,
+	"application/json":                     FormatTopoJSON,
+	"application/geo+json":                 FormatGeoJSON,
+	"application/vnd.geo+json":             FormatGeoJSON,
+	"application/vnd.google-earth.kml+xml": FormatKML,
+	"application/zip":                      FormatShapefile,
+	"application/x-shapefile":              FormatShapefile,
+	"*/*":                                  FormatMsgPack,
+	"application/*":                        FormatMsgPack,
+}
+
+func (f OutputFormat) ContentType() string {
+	return formatContentTypes[f]
+}
+
+func (f OutputFormat) FileExtension() string {
+	return formatFileExtensions[f]
+}
+
+// Formats a wildcard stands for, most preferred first
+var wildcardFormats = []OutputFormat{FormatMsgPack, FormatTopoJSON, FormatGeoJSON, FormatKML, FormatShapefile}
+
+// A media range from the Accept header with its quality
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func (r mediaRange) wildcard() bool {
+	return strings.HasSuffix(r.mediaType, "/*")
+}
+
+// Splits the Accept header into media ranges, q defaults to 1
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			// A q that doesn't parse is treated as not acceptable
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 {
+				q = 0
+			}
+			r.q = min(q, 1)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// NegotiateFormat picks the output format from the ?format= parameter if given,
+// otherwise from the Accept header. Without either the MsgPack TopoJSON is used.
+func NegotiateFormat(query string, accept string) (OutputFormat, error) {
+	if query != "" {
+		format := OutputFormat(strings.ToLower(query))
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q", query)
+		}
+		return format, nil
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return FormatMsgPack, nil
+	}
+
+	// Highest q first, exact types before wildcards, otherwise in the order the client listed them
+	ranges := parseAccept(accept)
+	slices.SortStableFunc(ranges, func(a, b mediaRange) int {
+		if c := cmp.Compare(b.q, a.q); c != 0 {
+			return c
+		}
+		if a.wildcard() != b.wildcard() {
+			if a.wildcard() {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	})
+
+	// q=0 means not acceptable, so a wildcard can't fall back to it
+	refused := map[OutputFormat]bool{}
+	for _, r := range ranges {
+		if format, ok := acceptedMediaTypes[r.mediaType]; ok && r.q == 0 && !r.wildcard() {
+			refused[format] = true
+		}
+	}
+
+	for _, r := range ranges {
+		if r.q == 0 {
+			break
+		}
+		if r.wildcard() {
+			if _, ok := acceptedMediaTypes[r.mediaType]; !ok {
+				continue
+			}
+			for _, format := range wildcardFormats {
+				if !refused[format] {
+					return format, nil
+				}
+			}
+			continue
+		}
+		if format, ok := acceptedMediaTypes[r.mediaType]; ok {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("no supported format in %q", accept)
+}
+
+// EncodeFeatureCollection encodes the alert features in the given format
+func EncodeFeatureCollection(format OutputFormat, collection geojson.FeatureCollection) ([]byte, error) {
+	switch format {
+	case FormatGeoJSON:
+		return json.Marshal(collection)
+	case FormatTopoJSON:
+		return topojson.NewTopology(&collection, nil).MarshalJSON()
+	case FormatMsgPack:
+		topoJson, err := topojson.NewTopology(&collection, nil).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		// Convert to any so the MsgPack matches the JSON layout
+		var jsonObject map[string]any
+		if err := json.Unmarshal(topoJson, &jsonObject); err != nil {
+			return nil, err
+		}
+		return msgpack.Marshal(jsonObject)
+	case FormatKML:
+		return encodeKML(collection)
+	case FormatShapefile:
+		return encodeShapefile(collection)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func propertyString(feature *geojson.Feature, key string) string {
+	if v, ok := feature.Properties[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+/**============================================
+ *                    KML
+ *=============================================**/
+
+type kmlDocument struct {
+	XMLName  xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document struct {
+		Name       string         `xml:"name"`
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlPlacemark struct {
+	Name         string `xml:"name"`
+	Description  string `xml:"description,omitempty"`
+	Style        kmlStyle
+	ExtendedData struct {
+		Data []kmlData `xml:"Data"`
+	} `xml:"ExtendedData"`
+	MultiGeometry struct {
+		Polygons []kmlPolygon `xml:"Polygon"`
+	} `xml:"MultiGeometry"`
+}
+
+type kmlStyle struct {
+	XMLName   xml.Name `xml:"Style"`
+	LineColor string   `xml:"LineStyle>color"`
+	LineWidth int      `xml:"LineStyle>width"`
+	PolyColor string   `xml:"PolyStyle>color"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates,omitempty"`
+}
+
+// KML colors are aabbggrr instead of #rrggbb
+func kmlColor(hex string, alpha string) string {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		hex = "EFEFEF"
+	}
+	return strings.ToLower(alpha + hex[4:6] + hex[2:4] + hex[0:2])
+}
+
+func kmlCoordinates(ring [][]float64) string {
+	points := make([]string, 0, len(ring))
+	for _, pt := range ring {
+		if len(pt) < 2 {
+			continue
+		}
+		points = append(points, fmt.Sprintf("%g,%g", pt[0], pt[1]))
+	}
+	return strings.Join(points, " ")
+}
+
+func encodeKML(collection geojson.FeatureCollection) ([]byte, error) {
+	var doc kmlDocument
+	doc.Document.Name = "SIREN Active Alerts"
+
+	for _, feature := range collection.Features {
+		if feature.Geometry == nil || !feature.Geometry.IsMultiPolygon() {
+			continue
+		}
+
+		color := propertyString(feature, "color")
+		placemark := kmlPlacemark{
+			Name:        propertyString(feature, "id"),
+			Description: propertyString(feature, "event"),
+			Style: kmlStyle{
+				LineColor: kmlColor(color, "ff"),
+				LineWidth: 2,
+				PolyColor: kmlColor(color, "80"),
+			},
+		}
+		for _, key := range []string{"id", "event", "severity", "color"} {
+			if value := propertyString(feature, key); value != "" {
+				placemark.ExtendedData.Data = append(placemark.ExtendedData.Data, kmlData{Name: key, Value: value})
+			}
+		}
+
+		for _, polygon := range feature.Geometry.MultiPolygon {
+			if len(polygon) == 0 {
+				continue
+			}
+			kmlPoly := kmlPolygon{Outer: kmlCoordinates(polygon[0])}
+			for _, hole := range polygon[1:] {
+				kmlPoly.Inner = append(kmlPoly.Inner, kmlCoordinates(hole))
+			}
+			placemark.MultiGeometry.Polygons = append(placemark.MultiGeometry.Polygons, kmlPoly)
+		}
+
+		doc.Document.Placemarks = append(doc.Document.Placemarks, placemark)
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+/**============================================
+ *                 Shapefile
+ *=============================================**/
+
+const wgs84Projection = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// Signed area of a ring, positive when counter-clockwise
+func signedArea(ring [][]float64) float64 {
+	area := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// Shapefiles want outer rings clockwise and holes counter-clockwise
+func shapefileRing(ring [][]float64, outer bool) []shp.Point {
+	points := make([]shp.Point, 0, len(ring))
+	for _, pt := range ring {
+		if len(pt) < 2 {
+			continue
+		}
+		points = append(points, shp.Point{X: pt[0], Y: pt[1]})
+	}
+
+	area := signedArea(ring)
+	if (outer && area > 0) || (!outer && area < 0) {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	return points
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// Writes the features as a zipped shapefile with .shp, .shx, .dbf and .prj
+func encodeShapefile(collection geojson.FeatureCollection) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "siren-shapefile")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "siren_alerts")
+	writer, err := shp.Create(base+".shp", shp.POLYGON)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []shp.Field{
+		shp.StringField("ID", 64),
+		shp.StringField("EVENT", 64),
+		shp.StringField("SEVERITY", 16),
+		shp.StringField("COLOR", 8),
+	}
+	if err := writer.SetFields(fields); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	row := 0
+	for _, feature := range collection.Features {
+		if feature.Geometry == nil || !feature.Geometry.IsMultiPolygon() {
+			continue
+		}
+
+		var parts [][]shp.Point
+		for _, polygon := range feature.Geometry.MultiPolygon {
+			for i, ring := range polygon {
+				parts = append(parts, shapefileRing(ring, i == 0))
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		writer.Write((*shp.Polygon)(shp.NewPolyLine(parts)))
+		for i, key := range []string{"id", "event", "severity", "color"} {
+			if err := writer.WriteAttribute(row, i, truncate(propertyString(feature, key), int(fields[i].Size))); err != nil {
+				writer.Close()
+				return nil, err
+			}
+		}
+		row++
+	}
+	writer.Close()
+
+	// go-shp names the attribute file "<base>dbf", put the dot back
+	if _, err := os.Stat(base + "dbf"); err == nil {
+		if err := os.Rename(base+"dbf", base+".dbf"); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(base+".prj", []byte(wgs84Projection), 0644); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, ext := range []string{".shp", ".shx", ".dbf", ".prj"} {
+		data, err := os.ReadFile(base + ext)
+		if err != nil {
+			return nil, err
+		}
+		w, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     "siren_alerts" + ext,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
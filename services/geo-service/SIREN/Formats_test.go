@@ -0,0 +1,48 @@
+package SIREN
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   OutputFormat
+		fails  bool
+	}{
+		{name: "nothing asked for", want: FormatMsgPack},
+		{name: "query wins", query: "KML", accept: "application/geo+json", want: FormatKML},
+		{name: "unknown query", query: "svg", fails: true},
+		{name: "single type", accept: "application/geo+json", want: FormatGeoJSON},
+		{name: "listed order on a tie", accept: "application/vnd.google-earth.kml+xml, application/geo+json", want: FormatKML},
+		{name: "higher q listed last", accept: "application/json;q=0.1, application/geo+json", want: FormatGeoJSON},
+		{name: "q with spaces and parameters", accept: "application/json; charset=utf-8; q=0.5, application/zip ; q = 0.8", want: FormatShapefile},
+		{name: "exact type before a wildcard", accept: "*/*, application/geo+json", want: FormatGeoJSON},
+		{name: "wildcard with a higher q", accept: "application/geo+json;q=0.5, */*", want: FormatMsgPack},
+		{name: "unknown types skipped", accept: "text/html, application/xhtml+xml, application/geo+json;q=0.9", want: FormatGeoJSON},
+		{name: "q=0 is not acceptable", accept: "application/geo+json;q=0", fails: true},
+		{name: "q=0 wildcard", accept: "*/*;q=0", fails: true},
+		{name: "wildcard skips a refused format", accept: "application/msgpack;q=0, */*", want: FormatTopoJSON},
+		{name: "refused media type leaves its alias", accept: "application/json;q=0, application/topo+json", want: FormatTopoJSON},
+		{name: "bad q", accept: "application/geo+json;q=high, application/zip;q=0.1", want: FormatShapefile},
+		{name: "nothing supported", accept: "text/html", fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := NegotiateFormat(tt.query, tt.accept)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("got %q, want an error", format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.want {
+				t.Errorf("got %q, want %q", format, tt.want)
+			}
+		})
+	}
+}
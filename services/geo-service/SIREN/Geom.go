@@ -10,30 +10,47 @@ import (
 )
 
 type UGC struct {
-	UGC   string  `msgpack:"UGC"`
-	Lat   float64 `msgpack:"lat"`
-	Lon   float64 `msgpack:"lon"`
-	Name  string  `msgpack:"name"`
-	State string  `msgpack:"state"`
-	// This is either a [][2]float64 or a [][][2]float64
-	Feature any `msgpack:"feature"`
+	UGC      string   `msgpack:"UGC"`
+	Lat      float64  `msgpack:"lat"`
+	Lon      float64  `msgpack:"lon"`
+	Name     string   `msgpack:"name"`
+	State    string   `msgpack:"state"`
+	Geometry Geometry `msgpack:"geometry"`
 }
 
 type AbstractGeom = polygol.Geom // This is a [][][][]float64
 
 // UnionPolygons takes a slice of polygons and multipolygons and returns their union as a multipolygon.
-func UnionPolygons(geoms []AbstractGeom) AbstractGeom {
+func UnionPolygons(geoms []AbstractGeom) (AbstractGeom, error) {
+	if len(geoms) == 0 {
+		return nil, fmt.Errorf("nothing to union")
+	}
 	res, err := polygol.Union(geoms[0], geoms[1:]...)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("union failed: %w", err)
 	}
 	if len(res) == 0 {
-		return nil
+		return nil, fmt.Errorf("union is empty")
 	}
-	return res
+	return res, nil
 }
 
+// SimplifyMultiPolygon simplifies every ring with Douglas-Peucker, tolerance is in degrees.
+// A tolerance of 0 keeps the full detail. The result is repaired since simplifying
+// can fold a ring over itself.
 func SimplifyMultiPolygon(geom AbstractGeom, tolerance float64) AbstractGeom {
+	if tolerance <= 0 {
+		return RepairMultiPolygon(geom)
+	}
+
+	simplified := orb.Simplifier.MultiPolygon(simplify.DouglasPeucker(tolerance), toOrbMultiPolygon(geom))
+	if len(simplified) == 0 {
+		return nil
+	}
+	return RepairMultiPolygon(fromOrbMultiPolygon(simplified))
+}
+
+func toOrbMultiPolygon(geom AbstractGeom) orb.MultiPolygon {
 	var mp orb.MultiPolygon
 	for _, polygon := range geom {
 		var poly orb.Polygon
@@ -49,16 +66,12 @@ func SimplifyMultiPolygon(geom AbstractGeom, tolerance float64) AbstractGeom {
 		}
 		mp = append(mp, poly)
 	}
+	return mp
+}
 
-	simplified := orb.Simplifier.MultiPolygon(simplify.VisvalingamThreshold(0.0005), mp)
-	if len(simplified) == 0 {
-		return nil
-	}
-
-	// Convert back to the original format
-
+func fromOrbMultiPolygon(mp orb.MultiPolygon) AbstractGeom {
 	var converted AbstractGeom
-	for _, polygon := range simplified {
+	for _, polygon := range mp {
 		var newPolygon [][][]float64
 		for _, ring := range polygon {
 			var newRing [][]float64
@@ -69,58 +82,9 @@ func SimplifyMultiPolygon(geom AbstractGeom, tolerance float64) AbstractGeom {
 		}
 		converted = append(converted, newPolygon)
 	}
-
 	return converted
 }
 
-// ConvertToPolygon attempts to convert an interface{} into a simple polygon,
-// which is defined as a slice of [2]float64.
-func ConvertToPolygon(feature any) ([][2]float64, error) {
-	arr, ok := feature.([]any)
-	if !ok {
-		return nil, fmt.Errorf("expected []interface{} for polygon")
-	}
-
-	var polygon [][2]float64
-	for _, point := range arr {
-		ptArr, ok := point.([]any)
-		if !ok || len(ptArr) != 2 {
-			return nil, fmt.Errorf("each point must be a slice of 2 items")
-		}
-
-		var pt [2]float64
-		for j, val := range ptArr {
-			f, ok := val.(float64)
-			if !ok {
-				return nil, fmt.Errorf("point coordinates must be float64")
-			}
-			pt[j] = f
-		}
-		polygon = append(polygon, pt)
-	}
-	return polygon, nil
-}
-
-// ConvertToMultiPolygon converts the feature into a multipolygon,
-// defined as a slice of simple polygons.
-func ConvertToMultiPolygon(feature any) ([][][2]float64, error) {
-	arr, ok := feature.([]any)
-	if !ok {
-		return nil, fmt.Errorf("expected []interface{} for multipolygon")
-	}
-
-	var multiPolygon [][][2]float64
-	for _, poly := range arr {
-		// Each polygon should be convertible with convertToPolygon.
-		polygon, err := ConvertToPolygon(poly)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert inner polygon: %w", err)
-		}
-		multiPolygon = append(multiPolygon, polygon)
-	}
-	return multiPolygon, nil
-}
-
 func CreateGeoJSON(geometry []AlertGeometry) geojson.FeatureCollection {
 	collection := geojson.NewFeatureCollection()
 
@@ -132,6 +96,16 @@ func CreateGeoJSON(geometry []AlertGeometry) geojson.FeatureCollection {
 		if feature.Properties["color"] == "" {
 			feature.Properties["color"] = "#EFEFEF"
 		}
+		if geom.Event != "" {
+			feature.Properties["event"] = geom.Event
+		}
+		if geom.Severity != "" {
+			feature.Properties["severity"] = geom.Severity
+		}
+		// Lets clients tell an alert is drawn without some of its areas
+		if len(geom.FailedAreas) > 0 {
+			feature.Properties["failedAreas"] = geom.FailedAreas
+		}
 
 		collection.AddFeature(feature)
 	}
@@ -0,0 +1,295 @@
+package SIREN
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SchemaVersion is the layout of the UGC records in a store. Version 1 stores kept the
+// feature as untyped nested lists, version 2 stores keep a typed Geometry.
+const SchemaVersion = 2
+
+type GeometryType string
+
+const (
+	GeometryPolygon      GeometryType = "Polygon"
+	GeometryMultiPolygon GeometryType = "MultiPolygon"
+)
+
+var ErrUnknownShape = errors.New("unknown geometry shape")
+
+// A closed ring of lon/lat points
+type Ring [][2]float64
+
+type Polygon struct {
+	Exterior Ring   `msgpack:"exterior"`
+	Holes    []Ring `msgpack:"holes,omitempty"`
+}
+
+// Geometry is the shape of a UGC area as it is kept in the stores
+type Geometry struct {
+	Type     GeometryType `msgpack:"type"`
+	Polygons []Polygon    `msgpack:"polygons"`
+}
+
+// Check fails on anything that isn't a shape we know how to draw. It only looks at the
+// structure, problems with the rings themselves are for ValidateGeometry to repair.
+func (g Geometry) Check() error {
+	switch g.Type {
+	case GeometryPolygon:
+		if len(g.Polygons) != 1 {
+			return fmt.Errorf("%w: Polygon with %d polygons", ErrUnknownShape, len(g.Polygons))
+		}
+	case GeometryMultiPolygon:
+		if len(g.Polygons) == 0 {
+			return fmt.Errorf("%w: MultiPolygon with no polygons", ErrUnknownShape)
+		}
+	case "":
+		return fmt.Errorf("%w: geometry has no type", ErrUnknownShape)
+	default:
+		return fmt.Errorf("%w: geometry type %q", ErrUnknownShape, g.Type)
+	}
+
+	for i, polygon := range g.Polygons {
+		if len(polygon.Exterior) == 0 {
+			return fmt.Errorf("%w: polygon %d has no exterior ring", ErrUnknownShape, i)
+		}
+	}
+	return nil
+}
+
+// Abstract converts the geometry to the nested lists used for unions
+func (g Geometry) Abstract() AbstractGeom {
+	geom := make(AbstractGeom, 0, len(g.Polygons))
+	for _, polygon := range g.Polygons {
+		rings := make([][][]float64, 0, len(polygon.Holes)+1)
+		rings = append(rings, polygon.Exterior.floats())
+		for _, hole := range polygon.Holes {
+			rings = append(rings, hole.floats())
+		}
+		geom = append(geom, rings)
+	}
+	return geom
+}
+
+func (r Ring) floats() [][]float64 {
+	converted := make([][]float64, len(r))
+	for i, pt := range r {
+		converted[i] = []float64{pt[0], pt[1]}
+	}
+	return converted
+}
+
+func ringFromFloats(ring [][]float64) Ring {
+	converted := make(Ring, len(ring))
+	for i, pt := range ring {
+		converted[i] = [2]float64{pt[0], pt[1]}
+	}
+	return converted
+}
+
+// GeometryFromRings builds a geometry from rings that don't say whether they are holes,
+// like the parts of a shapefile polygon. Rings inside an odd number of other rings are
+// holes of the smallest ring around them, everything else is an exterior. The rings are
+// wound the GeoJSON way, shapefiles wind them the other way around.
+func GeometryFromRings(rings []Ring) (Geometry, error) {
+	var usable [][][]float64
+	for _, ring := range rings {
+		if len(ring) >= 3 {
+			usable = append(usable, ring.floats())
+		}
+	}
+	if len(usable) == 0 {
+		return Geometry{}, fmt.Errorf("%w: no rings with at least 3 points", ErrUnknownShape)
+	}
+
+	sort.SliceStable(usable, func(i, j int) bool {
+		return math.Abs(signedArea(usable[i])) > math.Abs(signedArea(usable[j]))
+	})
+
+	depth := make([]int, len(usable))
+	parent := make([]int, len(usable))
+	for i := range usable {
+		parent[i] = -1
+		// Only a larger ring can contain this one, the smallest container is found last
+		for j := 0; j < i; j++ {
+			if ringContainsRing(usable[j], usable[i]) {
+				depth[i]++
+				parent[i] = j
+			}
+		}
+	}
+
+	polygonOf := make(map[int]int)
+	var geometry Geometry
+	for i, ring := range usable {
+		if depth[i]%2 == 0 {
+			polygonOf[i] = len(geometry.Polygons)
+			geometry.Polygons = append(geometry.Polygons, Polygon{Exterior: ringFromFloats(orientRing(ring, true))})
+		}
+	}
+	for i, ring := range usable {
+		if depth[i]%2 == 1 {
+			p := polygonOf[parent[i]]
+			geometry.Polygons[p].Holes = append(geometry.Polygons[p].Holes, ringFromFloats(orientRing(ring, false)))
+		}
+	}
+
+	geometry.Type = GeometryMultiPolygon
+	if len(geometry.Polygons) == 1 {
+		geometry.Type = GeometryPolygon
+	}
+	return geometry, nil
+}
+
+// Whether outer contains inner, tested with the first vertex of inner that isn't on outer
+func ringContainsRing(outer [][]float64, inner [][]float64) bool {
+	for _, pt := range inner {
+		inside, onBoundary := pointInRing(pt, outer)
+		if !onBoundary {
+			return inside
+		}
+	}
+	return false
+}
+
+// Ray casting, also reporting if the point is one of the ring's vertices
+func pointInRing(pt []float64, ring [][]float64) (bool, bool) {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if samePoint(a, pt) {
+			return false, true
+		}
+		if (a[1] > pt[1]) != (b[1] > pt[1]) && pt[0] < (b[0]-a[0])*(pt[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside, false
+}
+
+/**============================================
+ *             Legacy Feature Decoding
+ *=============================================**/
+
+// DecodeLegacyFeature converts the untyped feature of a version 1 store. Features were
+// stored as a ring, a list of rings or a list of polygons, and MsgPack may hand back
+// any numeric type for the coordinates.
+func DecodeLegacyFeature(feature any) (Geometry, error) {
+	var rings []Ring
+	switch f := feature.(type) {
+	case [][2]float64:
+		rings = []Ring{f}
+	case [][][2]float64:
+		for _, ring := range f {
+			rings = append(rings, ring)
+		}
+	case []any:
+		decoded, err := decodeNested(f, shapeDepth(f))
+		if err != nil {
+			return Geometry{}, err
+		}
+		rings = decoded
+	case nil:
+		return Geometry{}, fmt.Errorf("%w: feature is empty", ErrUnknownShape)
+	default:
+		return Geometry{}, fmt.Errorf("%w: %T", ErrUnknownShape, feature)
+	}
+	return GeometryFromRings(rings)
+}
+
+// How many levels of lists are above the coordinates, 1 for a point, 2 for a ring and so on
+func shapeDepth(v any) int {
+	depth := 0
+	for {
+		arr, ok := v.([]any)
+		if !ok {
+			if _, isNumber := toFloat(v); isNumber {
+				return depth
+			}
+			return -1
+		}
+		if len(arr) == 0 {
+			return -1
+		}
+		depth++
+		v = arr[0]
+	}
+}
+
+func decodeNested(arr []any, depth int) ([]Ring, error) {
+	switch depth {
+	case 2:
+		ring, err := decodeRing(arr)
+		if err != nil {
+			return nil, err
+		}
+		return []Ring{ring}, nil
+	case 3, 4:
+		var rings []Ring
+		for _, item := range arr {
+			inner, ok := item.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: expected a list, got %T", ErrUnknownShape, item)
+			}
+			decoded, err := decodeNested(inner, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			rings = append(rings, decoded...)
+		}
+		return rings, nil
+	default:
+		return nil, fmt.Errorf("%w: nesting depth %d", ErrUnknownShape, depth)
+	}
+}
+
+func decodeRing(arr []any) (Ring, error) {
+	ring := make(Ring, 0, len(arr))
+	for _, item := range arr {
+		point, ok := item.([]any)
+		if !ok || len(point) < 2 {
+			return nil, fmt.Errorf("%w: point must be a list of at least 2 numbers", ErrUnknownShape)
+		}
+		// Anything past the longitude and latitude, like elevation, is ignored
+		x, okX := toFloat(point[0])
+		y, okY := toFloat(point[1])
+		if !okX || !okY {
+			return nil, fmt.Errorf("%w: coordinates must be numbers", ErrUnknownShape)
+		}
+		ring = append(ring, [2]float64{x, y})
+	}
+	return ring, nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
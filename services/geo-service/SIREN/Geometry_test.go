@@ -0,0 +1,291 @@
+package SIREN
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+	"go.etcd.io/bbolt"
+)
+
+// Sends the feature through MsgPack so it comes back with the types a version 1 store decodes to
+func throughMsgPack(t *testing.T, feature any) any {
+	t.Helper()
+	data, err := msgpack.Marshal(feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded any
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func pointList(ring Ring) []any {
+	points := make([]any, len(ring))
+	for i, pt := range ring {
+		points[i] = []any{pt[0], pt[1]}
+	}
+	return points
+}
+
+var (
+	// Around Polk County, IA, with small integer corners so MsgPack packs them as ints
+	county = []any{[]any{-94, 41}, []any{int16(-93), uint8(41)}, []any{float32(-93), 42.0}, []any{-94.0, int64(42)}, []any{-94, 41}}
+	lake   = Ring{{-93.8, 41.2}, {-93.8, 41.8}, {-93.2, 41.8}, {-93.2, 41.2}, {-93.8, 41.2}}
+	island = Ring{{-93.6, 41.4}, {-93.4, 41.4}, {-93.4, 41.6}, {-93.6, 41.6}, {-93.6, 41.4}}
+	nearby = Ring{{-92, 41}, {-91, 41}, {-91, 42}, {-92, 42}, {-92, 41}}
+)
+
+func TestDecodeLegacyFeature(t *testing.T) {
+	tests := []struct {
+		name     string
+		feature  any
+		typ      GeometryType
+		holes    []int // Number of holes in each polygon
+		raw      bool  // Passed as is instead of through MsgPack
+		err      error
+		exterior Ring // Checked when set, the exterior of the first polygon
+	}{
+		{
+			name:     "ring with mixed numeric types",
+			feature:  county,
+			typ:      GeometryPolygon,
+			holes:    []int{0},
+			exterior: Ring{{-94, 41}, {-93, 41}, {-93, 42}, {-94, 42}, {-94, 41}},
+		},
+		{
+			name:    "ring with elevation",
+			feature: []any{[]any{-94, 41, 300}, []any{-93, 41, 310}, []any{-93, 42, 305}, []any{-94, 41, 300}},
+			typ:     GeometryPolygon,
+			holes:   []int{0},
+		},
+		{
+			name:    "ring list with a hole",
+			feature: []any{county, pointList(lake)},
+			typ:     GeometryPolygon,
+			holes:   []int{1},
+		},
+		{
+			name:    "ring list with the hole first",
+			feature: []any{pointList(lake), county},
+			typ:     GeometryPolygon,
+			holes:   []int{1},
+		},
+		{
+			name:    "ring list of separate areas",
+			feature: []any{county, pointList(nearby)},
+			typ:     GeometryMultiPolygon,
+			holes:   []int{0, 0},
+		},
+		{
+			name:    "island in a hole",
+			feature: []any{county, pointList(lake), pointList(island)},
+			typ:     GeometryMultiPolygon,
+			holes:   []int{1, 0},
+		},
+		{
+			name:    "polygon list",
+			feature: []any{[]any{county, pointList(lake)}, []any{pointList(nearby)}},
+			typ:     GeometryMultiPolygon,
+			holes:   []int{1, 0},
+		},
+		{
+			name:    "typed ring",
+			feature: [][2]float64(nearby),
+			raw:     true,
+			typ:     GeometryPolygon,
+			holes:   []int{0},
+		},
+		{
+			name:    "typed ring list",
+			feature: [][][2]float64{nearby, lake},
+			raw:     true,
+			typ:     GeometryMultiPolygon,
+			holes:   []int{0, 0},
+		},
+		{name: "nil", feature: nil, raw: true, err: ErrUnknownShape},
+		{name: "string", feature: "POLYGON((-94 41, -93 41, -93 42, -94 41))", err: ErrUnknownShape},
+		{name: "map", feature: map[string]any{"type": "Polygon"}, err: ErrUnknownShape},
+		{name: "empty list", feature: []any{}, err: ErrUnknownShape},
+		{name: "single point", feature: []any{-94, 41}, err: ErrUnknownShape},
+		{name: "too deeply nested", feature: []any{[]any{[]any{county}}}, err: ErrUnknownShape},
+		{name: "text coordinates", feature: []any{[]any{"-94", "41"}, []any{"-93", "41"}, []any{"-93", "42"}}, err: ErrUnknownShape},
+		{name: "point with one coordinate", feature: []any{[]any{-94}, []any{-93}, []any{-93}}, err: ErrUnknownShape},
+		{name: "mixed depths", feature: []any{county, []any{-94, 41}}, err: ErrUnknownShape},
+		{name: "ring too short", feature: []any{[]any{-94, 41}, []any{-93, 41}}, err: ErrUnknownShape},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feature := tt.feature
+			if !tt.raw {
+				feature = throughMsgPack(t, feature)
+			}
+			geometry, err := DecodeLegacyFeature(feature)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := geometry.Check(); err != nil {
+				t.Fatalf("decoded geometry doesn't check: %v", err)
+			}
+
+			if geometry.Type != tt.typ {
+				t.Errorf("type %s, want %s", geometry.Type, tt.typ)
+			}
+			holes := make([]int, len(geometry.Polygons))
+			for i, polygon := range geometry.Polygons {
+				holes[i] = len(polygon.Holes)
+				if signedArea(polygon.Exterior.floats()) <= 0 {
+					t.Errorf("polygon %d exterior is clockwise", i)
+				}
+				for _, hole := range polygon.Holes {
+					if signedArea(hole.floats()) >= 0 {
+						t.Errorf("polygon %d has a counter-clockwise hole", i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(holes, tt.holes) {
+				t.Errorf("holes per polygon %v, want %v", holes, tt.holes)
+			}
+			if tt.exterior != nil && !reflect.DeepEqual(geometry.Polygons[0].Exterior, tt.exterior) {
+				t.Errorf("exterior %v, want %v", geometry.Polygons[0].Exterior, tt.exterior)
+			}
+		})
+	}
+}
+
+func TestGeometryCheck(t *testing.T) {
+	polygon := Polygon{Exterior: nearby}
+	tests := []struct {
+		name     string
+		geometry Geometry
+		ok       bool
+	}{
+		{"polygon", Geometry{Type: GeometryPolygon, Polygons: []Polygon{polygon}}, true},
+		{"multipolygon", Geometry{Type: GeometryMultiPolygon, Polygons: []Polygon{polygon, polygon}}, true},
+		{"polygon with two parts", Geometry{Type: GeometryPolygon, Polygons: []Polygon{polygon, polygon}}, false},
+		{"empty multipolygon", Geometry{Type: GeometryMultiPolygon}, false},
+		{"no type", Geometry{Polygons: []Polygon{polygon}}, false},
+		{"line", Geometry{Type: "LineString", Polygons: []Polygon{polygon}}, false},
+		{"no exterior", Geometry{Type: GeometryPolygon, Polygons: []Polygon{{Holes: []Ring{lake}}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.geometry.Check()
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrUnknownShape) {
+				t.Errorf("err = %v, want %v", err, ErrUnknownShape)
+			}
+		})
+	}
+}
+
+// Writes a store the way it was before the loader, legacy records and no metadata
+func writeLegacyStore(t *testing.T, path string, records map[string]any) {
+	t.Helper()
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(DataBucket))
+		if err != nil {
+			return err
+		}
+		for ugc, feature := range records {
+			v, err := msgpack.Marshal(legacyUGC{UGC: ugc, Lat: 41.5, Lon: -93.5, Name: "Polk", State: "IA", Feature: feature})
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(ugc), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("current schema", func(t *testing.T) {
+		path := filepath.Join(dir, "current.db")
+		meta := StoreMetadata{Version: 4, ZoneType: ZoneCounty, Source: "c_05mr24", DatasetDate: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Records: 2}
+		features := []UGC{
+			{UGC: "IAC153", Lat: 41.7, Lon: -93.6, Name: "Polk", State: "IA", Geometry: Geometry{Type: GeometryPolygon, Polygons: []Polygon{{Exterior: nearby, Holes: []Ring{reversed(island)}}}}},
+			{UGC: "IAC169", Lat: 42.0, Lon: -93.5, Name: "Story", State: "IA", Geometry: Geometry{Type: GeometryMultiPolygon, Polygons: []Polygon{{Exterior: nearby}, {Exterior: lake}}}},
+		}
+		if err := WriteStore(path, meta, features); err != nil {
+			t.Fatal(err)
+		}
+
+		readMeta, readFeatures, err := ReadStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta.SchemaVersion = SchemaVersion
+		// MsgPack hands times back in the local zone
+		readMeta.DatasetDate, readMeta.BuiltAt = readMeta.DatasetDate.UTC(), readMeta.BuiltAt.UTC()
+		if !reflect.DeepEqual(readMeta, meta) {
+			t.Errorf("metadata %+v, want %+v", readMeta, meta)
+		}
+		if !reflect.DeepEqual(readFeatures, features) {
+			t.Errorf("features %+v, want %+v", readFeatures, features)
+		}
+	})
+
+	t.Run("legacy schema", func(t *testing.T) {
+		path := filepath.Join(dir, "legacy.db")
+		writeLegacyStore(t, path, map[string]any{
+			"IAC153": []any{county, pointList(lake)},
+			"IAC999": "not a shape",
+		})
+
+		meta, features, err := ReadStore(path)
+		if meta.SchemaVersion != 0 {
+			t.Errorf("schema version %d for a store without metadata", meta.SchemaVersion)
+		}
+		if !errors.Is(err, ErrUnknownShape) {
+			t.Errorf("err = %v, want the bad feature reported", err)
+		}
+		if len(features) != 1 || features[0].UGC != "IAC153" || features[0].Name != "Polk" || len(features[0].Geometry.Polygons[0].Holes) != 1 {
+			t.Fatalf("features %+v, want IAC153 with its hole", features)
+		}
+
+		// Written back with the current schema it reads the same
+		migrated := filepath.Join(dir, "migrated.db")
+		if err := WriteStore(migrated, StoreMetadata{ZoneType: ZoneCounty}, features); err != nil {
+			t.Fatal(err)
+		}
+		meta, again, err := ReadStore(migrated)
+		if err != nil || meta.SchemaVersion != SchemaVersion || !reflect.DeepEqual(again, features) {
+			t.Errorf("migrated store read back as schema %d %+v, err %v", meta.SchemaVersion, again, err)
+		}
+	})
+
+	t.Run("invalid geometry is not written", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.db")
+		err := WriteStore(path, StoreMetadata{}, []UGC{{UGC: "IAC153", Geometry: Geometry{Type: "Point"}}})
+		if !errors.Is(err, ErrUnknownShape) {
+			t.Errorf("err = %v, want %v", err, ErrUnknownShape)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package SIREN
+
+import (
+	"errors"
+	"fmt"
+	"geoService/NWS"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+const metersPerSecondPerKnot = 1852.0 / 3600.0
+
+// How wide the projected path is on each side of the storm's track, in meters
+var SwathRadius = 8000.0
+
+var ErrNoMotion = errors.New("alert has no storm motion")
+
+// StormMotion is the TIME...MOT...LOC line of a warning, the storm's position at a time and
+// the direction it is moving from
+type StormMotion struct {
+	Time          time.Time   `json:"time"`
+	DirectionFrom float64     `json:"directionFrom"` // Degrees the storm is moving from
+	SpeedKnots    float64     `json:"speedKnots"`
+	Locations     []orb.Point `json:"locations"` // A single cell, or several points along a line of storms
+}
+
+// ParseMotion reads the CAP eventMotionDescription parameter. The direction and speed may
+// still carry their units, e.g. 239DEG and 28KT.
+func ParseMotion(description *NWS.EventMotionDescription) (StormMotion, error) {
+	if description == nil || len(description.Location) == 0 {
+		return StormMotion{}, ErrNoMotion
+	}
+
+	direction, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(description.Direction), "DEG"), 64)
+	if err != nil {
+		return StormMotion{}, fmt.Errorf("invalid storm motion direction %q", description.Direction)
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(description.Speed), "KT"), 64)
+	if err != nil {
+		return StormMotion{}, fmt.Errorf("invalid storm motion speed %q", description.Speed)
+	}
+
+	motion := StormMotion{
+		Time:          description.Timestamp,
+		DirectionFrom: math.Mod(direction, 360),
+		SpeedKnots:    speed,
+	}
+	for _, location := range description.Location {
+		motion.Locations = append(motion.Locations, orb.Point{location.Lon, location.Lat})
+	}
+	return motion, nil
+}
+
+// The bearing the storm is heading towards
+func (m StormMotion) Heading() float64 {
+	return math.Mod(m.DirectionFrom+180, 360)
+}
+
+func (m StormMotion) metersPerSecond() float64 {
+	return m.SpeedKnots * metersPerSecondPerKnot
+}
+
+// PositionAt projects the storm's locations forward, or back, to the time
+func (m StormMotion) PositionAt(t time.Time) []orb.Point {
+	distance := m.metersPerSecond() * t.Sub(m.Time).Seconds()
+	positions := make([]orb.Point, 0, len(m.Locations))
+	for _, location := range m.Locations {
+		positions = append(positions, geo.PointAtBearingAndDistance(location, m.Heading(), distance))
+	}
+	return positions
+}
+
+// Swath is the area the storm is projected to cross until the time, widened by SwathRadius
+// with rounded ends. A line of storms also sweeps the ground between its locations.
+func (m StormMotion) Swath(until time.Time) (AbstractGeom, error) {
+	if !until.After(m.Time) {
+		return nil, fmt.Errorf("storm motion at %s is after %s", m.Time.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+
+	ends := m.PositionAt(until)
+	parts := make([]AbstractGeom, 0, 4*len(m.Locations))
+	for i, start := range m.Locations {
+		parts = append(parts, capsule(start, ends[i]))
+	}
+	for i := 1; i < len(m.Locations); i++ {
+		a, b := m.Locations[i-1], m.Locations[i]
+		sweep := [][]float64{{a[0], a[1]}, {b[0], b[1]}, {ends[i][0], ends[i][1]}, {ends[i-1][0], ends[i-1][1]}, {a[0], a[1]}}
+		parts = append(parts, AbstractGeom{[][][]float64{sweep}}, capsule(a, b), capsule(ends[i-1], ends[i]))
+	}
+	return UnionPolygons(parts)
+}
+
+// The area within SwathRadius of the segment from a to b
+func capsule(a orb.Point, b orb.Point) AbstractGeom {
+	bearing := geo.Bearing(a, b)
+	var ring [][]float64
+	// Around the far end, then back around the start
+	for step := 0; step <= 12; step++ {
+		p := geo.PointAtBearingAndDistance(b, bearing-90+float64(step)*15, SwathRadius)
+		ring = append(ring, []float64{p[0], p[1]})
+	}
+	for step := 0; step <= 12; step++ {
+		p := geo.PointAtBearingAndDistance(a, bearing+90+float64(step)*15, SwathRadius)
+		ring = append(ring, []float64{p[0], p[1]})
+	}
+	ring = append(ring, ring[0])
+	return AbstractGeom{[][][]float64{ring}}
+}
+
+// ArrivalWindow is when the storm is projected to pass within SwathRadius of a point
+type ArrivalWindow struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	ClosestMeters float64   `json:"closestMeters"` // How close the track passes to the point
+}
+
+// Arrival finds when the storm passes the point between the motion time and until, false
+// if its track misses the point or it has already passed. A line of storms reaches the point
+// when any part of the line between its locations does.
+func (m StormMotion) Arrival(point orb.Point, now time.Time, until time.Time) (ArrivalWindow, bool) {
+	speed := m.metersPerSecond()
+
+	// Each location in meters from the point, along the heading and across it
+	heading := m.Heading()
+	offsets := make([][2]float64, 0, len(m.Locations))
+	for _, location := range m.Locations {
+		distance := geo.Distance(point, location)
+		angle := (geo.Bearing(point, location) - heading) * math.Pi / 180
+		offsets = append(offsets, [2]float64{distance * math.Cos(angle), distance * math.Sin(angle)})
+	}
+	segments := make([][2][2]float64, 0, len(offsets))
+	if len(offsets) == 1 {
+		segments = append(segments, [2][2]float64{offsets[0], offsets[0]})
+	}
+	for i := 1; i < len(offsets); i++ {
+		segments = append(segments, [2][2]float64{offsets[i-1], offsets[i]})
+	}
+
+	var best ArrivalWindow
+	found := false
+	for _, segment := range segments {
+		pass, ok := passOver(segment[0], segment[1], speed)
+		if !ok {
+			continue
+		}
+		start, end := m.Time, until
+		if speed > 0 {
+			start = m.Time.Add(time.Duration(pass.start * float64(time.Second)))
+			end = m.Time.Add(time.Duration(pass.end * float64(time.Second)))
+		}
+		if end.Before(now) || start.After(until) {
+			continue
+		}
+		if end.After(until) {
+			end = until
+		}
+
+		if !found || start.Before(best.Start) {
+			best = ArrivalWindow{Start: start, End: end, ClosestMeters: pass.closest}
+			found = true
+		}
+	}
+	return best, found
+}
+
+// When a segment of the storm covers the point, in seconds after the motion time
+type pass struct {
+	start   float64
+	end     float64
+	closest float64 // Meters across the track
+}
+
+// passOver finds when the segment from p to q, moving along the heading at speed, passes
+// within SwathRadius of the point at the origin. A stationary segment passes if it is over
+// the point already.
+func passOver(p [2]float64, q [2]float64, speed float64) (pass, bool) {
+	// Parts of the segment further across the track than SwathRadius never reach the point
+	lo, hi := 0.0, 1.0
+	if p[1] == q[1] {
+		if math.Abs(p[1]) > SwathRadius {
+			return pass{}, false
+		}
+	} else {
+		s1 := (-SwathRadius - p[1]) / (q[1] - p[1])
+		s2 := (SwathRadius - p[1]) / (q[1] - p[1])
+		lo, hi = max(lo, min(s1, s2)), min(hi, max(s1, s2))
+		if lo > hi {
+			return pass{}, false
+		}
+	}
+
+	along := func(s float64) float64 { return p[0] + s*(q[0]-p[0]) }
+	across := func(s float64) float64 { return p[1] + s*(q[1]-p[1]) }
+	// Half the chord of the SwathRadius circle around the point at that distance across
+	halfChord := func(s float64) float64 {
+		return math.Sqrt(max(0, SwathRadius*SwathRadius-across(s)*across(s)))
+	}
+
+	closest := min(math.Abs(across(lo)), math.Abs(across(hi)))
+	if (across(lo) < 0) != (across(hi) < 0) {
+		closest = 0
+	}
+
+	if speed <= 0 {
+		distance := math.Sqrt(minimize(func(s float64) float64 { return along(s)*along(s) + across(s)*across(s) }, lo, hi))
+		return pass{closest: closest}, distance <= SwathRadius
+	}
+
+	// Each part of the segment covers the point from when it is half a chord short of it
+	// until it is half a chord past. The first part in is a convex minimum, the last out a
+	// concave maximum.
+	start := minimize(func(s float64) float64 { return -along(s) - halfChord(s) }, lo, hi)
+	end := -minimize(func(s float64) float64 { return along(s) - halfChord(s) }, lo, hi)
+	return pass{start: start / speed, end: end / speed, closest: closest}, true
+}
+
+// Finds the minimum of a convex function on [lo, hi]
+func minimize(f func(float64) float64, lo float64, hi float64) float64 {
+	for range 64 {
+		m1 := lo + (hi-lo)/3
+		m2 := hi - (hi-lo)/3
+		if f(m1) < f(m2) {
+			hi = m2
+		} else {
+			lo = m1
+		}
+	}
+	return f((lo + hi) / 2)
+}
+
+// Summary phrases the window for a push, e.g. arriving near you in ~12 minutes
+func (w ArrivalWindow) Summary(now time.Time) string {
+	if !w.Start.After(now) {
+		return "over or near you now"
+	}
+	minutes := int(math.Round(w.Start.Sub(now).Minutes()))
+	if minutes <= 1 {
+		return "arriving near you in ~1 minute"
+	}
+	return fmt.Sprintf("arriving near you in ~%d minutes", minutes)
+}
@@ -0,0 +1,255 @@
+package SIREN
+
+import (
+	"errors"
+	"geoService/NWS"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// A storm over Des Moines moving east at 30 knots
+var (
+	motionTime = time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	desMoines  = orb.Point{-93.6, 41.6}
+	eastward   = StormMotion{Time: motionTime, DirectionFrom: 270, SpeedKnots: 30, Locations: []orb.Point{desMoines}}
+)
+
+func metersPerSecond(knots float64) float64 {
+	return knots * 1852 / 3600
+}
+
+// The point the distance ahead of the start along the track, then the offset to its left
+func aheadOf(start orb.Point, ahead float64, left float64) orb.Point {
+	p := geo.PointAtBearingAndDistance(start, 90, ahead)
+	return geo.PointAtBearingAndDistance(p, 0, left)
+}
+
+func TestParseMotion(t *testing.T) {
+	tests := []struct {
+		name        string
+		description *NWS.EventMotionDescription
+		want        StormMotion
+		err         error
+	}{
+		{
+			name:        "units",
+			description: &NWS.EventMotionDescription{Timestamp: motionTime, Direction: "239DEG", Speed: "28KT", Location: []NWS.Coordinate{{Lat: 41.6, Lon: -93.6}}},
+			want:        StormMotion{Time: motionTime, DirectionFrom: 239, SpeedKnots: 28, Locations: []orb.Point{desMoines}},
+		},
+		{
+			name:        "line of storms",
+			description: &NWS.EventMotionDescription{Timestamp: motionTime, Direction: "270", Speed: "0", Location: []NWS.Coordinate{{Lat: 41, Lon: -94}, {Lat: 42, Lon: -94}}},
+			want:        StormMotion{Time: motionTime, DirectionFrom: 270, Locations: []orb.Point{{-94, 41}, {-94, 42}}},
+		},
+		{name: "none", description: nil, err: ErrNoMotion},
+		{name: "no location", description: &NWS.EventMotionDescription{Direction: "270", Speed: "30"}, err: ErrNoMotion},
+		{name: "bad speed", description: &NWS.EventMotionDescription{Direction: "270", Speed: "fast", Location: []NWS.Coordinate{{Lat: 41, Lon: -94}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			motion, err := ParseMotion(tt.description)
+			if tt.err != nil || tt.want.Locations == nil {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !motion.Time.Equal(tt.want.Time) || motion.DirectionFrom != tt.want.DirectionFrom || motion.SpeedKnots != tt.want.SpeedKnots || !orb.Equal(orb.MultiPoint(motion.Locations), orb.MultiPoint(tt.want.Locations)) {
+				t.Errorf("got %+v, want %+v", motion, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwath(t *testing.T) {
+	until := motionTime.Add(30 * time.Minute)
+	travelled := metersPerSecond(30) * 30 * 60
+
+	t.Run("single cell", func(t *testing.T) {
+		swath, err := eastward.Swath(until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		polygons := toOrbMultiPolygon(swath)
+		inside := map[string]orb.Point{
+			"start":            desMoines,
+			"halfway":          aheadOf(desMoines, travelled/2, 0),
+			"end":              eastward.PositionAt(until)[0],
+			"beside the track": aheadOf(desMoines, travelled/2, SwathRadius-500),
+			"behind the start": aheadOf(desMoines, -SwathRadius+500, 0),
+			"past the end":     aheadOf(desMoines, travelled+SwathRadius-500, 0),
+		}
+		for name, pt := range inside {
+			if !planar.MultiPolygonContains(polygons, pt) {
+				t.Errorf("the %s isn't in the swath", name)
+			}
+		}
+		outside := map[string]orb.Point{
+			"off the track":     aheadOf(desMoines, travelled/2, SwathRadius+1000),
+			"well past the end": aheadOf(desMoines, travelled+SwathRadius+1000, 0),
+			"upstream":          aheadOf(desMoines, -SwathRadius-1000, 0),
+		}
+		for name, pt := range outside {
+			if planar.MultiPolygonContains(polygons, pt) {
+				t.Errorf("the point %s is in the swath", name)
+			}
+		}
+
+		// A rectangle along the track with a half circle on each end
+		want := travelled*2*SwathRadius + math.Pi*SwathRadius*SwathRadius
+		if area := geo.Area(polygons); math.Abs(area-want)/want > 0.02 {
+			t.Errorf("area %.0f m², want about %.0f", area, want)
+		}
+	})
+
+	t.Run("line of storms", func(t *testing.T) {
+		south, north := aheadOf(desMoines, 0, -20000), aheadOf(desMoines, 0, 20000)
+		line := StormMotion{Time: motionTime, DirectionFrom: 270, SpeedKnots: 30, Locations: []orb.Point{south, north}}
+		swath, err := line.Swath(until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		polygons := toOrbMultiPolygon(swath)
+		if len(polygons) != 1 {
+			t.Errorf("the swath is in %d parts, want 1", len(polygons))
+		}
+		// Between the cells the line sweeps the ground too
+		if !planar.MultiPolygonContains(polygons, aheadOf(desMoines, travelled/2, 0)) {
+			t.Error("the ground between the cells isn't in the swath")
+		}
+	})
+
+	t.Run("until before the motion", func(t *testing.T) {
+		if _, err := eastward.Swath(motionTime.Add(-time.Minute)); err == nil {
+			t.Error("a swath into the past was projected")
+		}
+	})
+}
+
+func TestArrival(t *testing.T) {
+	speed := metersPerSecond(30)
+	until := motionTime.Add(time.Hour)
+	seconds := func(s float64) time.Time { return motionTime.Add(time.Duration(s * float64(time.Second))) }
+	halfChord := func(across float64) float64 { return math.Sqrt(SwathRadius*SwathRadius - across*across) }
+
+	tests := []struct {
+		name    string
+		motion  StormMotion
+		point   orb.Point
+		now     time.Time
+		ok      bool
+		start   time.Time
+		end     time.Time
+		closest float64
+		summary string
+	}{
+		{
+			name:    "on the track",
+			motion:  eastward,
+			point:   aheadOf(desMoines, 20000, 0),
+			now:     motionTime,
+			ok:      true,
+			start:   seconds((20000 - SwathRadius) / speed),
+			end:     seconds((20000 + SwathRadius) / speed),
+			summary: "arriving near you in ~13 minutes",
+		},
+		{
+			name:    "beside the track",
+			motion:  eastward,
+			point:   aheadOf(desMoines, 20000, 5000),
+			now:     motionTime,
+			ok:      true,
+			start:   seconds((20000 - halfChord(5000)) / speed),
+			end:     seconds((20000 + halfChord(5000)) / speed),
+			closest: 5000,
+		},
+		{
+			name:   "off the track",
+			motion: eastward,
+			point:  aheadOf(desMoines, 20000, SwathRadius+2000),
+			now:    motionTime,
+		},
+		{
+			name:   "already passed",
+			motion: eastward,
+			point:  aheadOf(desMoines, -20000, 0),
+			now:    motionTime,
+		},
+		{
+			name:   "beyond until",
+			motion: eastward,
+			point:  aheadOf(desMoines, 200000, 0),
+			now:    motionTime,
+		},
+		{
+			name:    "overhead now",
+			motion:  eastward,
+			point:   aheadOf(desMoines, 20000, 0),
+			now:     seconds(20000 / speed),
+			ok:      true,
+			start:   seconds((20000 - SwathRadius) / speed),
+			end:     seconds((20000 + SwathRadius) / speed),
+			summary: "over or near you now",
+		},
+		{
+			name:    "between the cells of a line",
+			motion:  StormMotion{Time: motionTime, DirectionFrom: 270, SpeedKnots: 30, Locations: []orb.Point{aheadOf(desMoines, 0, -30000), aheadOf(desMoines, 0, 30000)}},
+			point:   aheadOf(desMoines, 20000, 0),
+			now:     motionTime,
+			ok:      true,
+			start:   seconds((20000 - SwathRadius) / speed),
+			end:     seconds((20000 + SwathRadius) / speed),
+			summary: "arriving near you in ~13 minutes",
+		},
+		{
+			name:   "stationary over the point",
+			motion: StormMotion{Time: motionTime, DirectionFrom: 270, Locations: []orb.Point{desMoines}},
+			point:  aheadOf(desMoines, 3000, 0),
+			now:    motionTime,
+			ok:     true,
+			start:  motionTime,
+			end:    until,
+		},
+		{
+			name:   "stationary away from the point",
+			motion: StormMotion{Time: motionTime, DirectionFrom: 270, Locations: []orb.Point{desMoines}},
+			point:  aheadOf(desMoines, 20000, 0),
+			now:    motionTime,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, ok := tt.motion.Arrival(tt.point, tt.now, until)
+			if ok != tt.ok {
+				t.Fatalf("arrives %v, want %v (%+v)", ok, tt.ok, window)
+			}
+			if !ok {
+				return
+			}
+			// The projection is on the sphere, allow a few seconds either way
+			if d := window.Start.Sub(tt.start); d.Abs() > 5*time.Second {
+				t.Errorf("starts %s, want %s", window.Start, tt.start)
+			}
+			if d := window.End.Sub(tt.end); d.Abs() > 5*time.Second {
+				t.Errorf("ends %s, want %s", window.End, tt.end)
+			}
+			// Offsets are measured in a flat frame at the point, which is off by tens of meters this far out
+			if math.Abs(window.ClosestMeters-tt.closest) > 100 {
+				t.Errorf("passes %.0fm from the point, want %.0fm", window.ClosestMeters, tt.closest)
+			}
+			if tt.summary != "" && window.Summary(tt.now) != tt.summary {
+				t.Errorf("summary %q, want %q", window.Summary(tt.now), tt.summary)
+			}
+		})
+	}
+}
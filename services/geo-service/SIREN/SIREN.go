@@ -17,12 +17,16 @@ type SirenAlert struct {
 	History            any        `bson:"history"`
 	Areas              []string   `bson:"areas"`
 	CapInfo            *NWS.Alert `bson:"capInfo,omitempty"`
+	TraceParent        string     `bson:"traceParent,omitempty"` // W3C traceparent of the CAP that last changed the alert
 }
 
 type AlertGeometry struct {
-	Identifier   string       `bson:"identifier"`
-	Coordinates  AbstractGeom `bson:"coordinates"`
-	GeometryType string       `bson:"geometryType"` // Should be "Polygon" or "MultiPolygon"
+	Identifier   string        `bson:"identifier" msgpack:"identifier"`
+	Coordinates  AbstractGeom  `bson:"coordinates" msgpack:"coordinates"`
+	GeometryType string        `bson:"geometryType" msgpack:"geometryType"` // Should be "Polygon" or "MultiPolygon"
+	Event        string        `bson:"event,omitempty" msgpack:"event,omitempty"`
+	Severity     string        `bson:"severity,omitempty" msgpack:"severity,omitempty"`
+	FailedAreas  []AreaFailure `bson:"failedAreas,omitempty" msgpack:"failedAreas,omitempty"`
 }
 
 type AlertKey struct {
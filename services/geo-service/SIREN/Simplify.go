@@ -0,0 +1,209 @@
+package SIREN
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/engelsjk/polygol"
+	geojson "github.com/paulmach/go.geojson"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/simplify"
+	"github.com/rubenv/topojson"
+)
+
+// How much detail the geometry endpoints return. Alert geometry is always computed
+// and cached at full detail, the other levels are simplified from it on request.
+type DetailLevel string
+
+const (
+	DetailFull     DetailLevel = "full"
+	DetailStandard DetailLevel = "standard"
+	DetailMedium   DetailLevel = "medium"
+	DetailCoarse   DetailLevel = "coarse"
+	// Each alert simplified on its own with Visvalingam, what the endpoints returned before
+	// the levels existed. Adjacent alerts can gap or overlap, so it has to be asked for.
+	DetailLegacy DetailLevel = "legacy"
+)
+
+var DetailLevels = []DetailLevel{DetailFull, DetailStandard, DetailMedium, DetailCoarse, DetailLegacy}
+
+// The level used when the request doesn't ask for one
+const DefaultDetail = DetailStandard
+
+// Douglas-Peucker tolerance of each level in degrees, roughly 100m for standard, 500m for
+// medium and 2km for coarse. Legacy is instead the Visvalingam area threshold.
+var DetailTolerances = map[DetailLevel]float64{
+	DetailFull:     0,
+	DetailStandard: 0.001,
+	DetailMedium:   0.005,
+	DetailCoarse:   0.02,
+	DetailLegacy:   0.0005,
+}
+
+func ParseDetailLevel(s string) (DetailLevel, error) {
+	if s == "" {
+		return DefaultDetail, nil
+	}
+	level := DetailLevel(strings.ToLower(s))
+	if _, ok := DetailTolerances[level]; !ok {
+		return "", fmt.Errorf("unknown detail level %q", s)
+	}
+	return level, nil
+}
+
+func (l DetailLevel) Tolerance() float64 {
+	return DetailTolerances[l]
+}
+
+// Simplify returns the collection at this level of detail
+func (l DetailLevel) Simplify(collection geojson.FeatureCollection) geojson.FeatureCollection {
+	if l == DetailLegacy {
+		return simplifyFeatures(collection, l.Tolerance())
+	}
+	return SimplifyCollection(collection, l.Tolerance())
+}
+
+// Simplifies each feature on its own with Visvalingam, the way alerts were simplified before the levels
+func simplifyFeatures(collection geojson.FeatureCollection, threshold float64) geojson.FeatureCollection {
+	if threshold <= 0 || len(collection.Features) == 0 {
+		return collection
+	}
+
+	result := geojson.NewFeatureCollection()
+	for _, feature := range collection.Features {
+		if feature.Geometry == nil || !feature.Geometry.IsMultiPolygon() {
+			result.AddFeature(feature)
+			continue
+		}
+		simplified := orb.Simplifier.MultiPolygon(simplify.VisvalingamThreshold(threshold), toOrbMultiPolygon(feature.Geometry.MultiPolygon))
+		repaired := RepairMultiPolygon(fromOrbMultiPolygon(simplified))
+		if len(repaired) == 0 {
+			// Too small to survive at this level
+			continue
+		}
+		simplifiedFeature := *feature
+		simplifiedFeature.Geometry = geojson.NewMultiPolygonGeometry(repaired...)
+		result.AddFeature(&simplifiedFeature)
+	}
+	return *result
+}
+
+// SimplifyCollection simplifies all the features together so the borders they share
+// stay shared. Each shared border is simplified once as a TopoJSON arc, which keeps
+// adjacent alerts from gapping or overlapping the way simplifying them one by one does.
+func SimplifyCollection(collection geojson.FeatureCollection, tolerance float64) geojson.FeatureCollection {
+	if tolerance <= 0 || len(collection.Features) == 0 {
+		return collection
+	}
+
+	topology := topojson.NewTopology(&collection, &topojson.TopologyOptions{
+		Simplify:           tolerance,
+		ReductionAlgorithm: topojson.DouglasPeucker,
+	})
+	simplified := topology.ToGeoJSON()
+
+	result := geojson.NewFeatureCollection()
+	for _, feature := range simplified.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+		// The topology turns multipolygons with a single part into polygons
+		var geom AbstractGeom
+		switch {
+		case feature.Geometry.IsMultiPolygon():
+			geom = feature.Geometry.MultiPolygon
+		case feature.Geometry.IsPolygon():
+			geom = AbstractGeom{feature.Geometry.Polygon}
+		default:
+			continue
+		}
+		repaired := RepairMultiPolygon(geom)
+		if len(repaired) == 0 {
+			// Too small to survive at this level
+			continue
+		}
+		feature.Geometry = geojson.NewMultiPolygonGeometry(repaired...)
+		result.AddFeature(feature)
+	}
+
+	// The topology keeps its objects in a map, put them back in a stable order
+	sort.SliceStable(result.Features, func(i, j int) bool {
+		a, _ := result.Features[i].Properties["id"].(string)
+		b, _ := result.Features[j].Properties["id"].(string)
+		return a < b
+	})
+	return *result
+}
+
+// RepairMultiPolygon closes rings, drops repeated points and rings with no area, and
+// resolves self-intersections and overlapping parts by unioning the result with itself.
+// Holes are dropped along with a polygon whose exterior ring is degenerate.
+func RepairMultiPolygon(geom AbstractGeom) AbstractGeom {
+	var cleaned AbstractGeom
+	for _, polygon := range geom {
+		var newPolygon [][][]float64
+		for r, ring := range polygon {
+			newRing := cleanRing(ring)
+			if newRing == nil {
+				if r == 0 {
+					break
+				}
+				continue
+			}
+			newPolygon = append(newPolygon, newRing)
+		}
+		if len(newPolygon) > 0 {
+			cleaned = append(cleaned, newPolygon)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+
+	repaired, err := polygol.Union(cleaned)
+	if err != nil || len(repaired) == 0 {
+		// Better to draw the cleaned rings than nothing at all
+		return cleaned
+	}
+	return repaired
+}
+
+// Returns the ring closed and without repeated points, or nil if it has no area
+func cleanRing(ring [][]float64) [][]float64 {
+	var newRing [][]float64
+	for _, pt := range ring {
+		if len(pt) < 2 {
+			continue
+		}
+		if len(newRing) > 0 && samePoint(newRing[len(newRing)-1], pt) {
+			continue
+		}
+		newRing = append(newRing, []float64{pt[0], pt[1]})
+	}
+	if len(newRing) > 0 && !samePoint(newRing[0], newRing[len(newRing)-1]) {
+		newRing = append(newRing, []float64{newRing[0][0], newRing[0][1]})
+	}
+
+	// A triangle is the smallest ring, which is 4 points once closed
+	if len(newRing) < 4 || collinear(newRing) {
+		return nil
+	}
+	return newRing
+}
+
+// Whether every point of the ring lies on one line, so it encloses nothing
+func collinear(ring [][]float64) bool {
+	origin, direction := ring[0], ring[1]
+	for _, pt := range ring[2:] {
+		cross := (direction[0]-origin[0])*(pt[1]-origin[1]) - (direction[1]-origin[1])*(pt[0]-origin[0])
+		if cross != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func samePoint(a []float64, b []float64) bool {
+	return a[0] == b[0] && a[1] == b[1]
+}
@@ -0,0 +1,69 @@
+package SIREN
+
+import (
+	"math"
+	"testing"
+
+	"github.com/engelsjk/polygol"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Two alerts sharing a jagged border, like neighbouring counties
+func neighbours() geojson.FeatureCollection {
+	var border [][]float64
+	for i := 0; i <= 40; i++ {
+		border = append(border, []float64{-93 + 0.0004*math.Sin(float64(i)), 41 + float64(i)*0.025})
+	}
+	west := [][]float64{{-94, 41}}
+	west = append(west, border...)
+	west = append(west, []float64{-94, 42}, []float64{-94, 41})
+	east := [][]float64{{-92, 41}, {-92, 42}}
+	for i := len(border) - 1; i >= 0; i-- {
+		east = append(east, border[i])
+	}
+	east = append(east, []float64{-92, 41})
+
+	collection := geojson.NewFeatureCollection()
+	collection.AddFeature(alertFeature("FFW-1", "Flash Flood Warning", [][][]float64{west}))
+	collection.AddFeature(alertFeature("FFW-2", "Flash Flood Warning", [][][]float64{east}))
+	return *collection
+}
+
+func multiPolygonArea(geom AbstractGeom) float64 {
+	var area float64
+	for _, polygon := range geom {
+		for _, ring := range polygon {
+			area += signedArea(ring)
+		}
+	}
+	return math.Abs(area)
+}
+
+func TestDefaultDetailKeepsSharedBorders(t *testing.T) {
+	for _, level := range []DetailLevel{DefaultDetail, DetailMedium, DetailCoarse} {
+		t.Run(string(level), func(t *testing.T) {
+			simplified := level.Simplify(neighbours())
+			if len(simplified.Features) != 2 {
+				t.Fatalf("got %d features, want 2", len(simplified.Features))
+			}
+			a := AbstractGeom(simplified.Features[0].Geometry.MultiPolygon)
+			b := AbstractGeom(simplified.Features[1].Geometry.MultiPolygon)
+			union, err := polygol.Union(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A gap or an overlap along the border makes the union differ from the sum
+			if mismatch := math.Abs(multiPolygonArea(union) - multiPolygonArea(a) - multiPolygonArea(b)); mismatch > 1e-9 {
+				t.Errorf("the shared border gapped or overlapped, area mismatch %g", mismatch)
+			}
+		})
+	}
+
+	// The per-feature simplification has to be asked for
+	if level, _ := ParseDetailLevel(""); level != DefaultDetail || level == DetailLegacy {
+		t.Errorf("no detail parsed as %q", level)
+	}
+	if level, err := ParseDetailLevel("Legacy"); err != nil || level != DetailLegacy {
+		t.Errorf("legacy parsed as %q, %v", level, err)
+	}
+}
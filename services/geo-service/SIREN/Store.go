@@ -0,0 +1,351 @@
+package SIREN
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vmihailenco/msgpack"
+	"go.etcd.io/bbolt"
+)
+
+// Bucket and key names shared by the UGC loader and the geo service
+const (
+	DataBucket  = "Data"
+	MetaBucket  = "Meta"
+	MetadataKey = "metadata"
+)
+
+// The kind of NWS area a UGC store holds. Each type is shipped by NWS as its own shapefile.
+type ZoneType string
+
+const (
+	ZoneCounty   ZoneType = "county"
+	ZonePublic   ZoneType = "zone"
+	ZoneFire     ZoneType = "fire"
+	ZoneMarine   ZoneType = "marine"
+	ZoneOffshore ZoneType = "offshore"
+	ZoneHighSeas ZoneType = "highseas"
+)
+
+var ZoneTypes = []ZoneType{ZoneCounty, ZonePublic, ZoneFire, ZoneMarine, ZoneOffshore, ZoneHighSeas}
+
+// StoreFileName is the default bbolt file name for a zone type, e.g. "nws_county.db"
+func StoreFileName(zoneType ZoneType) string {
+	return fmt.Sprintf("nws_%s.db", zoneType)
+}
+
+// StorePath is where the store for a zone type is in dir, the working directory when dir is empty
+func StorePath(dir string, zoneType ZoneType) string {
+	return filepath.Join(dir, StoreFileName(zoneType))
+}
+
+// StoreMetadata describes the dataset a UGC store was built from.
+type StoreMetadata struct {
+	Version       int       `msgpack:"version"`
+	SchemaVersion int       `msgpack:"schemaVersion"` // Missing in version 1 stores
+	ZoneType      ZoneType  `msgpack:"zoneType"`
+	Source        string    `msgpack:"source"`
+	DatasetDate   time.Time `msgpack:"datasetDate"`
+	Records       int       `msgpack:"records"`
+	BuiltAt       time.Time `msgpack:"builtAt"`
+}
+
+// UGCStore is a read-only bbolt store of UGC features that can be swapped for a
+// newer file on disk without restarting the service.
+type UGCStore struct {
+	Name string
+	Path string
+
+	mu   sync.RWMutex
+	db   *bbolt.DB
+	meta StoreMetadata
+	info os.FileInfo
+}
+
+func OpenUGCStore(name string, path string) (*UGCStore, error) {
+	store := &UGCStore{Name: name, Path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload opens the file currently at the store path and swaps it in for the old one.
+func (s *UGCStore) Reload() error {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return err
+	}
+
+	db, err := bbolt.Open(s.Path, 0644, &bbolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	meta, err := readMetadata(db)
+	if err != nil {
+		// Stores built before the loader existed have no metadata, they are still usable
+		log.Warn("UGC store has no metadata", "store", s.Name, "err", err)
+	}
+	if meta.SchemaVersion > SchemaVersion {
+		db.Close()
+		return fmt.Errorf("store %s has schema version %d, this build only reads up to %d", s.Name, meta.SchemaVersion, SchemaVersion)
+	}
+	if meta.SchemaVersion < SchemaVersion {
+		log.Warn("UGC store uses the legacy schema, run ugc-migrate to convert it", "store", s.Name, "schema", meta.SchemaVersion)
+	}
+
+	s.mu.Lock()
+	old := s.db
+	s.db = db
+	s.meta = meta
+	s.info = info
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	log.Info("Opened UGC store", "store", s.Name, "version", meta.Version, "dataset", meta.DatasetDate.Format("2006-01-02"), "records", meta.Records)
+	return nil
+}
+
+// Watch polls the store path and reloads the store whenever the file is replaced.
+// The loader writes a new file and renames it over the old one, so a changed
+// inode or modification time means a new dataset is ready.
+func (s *UGCStore) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			log.Warn("Failed to stat UGC store", "store", s.Name, "err", err)
+			continue
+		}
+
+		s.mu.RLock()
+		changed := !os.SameFile(info, s.info) || !info.ModTime().Equal(s.info.ModTime())
+		s.mu.RUnlock()
+
+		if changed {
+			if err := s.Reload(); err != nil {
+				log.Error("Failed to reload UGC store", "store", s.Name, "err", err)
+			}
+		}
+	}
+}
+
+func (s *UGCStore) Get(ugc string) (UGC, error) {
+	var ugcData UGC
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.db == nil {
+		return UGC{}, fmt.Errorf("UGC store %s is closed", s.Name)
+	}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(DataBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		v := b.Get([]byte(ugc))
+		if v == nil {
+			return fmt.Errorf("key not found")
+		}
+		var err error
+		ugcData, err = decodeUGC(ugc, v, s.meta.SchemaVersion)
+		return err
+	})
+	if err != nil {
+		return UGC{}, err
+	}
+	return ugcData, nil
+}
+
+// The record layout of version 1 stores
+type legacyUGC struct {
+	UGC   string  `msgpack:"UGC"`
+	Lat   float64 `msgpack:"lat"`
+	Lon   float64 `msgpack:"lon"`
+	Name  string  `msgpack:"name"`
+	State string  `msgpack:"state"`
+	// This is either a [][2]float64 or a [][][2]float64
+	Feature any `msgpack:"feature"`
+}
+
+func decodeUGC(key string, v []byte, schemaVersion int) (UGC, error) {
+	if schemaVersion >= 2 {
+		var ugc UGC
+		if err := msgpack.Unmarshal(v, &ugc); err != nil {
+			return UGC{}, fmt.Errorf("UGC %s: %w", key, err)
+		}
+		if err := ugc.Geometry.Check(); err != nil {
+			return UGC{}, fmt.Errorf("UGC %s: %w", key, err)
+		}
+		return ugc, nil
+	}
+
+	var legacy legacyUGC
+	if err := msgpack.Unmarshal(v, &legacy); err != nil {
+		return UGC{}, fmt.Errorf("UGC %s: %w", key, err)
+	}
+	geometry, err := DecodeLegacyFeature(legacy.Feature)
+	if err != nil {
+		return UGC{}, fmt.Errorf("UGC %s: %w", key, err)
+	}
+	return UGC{
+		UGC:      legacy.UGC,
+		Lat:      legacy.Lat,
+		Lon:      legacy.Lon,
+		Name:     legacy.Name,
+		State:    legacy.State,
+		Geometry: geometry,
+	}, nil
+}
+
+// Check makes sure the store is open and has its data bucket, for readiness checks
+func (s *UGCStore) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.db == nil {
+		return fmt.Errorf("store is closed")
+	}
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(DataBucket)) == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		return nil
+	})
+}
+
+func (s *UGCStore) Metadata() StoreMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.meta
+}
+
+func (s *UGCStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+func readMetadata(db *bbolt.DB) (StoreMetadata, error) {
+	var meta StoreMetadata
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(MetaBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		v := b.Get([]byte(MetadataKey))
+		if v == nil {
+			return fmt.Errorf("key not found")
+		}
+		return msgpack.Unmarshal(v, &meta)
+	})
+	return meta, err
+}
+
+// ReadStoreMetadata reads the metadata of the store file at path without keeping it open.
+func ReadStoreMetadata(path string) (StoreMetadata, error) {
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return StoreMetadata{}, err
+	}
+	defer db.Close()
+	return readMetadata(db)
+}
+
+// ReadStore reads the metadata and every feature of the store file at path, whatever
+// schema it was written with. Features that can't be decoded are returned as errors.
+func ReadStore(path string) (StoreMetadata, []UGC, error) {
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return StoreMetadata{}, nil, err
+	}
+	defer db.Close()
+
+	// Missing metadata means a store from before the loader, which is schema 1
+	meta, _ := readMetadata(db)
+	if meta.SchemaVersion > SchemaVersion {
+		return meta, nil, fmt.Errorf("schema version %d is newer than %d", meta.SchemaVersion, SchemaVersion)
+	}
+
+	var features []UGC
+	var errs []error
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(DataBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			ugc, err := decodeUGC(string(k), v, meta.SchemaVersion)
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			features = append(features, ugc)
+			return nil
+		})
+	})
+	if err != nil {
+		return meta, nil, err
+	}
+	return meta, features, errors.Join(errs...)
+}
+
+// WriteStore writes the features and metadata to a brand new bbolt file at path.
+// The store is always written with the current schema.
+func WriteStore(path string, meta StoreMetadata, features []UGC) error {
+	meta.SchemaVersion = SchemaVersion
+	for _, feature := range features {
+		if err := feature.Geometry.Check(); err != nil {
+			return fmt.Errorf("refusing to write %s: %w", feature.UGC, err)
+		}
+	}
+
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		data, err := tx.CreateBucketIfNotExists([]byte(DataBucket))
+		if err != nil {
+			return err
+		}
+		for _, feature := range features {
+			v, err := msgpack.Marshal(feature)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", feature.UGC, err)
+			}
+			if err := data.Put([]byte(feature.UGC), v); err != nil {
+				return err
+			}
+		}
+
+		metaBucket, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+		if err != nil {
+			return err
+		}
+		v, err := msgpack.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return metaBucket.Put([]byte(MetadataKey), v)
+	})
+}
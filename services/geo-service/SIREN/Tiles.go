@@ -0,0 +1,191 @@
+package SIREN
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	gogeojson "github.com/paulmach/go.geojson"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+/**============================================
+ *            Mapbox Vector Tiles
+ *=============================================**/
+
+// See https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+
+const (
+	TileExtent   = 4096
+	tileBuffer   = 64 // Pixels drawn outside the tile so borders don't show at tile edges
+	tileLayer    = "alerts"
+	maxTileZoom  = 22
+	tileCacheMax = 4096
+)
+
+// Properties copied from CreateGeoJSON onto each tile feature
+var tileProperties = []string{"id", "event", "color", "severity"}
+
+type tileFeature struct {
+	bound      orb.Bound
+	geometry   orb.MultiPolygon
+	properties geojson.Properties
+}
+
+// TileSource cuts vector tiles out of the current active alert geometry.
+type TileSource struct {
+	mu       sync.RWMutex
+	version  uint64
+	features []tileFeature
+	cache    map[maptile.Tile][]byte
+}
+
+func NewTileSource() *TileSource {
+	return &TileSource{cache: make(map[maptile.Tile][]byte)}
+}
+
+// Update replaces the geometry tiles are cut from
+func (t *TileSource) Update(version uint64, collection gogeojson.FeatureCollection) {
+	features := make([]tileFeature, 0, len(collection.Features))
+	for _, feature := range collection.Features {
+		if feature.Geometry == nil || !feature.Geometry.IsMultiPolygon() {
+			continue
+		}
+
+		var mp orb.MultiPolygon
+		for _, polygon := range feature.Geometry.MultiPolygon {
+			var poly orb.Polygon
+			for _, ring := range polygon {
+				var orbRing orb.Ring
+				for _, pt := range ring {
+					if len(pt) < 2 {
+						continue
+					}
+					orbRing = append(orbRing, orb.Point{pt[0], pt[1]})
+				}
+				poly = append(poly, orbRing)
+			}
+			mp = append(mp, poly)
+		}
+
+		properties := make(geojson.Properties)
+		for _, key := range tileProperties {
+			if v, ok := feature.Properties[key]; ok && v != nil {
+				properties[key] = fmt.Sprint(v)
+			}
+		}
+
+		features = append(features, tileFeature{bound: mp.Bound(), geometry: mp, properties: properties})
+	}
+
+	t.mu.Lock()
+	t.version = version
+	t.features = features
+	t.cache = make(map[maptile.Tile][]byte)
+	t.mu.Unlock()
+}
+
+// Tile returns the encoded vector tile and the geometry version it was cut from
+func (t *TileSource) Tile(z, x, y uint32) ([]byte, uint64, error) {
+	if z > maxTileZoom || x >= 1<<z || y >= 1<<z {
+		return nil, 0, fmt.Errorf("tile %d/%d/%d out of range", z, x, y)
+	}
+	tile := maptile.New(x, y, maptile.Zoom(z))
+
+	t.mu.RLock()
+	version := t.version
+	features := t.features
+	data, ok := t.cache[tile]
+	t.mu.RUnlock()
+	if ok {
+		return data, version, nil
+	}
+
+	data, err := encodeTile(tile, features)
+	if err != nil {
+		return nil, version, err
+	}
+
+	t.mu.Lock()
+	// Only keep the tile if the geometry didn't change while we were cutting it
+	if t.version == version {
+		if len(t.cache) >= tileCacheMax {
+			t.cache = make(map[maptile.Tile][]byte)
+		}
+		t.cache[tile] = data
+	}
+	t.mu.Unlock()
+
+	return data, version, nil
+}
+
+func encodeTile(tile maptile.Tile, features []tileFeature) ([]byte, error) {
+	// Pad the tile in degrees by the buffer so we only project what we need
+	bound := tile.Bound()
+	padX := (bound.Max[0] - bound.Min[0]) * tileBuffer / TileExtent
+	padY := (bound.Max[1] - bound.Min[1]) * tileBuffer / TileExtent
+	bound = bound.Pad(math.Max(padX, padY))
+
+	collection := geojson.NewFeatureCollection()
+	for i, feature := range features {
+		if !feature.bound.Intersects(bound) {
+			continue
+		}
+		// Projecting works in place, so each tile gets its own copy
+		f := geojson.NewFeature(feature.geometry.Clone())
+		f.ID = uint64(i + 1)
+		f.Properties = feature.properties
+		collection.Append(f)
+	}
+	if len(collection.Features) == 0 {
+		return []byte{}, nil
+	}
+
+	layer := mvt.NewLayer(tileLayer, collection)
+	layer.Version = 2
+	layer.Extent = TileExtent
+	layer.ProjectToTile(tile)
+	layer.Clip(orb.Bound{
+		Min: orb.Point{-tileBuffer, -tileBuffer},
+		Max: orb.Point{TileExtent + tileBuffer, TileExtent + tileBuffer},
+	})
+	// A pixel of tolerance is the same detail at every zoom
+	layer.Simplify(simplify.DouglasPeucker(1.0))
+	layer.RemoveEmpty(1.0, 1.0)
+	if len(layer.Features) == 0 {
+		return []byte{}, nil
+	}
+	for _, f := range layer.Features {
+		f.Geometry = windForTile(f.Geometry)
+	}
+	return mvt.Marshal(mvt.Layers{layer})
+}
+
+// Winds the rings the way the spec wants them. Tile space has y pointing down, so an
+// exterior that looks clockwise on screen has a positive area, which orb calls CCW.
+func windForTile(geometry orb.Geometry) orb.Geometry {
+	wind := func(polygon orb.Polygon) {
+		for i, ring := range polygon {
+			want := orb.CW
+			if i == 0 {
+				want = orb.CCW
+			}
+			if ring.Orientation() == -want {
+				ring.Reverse()
+			}
+		}
+	}
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		wind(g)
+	case orb.MultiPolygon:
+		for _, polygon := range g {
+			wind(polygon)
+		}
+	}
+	return geometry
+}
@@ -0,0 +1,123 @@
+package SIREN
+
+import (
+	"testing"
+
+	gogeojson "github.com/paulmach/go.geojson"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+)
+
+func alertFeature(id string, event string, polygons ...[][][]float64) *gogeojson.Feature {
+	feature := gogeojson.NewFeature(gogeojson.NewMultiPolygonGeometry(polygons...))
+	feature.Properties["id"] = id
+	feature.Properties["event"] = event
+	feature.Properties["color"] = "#FF0000"
+	feature.Properties["severity"] = "Extreme"
+	return feature
+}
+
+// Decodes the single alerts layer of a tile
+func decodeTile(t *testing.T, source *TileSource, tile maptile.Tile) *mvt.Layer {
+	t.Helper()
+	data, _, err := source.Tile(uint32(tile.Z), tile.X, tile.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := mvt.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 1 || layers[0].Name != tileLayer {
+		t.Fatalf("got %d layers, want just %q", len(layers), tileLayer)
+	}
+	if layers[0].Version != 2 || layers[0].Extent != TileExtent {
+		t.Errorf("layer version %d extent %d, want 2 and %d", layers[0].Version, layers[0].Extent, TileExtent)
+	}
+	return layers[0]
+}
+
+func TestTileEncoding(t *testing.T) {
+	// Wound the GeoJSON way, with a hole
+	withHole := [][][]float64{
+		{{-95, 40}, {-92, 40}, {-92, 43}, {-95, 43}, {-95, 40}},
+		{{-94, 41}, {-94, 42}, {-93, 42}, {-93, 41}, {-94, 41}},
+	}
+	// The same shape wound the shapefile way
+	reversed := [][][]float64{
+		{{-95, 40}, {-95, 43}, {-92, 43}, {-92, 40}, {-95, 40}},
+		{{-94, 41}, {-93, 41}, {-93, 42}, {-94, 42}, {-94, 41}},
+	}
+	// Much larger than the tile
+	large := [][][]float64{{{-130, 20}, {-60, 20}, {-60, 55}, {-130, 55}, {-130, 20}}}
+
+	collection := gogeojson.NewFeatureCollection()
+	collection.AddFeature(alertFeature("TOR-1", "Tornado Warning", withHole))
+	collection.AddFeature(alertFeature("SVR-1", "Severe Thunderstorm Warning", reversed))
+	collection.AddFeature(alertFeature("FFA-1", "Flood Watch", large))
+
+	source := NewTileSource()
+	source.Update(7, *collection)
+	tile := maptile.At(orb.Point{-93.5, 41.5}, 6)
+	layer := decodeTile(t, source, tile)
+
+	if len(layer.Features) != 3 {
+		t.Fatalf("got %d features, want 3", len(layer.Features))
+	}
+	byID := make(map[string]orb.Geometry)
+	for _, feature := range layer.Features {
+		id, _ := feature.Properties["id"].(string)
+		byID[id] = feature.Geometry
+		if id == "TOR-1" {
+			for key, want := range map[string]string{"event": "Tornado Warning", "color": "#FF0000", "severity": "Extreme"} {
+				if got := feature.Properties[key]; got != want {
+					t.Errorf("%s is %v, want %q", key, got, want)
+				}
+			}
+		}
+	}
+
+	t.Run("winding", func(t *testing.T) {
+		// The decoder splits polygons on exterior winding, a wrongly wound hole would be a polygon of its own
+		for _, id := range []string{"TOR-1", "SVR-1"} {
+			polygon, ok := byID[id].(orb.Polygon)
+			if !ok || len(polygon) != 2 {
+				t.Errorf("%s decoded as %T %v, want a polygon with a hole", id, byID[id], byID[id])
+				continue
+			}
+			if polygon[0].Orientation() != orb.CCW || polygon[1].Orientation() != orb.CW {
+				t.Errorf("%s exterior %d and hole %d, want the exterior positive and the hole negative", id, polygon[0].Orientation(), polygon[1].Orientation())
+			}
+		}
+	})
+
+	t.Run("clipping", func(t *testing.T) {
+		geometry, ok := byID["FFA-1"]
+		if !ok {
+			t.Fatal("the large feature is missing")
+		}
+		bound := geometry.Bound()
+		limit := orb.Bound{Min: orb.Point{-tileBuffer, -tileBuffer}, Max: orb.Point{TileExtent + tileBuffer, TileExtent + tileBuffer}}
+		if !limit.Contains(bound.Min) || !limit.Contains(bound.Max) {
+			t.Errorf("bound %v reaches outside the buffered tile %v", bound, limit)
+		}
+		// It covers the whole tile, so it is cut to the buffer on every side
+		if bound.Min != limit.Min || bound.Max != limit.Max {
+			t.Errorf("bound %v, want it cut to %v", bound, limit)
+		}
+	})
+
+	t.Run("empty tile", func(t *testing.T) {
+		data, version, err := source.Tile(6, 0, 0)
+		if err != nil || len(data) != 0 || version != 7 {
+			t.Errorf("got %d bytes, version %d, err %v, want an empty tile of version 7", len(data), version, err)
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		if _, _, err := source.Tile(2, 4, 0); err == nil {
+			t.Error("a tile past the edge of the world was cut")
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package SIREN
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Problems found in stored UGC geometry. Everything here is repaired when the
+// feature is validated, they are reported so bad datasets get noticed.
+type GeometryIssue string
+
+const (
+	IssueInvalidCoordinate GeometryIssue = "invalid_coordinate"
+	IssueUnclosedRing      GeometryIssue = "unclosed_ring"
+	IssueDuplicatePoints   GeometryIssue = "duplicate_points"
+	IssueDegenerateRing    GeometryIssue = "degenerate_ring"
+	IssueWrongWinding      GeometryIssue = "wrong_winding"
+	IssueSelfIntersection  GeometryIssue = "self_intersection"
+)
+
+var ErrNoUsableRings = errors.New("no usable rings")
+
+// Why an area of an alert couldn't be drawn
+type AreaFailure struct {
+	UGC    string `bson:"ugc" msgpack:"ugc" json:"ugc"`
+	Reason string `bson:"reason" msgpack:"reason" json:"reason"`
+}
+
+type ValidationResult struct {
+	Geometry AbstractGeom
+	Issues   []GeometryIssue
+	Holes    int
+}
+
+func (v *ValidationResult) report(issue GeometryIssue) {
+	for _, existing := range v.Issues {
+		if existing == issue {
+			return
+		}
+	}
+	v.Issues = append(v.Issues, issue)
+}
+
+// ValidateGeometry checks a stored UGC geometry and repairs its rings. Geometry with
+// a shape we don't know is rejected rather than guessed at.
+func ValidateGeometry(geometry Geometry) (ValidationResult, error) {
+	var result ValidationResult
+
+	if err := geometry.Check(); err != nil {
+		return result, err
+	}
+
+	var geom AbstractGeom
+	for _, polygon := range geometry.Polygons {
+		exterior := result.validateRing(polygon.Exterior.floats())
+		if exterior == nil {
+			continue
+		}
+		exteriorArea := signedArea(exterior)
+		if exteriorArea < 0 {
+			result.report(IssueWrongWinding)
+		}
+		rings := [][][]float64{orientRing(exterior, true)}
+
+		for _, raw := range polygon.Holes {
+			hole := result.validateRing(raw.floats())
+			if hole == nil {
+				continue
+			}
+			result.Holes++
+			// Holes are given the opposite winding of their exterior in a valid feature
+			if (signedArea(hole) > 0) == (exteriorArea > 0) {
+				result.report(IssueWrongWinding)
+			}
+			rings = append(rings, orientRing(hole, false))
+		}
+		geom = append(geom, rings)
+	}
+	if len(geom) == 0 {
+		return result, ErrNoUsableRings
+	}
+
+	intersects := false
+	for _, polygon := range geom {
+		for _, ring := range polygon {
+			if ringSelfIntersects(ring) {
+				intersects = true
+			}
+		}
+	}
+	if intersects {
+		result.report(IssueSelfIntersection)
+		geom = RepairMultiPolygon(geom)
+		if len(geom) == 0 {
+			return result, ErrNoUsableRings
+		}
+	}
+
+	result.Geometry = geom
+	return result, nil
+}
+
+// Drops bad coordinates and repeated points and closes the ring, nil if nothing usable is left
+func (v *ValidationResult) validateRing(raw [][]float64) [][]float64 {
+	var ring [][]float64
+	for _, pt := range raw {
+		if len(pt) < 2 || math.IsNaN(pt[0]) || math.IsNaN(pt[1]) || math.Abs(pt[0]) > 180 || math.Abs(pt[1]) > 90 {
+			v.report(IssueInvalidCoordinate)
+			continue
+		}
+		if len(ring) > 0 && samePoint(ring[len(ring)-1], pt) {
+			v.report(IssueDuplicatePoints)
+			continue
+		}
+		ring = append(ring, []float64{pt[0], pt[1]})
+	}
+
+	if len(ring) > 0 && !samePoint(ring[0], ring[len(ring)-1]) {
+		v.report(IssueUnclosedRing)
+		ring = append(ring, []float64{ring[0][0], ring[0][1]})
+	}
+
+	if len(ring) < 4 || collinear(ring) {
+		v.report(IssueDegenerateRing)
+		return nil
+	}
+	return ring
+}
+
+// Exteriors are made counter-clockwise and holes clockwise, as GeoJSON wants them
+func orientRing(ring [][]float64, exterior bool) [][]float64 {
+	area := signedArea(ring)
+	if (exterior && area < 0) || (!exterior && area > 0) {
+		reversed := make([][]float64, len(ring))
+		for i, pt := range ring {
+			reversed[len(ring)-1-i] = pt
+		}
+		return reversed
+	}
+	return ring
+}
+
+type segment struct {
+	index      int
+	a, b       []float64
+	minX, maxX float64
+}
+
+// Checks for two non-adjacent edges of the ring crossing or touching. Edges are swept
+// left to right so only edges overlapping in x are compared.
+func ringSelfIntersects(ring [][]float64) bool {
+	n := len(ring) - 1 // Closed, so the last point repeats the first
+	segments := make([]segment, n)
+	for i := 0; i < n; i++ {
+		a, b := ring[i], ring[i+1]
+		segments[i] = segment{index: i, a: a, b: b, minX: math.Min(a[0], b[0]), maxX: math.Max(a[0], b[0])}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].minX < segments[j].minX })
+
+	for i := range segments {
+		for j := i + 1; j < len(segments) && segments[j].minX <= segments[i].maxX; j++ {
+			s, t := segments[i], segments[j]
+			gap := s.index - t.index
+			if gap < 0 {
+				gap = -gap
+			}
+			// Neighbours always share a point
+			if gap == 1 || gap == n-1 {
+				continue
+			}
+			if segmentsIntersect(s.a, s.b, t.a, t.b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func orientation(a, b, c []float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func onSegment(a, b, c []float64) bool {
+	return math.Min(a[0], b[0]) <= c[0] && c[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= c[1] && c[1] <= math.Max(a[1], b[1])
+}
+
+func segmentsIntersect(p1, p2, q1, q2 []float64) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(q1, q2, p1)) || (d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) || (d4 == 0 && onSegment(p1, p2, q2))
+}
@@ -0,0 +1,54 @@
+package SIREN
+
+import (
+	"slices"
+	"testing"
+)
+
+// Counter-clockwise squares, reversed for clockwise
+var (
+	outerSquare = Ring{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}
+	innerSquare = Ring{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {1, 1}}
+)
+
+func reversed(ring Ring) Ring {
+	r := slices.Clone(ring)
+	slices.Reverse(r)
+	return r
+}
+
+func TestValidateWinding(t *testing.T) {
+	tests := []struct {
+		name     string
+		exterior Ring
+		holes    []Ring
+		wrong    bool
+	}{
+		{"wound the GeoJSON way", outerSquare, []Ring{reversed(innerSquare)}, false},
+		{"clockwise exterior", reversed(outerSquare), nil, true},
+		{"hole wound like its exterior", outerSquare, []Ring{innerSquare}, true},
+		{"both the shapefile way", reversed(outerSquare), []Ring{innerSquare}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ValidateGeometry(Geometry{Type: GeometryPolygon, Polygons: []Polygon{{Exterior: tt.exterior, Holes: tt.holes}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := slices.Contains(result.Issues, IssueWrongWinding); got != tt.wrong {
+				t.Errorf("wrong winding reported %v, want %v (issues %v)", got, tt.wrong, result.Issues)
+			}
+			// Repaired either way
+			polygon := result.Geometry[0]
+			if signedArea(polygon[0]) <= 0 {
+				t.Error("exterior is still clockwise")
+			}
+			for _, hole := range polygon[1:] {
+				if signedArea(hole) >= 0 {
+					t.Error("hole is still counter-clockwise")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package SIREN
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// GeometryDelta is what changed between two versions of the active geometry.
+type GeometryDelta struct {
+	Version uint64             `json:"version" msgpack:"version"`
+	Since   uint64             `json:"since" msgpack:"since"`
+	Added   []*geojson.Feature `json:"added" msgpack:"added"`
+	Changed []*geojson.Feature `json:"changed" msgpack:"changed"`
+	Removed []string           `json:"removed" msgpack:"removed"`
+}
+
+// ErrVersionTooOld is returned when a delta is requested from a version we no longer remember.
+// Clients should fetch the full collection again.
+type ErrVersionTooOld struct {
+	Since  uint64
+	Oldest uint64
+}
+
+func (e ErrVersionTooOld) Error() string {
+	return fmt.Sprintf("version %d is no longer available, oldest is %d", e.Since, e.Oldest)
+}
+
+// GeometryVersions numbers each published feature collection and remembers the
+// feature hashes of recent versions so clients can ask for only what changed.
+// Numbering starts at the boot time in unix millis, so a version or ETag from before
+// a restart is never mistaken for one of this boot's.
+type GeometryVersions struct {
+	mu       sync.RWMutex
+	retain   int
+	epoch    uint64 // The first version of this boot
+	version  uint64
+	features map[string]*geojson.Feature
+	history  map[uint64]map[string]string // version -> feature id -> hash
+}
+
+func NewGeometryVersions(retain int) *GeometryVersions {
+	epoch := uint64(time.Now().UnixMilli())
+	return &GeometryVersions{
+		retain:   retain,
+		epoch:    epoch,
+		version:  epoch,
+		features: make(map[string]*geojson.Feature),
+		history:  make(map[uint64]map[string]string),
+	}
+}
+
+func featureHash(feature *geojson.Feature) string {
+	b, err := json.Marshal(feature)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(b)
+	return fmt.Sprintf("%x", hash)
+}
+
+// Publish records a new feature collection and returns its version. If nothing in the
+// collection changed the current version is returned and no new version is created.
+func (v *GeometryVersions) Publish(collection geojson.FeatureCollection) (uint64, bool) {
+	features := make(map[string]*geojson.Feature, len(collection.Features))
+	hashes := make(map[string]string, len(collection.Features))
+	for _, feature := range collection.Features {
+		id, _ := feature.Properties["id"].(string)
+		features[id] = feature
+		hashes[id] = featureHash(feature)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if current, ok := v.history[v.version]; ok && sameHashes(current, hashes) {
+		return v.version, false
+	}
+
+	v.version++
+	v.features = features
+	v.history[v.version] = hashes
+	delete(v.history, v.version-uint64(v.retain))
+	return v.version, true
+}
+
+func sameHashes(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, hash := range a {
+		if b[id] != hash {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *GeometryVersions) Version() uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.version
+}
+
+// Delta returns the features added, changed and removed since the given version.
+func (v *GeometryVersions) Delta(since uint64) (GeometryDelta, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	delta := GeometryDelta{
+		Version: v.version,
+		Since:   since,
+		Added:   []*geojson.Feature{},
+		Changed: []*geojson.Feature{},
+		Removed: []string{},
+	}
+	if since == v.version {
+		return delta, nil
+	}
+
+	// Versions before the epoch are from an earlier boot, the client has to start over
+	old, ok := v.history[since]
+	if !ok || since < v.epoch || since > v.version {
+		oldest := v.version
+		for version := range v.history {
+			oldest = min(oldest, version)
+		}
+		return delta, ErrVersionTooOld{Since: since, Oldest: oldest}
+	}
+
+	current := v.history[v.version]
+	for id, hash := range current {
+		oldHash, existed := old[id]
+		if !existed {
+			delta.Added = append(delta.Added, v.features[id])
+		} else if oldHash != hash {
+			delta.Changed = append(delta.Changed, v.features[id])
+		}
+	}
+	for id := range old {
+		if _, exists := current[id]; !exists {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	return delta, nil
+}
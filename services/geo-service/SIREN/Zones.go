@@ -0,0 +1,62 @@
+package SIREN
+
+// Two letter prefixes of the marine UGC areas (coastal waters, Great Lakes, offshore and high seas).
+// Land zones use state abbreviations instead, so these never collide with a public or fire zone.
+var marinePrefixes = map[string]bool{
+	"AM": true, "AN": true, "GM": true, "LC": true, "LE": true, "LH": true,
+	"LM": true, "LO": true, "LS": true, "PH": true, "PK": true, "PM": true,
+	"PS": true, "PZ": true, "SL": true,
+}
+
+// Products issued against fire weather zones
+var fireProducts = map[string]bool{
+	"FWW": true, // Red Flag Warning
+	"FWA": true, // Fire Weather Watch
+}
+
+// Products issued against marine, offshore or high seas zones
+var marineProducts = map[string]bool{
+	"BWY": true, "GLA": true, "GLW": true, "HFA": true, "HFW": true, "LOY": true,
+	"MAW": true, "MFY": true, "MHW": true, "MHY": true, "MWS": true, "RBY": true,
+	"SCY": true, "SEA": true, "SEW": true, "SIY": true, "SMY": true, "SRA": true,
+	"SRW": true, "SWY": true, "UPY": true,
+}
+
+func IsMarineUGC(ugc string) bool {
+	return len(ugc) >= 2 && marinePrefixes[ugc[0:2]]
+}
+
+// ZoneLookupOrder returns the stores to search for a UGC, most likely first.
+// Public and fire zones share the same codes (TXZ035 is both), so the product
+// is used to decide which shape the forecaster meant. The product is the
+// phenomena and significance of the alert, e.g. "FWW" for a Red Flag Warning.
+func ZoneLookupOrder(ugc string, product string) []ZoneType {
+	if len(ugc) < 3 {
+		return nil
+	}
+
+	if ugc[2] == 'C' {
+		return []ZoneType{ZoneCounty}
+	}
+
+	if IsMarineUGC(ugc) {
+		return []ZoneType{ZoneMarine, ZoneOffshore, ZoneHighSeas}
+	}
+
+	if marineProducts[product] {
+		return []ZoneType{ZoneMarine, ZoneOffshore, ZoneHighSeas, ZonePublic}
+	}
+
+	if fireProducts[product] {
+		return []ZoneType{ZoneFire, ZonePublic}
+	}
+	return []ZoneType{ZonePublic, ZoneFire}
+}
+
+// ProductFromIdentifier gets the phenomena and significance from a SIREN identifier
+func ProductFromIdentifier(identifier string) string {
+	if len(identifier) < 3 {
+		return ""
+	}
+	return identifier[0:3]
+}
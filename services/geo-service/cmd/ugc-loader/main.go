@@ -0,0 +1,307 @@
+/**========================================================================
+ *  						  UGC Loader
+ *  							SIREN
+ *
+ *  Builds the bbolt UGC stores used by the geo service from the NWS AWIPS
+ *  shapefiles (https://www.weather.gov/gis/AWIPSShapefiles).
+ *
+ *  go run ./cmd/ugc-loader -type county -in c_05mr24.zip
+ *
+ *  The store is written next to the destination and renamed over it once it
+ *  is complete, so a running geo service picks it up without a restart.
+ *========================================================================**/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"geoService/SIREN"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jonas-p/go-shp"
+)
+
+// Common interface of the plain and zipped shapefile readers
+type shapeReader interface {
+	Next() bool
+	Shape() (int, shp.Shape)
+	Attribute(n int) string
+	Fields() []shp.Field
+	Err() error
+	Close() error
+}
+
+func openShapefile(path string) (shapeReader, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return shp.OpenZip(path)
+	}
+	return shp.Open(path)
+}
+
+/**============================================
+ *             Record Interpretation
+ *=============================================**/
+
+// The attribute columns we care about in each dataset
+type attributes struct {
+	values map[string]string
+}
+
+// DBF values are padded with spaces, or NULs depending on the writer
+func (a attributes) get(name string) string {
+	return strings.Trim(a.values[name], " \x00")
+}
+
+func (a attributes) float(name string) float64 {
+	f, _ := strconv.ParseFloat(a.get(name), 64)
+	return f
+}
+
+// Builds the UGC code, name and state for a shapefile record
+func identify(zoneType SIREN.ZoneType, attrs attributes) (string, string, string, error) {
+	switch zoneType {
+	case SIREN.ZoneCounty:
+		// County UGCs are the state + C + the last three digits of the FIPS code
+		fips := attrs.get("FIPS")
+		if len(fips) != 5 {
+			return "", "", "", fmt.Errorf("invalid FIPS code %q", fips)
+		}
+		state := attrs.get("STATE")
+		return state + "C" + fips[2:], attrs.get("COUNTYNAME"), state, nil
+	case SIREN.ZonePublic, SIREN.ZoneFire:
+		state := attrs.get("STATE")
+		zone := attrs.get("ZONE")
+		if state == "" || len(zone) != 3 {
+			return "", "", "", fmt.Errorf("invalid zone %q%q", state, zone)
+		}
+		return state + "Z" + zone, attrs.get("NAME"), state, nil
+	case SIREN.ZoneMarine, SIREN.ZoneOffshore, SIREN.ZoneHighSeas:
+		// Marine datasets already carry the full UGC
+		id := attrs.get("ID")
+		if len(id) != 6 {
+			return "", "", "", fmt.Errorf("invalid marine zone %q", id)
+		}
+		return id, attrs.get("NAME"), "", nil
+	default:
+		return "", "", "", fmt.Errorf("unknown zone type %q", zoneType)
+	}
+}
+
+// Splits a shapefile polygon into its rings
+func polygonRings(shape shp.Shape) ([]SIREN.Ring, error) {
+	var parts []int32
+	var points []shp.Point
+	switch p := shape.(type) {
+	case *shp.Polygon:
+		parts, points = p.Parts, p.Points
+	case *shp.PolygonZ:
+		parts, points = p.Parts, p.Points
+	case *shp.PolygonM:
+		parts, points = p.Parts, p.Points
+	default:
+		return nil, fmt.Errorf("unsupported shape type %T", shape)
+	}
+
+	rings := make([]SIREN.Ring, 0, len(parts))
+	for i, start := range parts {
+		end := int32(len(points))
+		if i+1 < len(parts) {
+			end = parts[i+1]
+		}
+		ring := make(SIREN.Ring, 0, end-start)
+		for _, pt := range points[start:end] {
+			ring = append(ring, [2]float64{pt.X, pt.Y})
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+type record struct {
+	ugc   SIREN.UGC
+	rings []SIREN.Ring
+}
+
+// Reads every record in the shapefile, merging records that share a UGC.
+// Counties split between forecast offices show up more than once.
+func readRecords(path string, zoneType SIREN.ZoneType) ([]SIREN.UGC, error) {
+	reader, err := openShapefile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	fields := reader.Fields()
+	records := make(map[string]*record)
+	skipped := 0
+
+	for reader.Next() {
+		n, shape := reader.Shape()
+
+		attrs := attributes{values: make(map[string]string, len(fields))}
+		for i, field := range fields {
+			attrs.values[strings.ToUpper(field.String())] = reader.Attribute(i)
+		}
+
+		ugc, name, state, err := identify(zoneType, attrs)
+		if err != nil {
+			log.Warn("Skipping record", "record", n, "err", err)
+			skipped++
+			continue
+		}
+
+		rings, err := polygonRings(shape)
+		if err != nil {
+			log.Warn("Skipping record", "record", n, "ugc", ugc, "err", err)
+			skipped++
+			continue
+		}
+
+		existing, ok := records[ugc]
+		if !ok {
+			existing = &record{ugc: SIREN.UGC{
+				UGC:   ugc,
+				Lat:   attrs.float("LAT"),
+				Lon:   attrs.float("LON"),
+				Name:  name,
+				State: state,
+			}}
+			records[ugc] = existing
+		}
+		existing.rings = append(existing.rings, rings...)
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+
+	features := make([]SIREN.UGC, 0, len(records))
+	for _, rec := range records {
+		// Shapefile parts don't say which rings are holes, so sort that out here once
+		geometry, err := SIREN.GeometryFromRings(rec.rings)
+		if err != nil {
+			log.Warn("Skipping record", "ugc", rec.ugc.UGC, "err", err)
+			skipped++
+			continue
+		}
+		rec.ugc.Geometry = geometry
+		features = append(features, rec.ugc)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i].UGC < features[j].UGC })
+
+	log.Info("Read shapefile", "path", path, "features", len(features), "skipped", skipped)
+	return features, nil
+}
+
+/**============================================
+ *               Dataset Dates
+ *=============================================**/
+
+// NWS names the shapefiles with their effective date, e.g. c_05mr24 or mz05mr24
+var datasetDateRE = regexp.MustCompile(`(\d{2})([a-z]{2})(\d{2})$`)
+
+var datasetMonths = map[string]time.Month{
+	"ja": time.January, "fe": time.February, "mr": time.March, "ap": time.April,
+	"my": time.May, "jn": time.June, "jl": time.July, "au": time.August,
+	"se": time.September, "oc": time.October, "no": time.November, "de": time.December,
+}
+
+func datasetDateFromName(path string) (time.Time, error) {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	matches := datasetDateRE.FindStringSubmatch(name)
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("no dataset date in %q", name)
+	}
+	month, ok := datasetMonths[matches[2]]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown month %q in %q", matches[2], name)
+	}
+	day, _ := strconv.Atoi(matches[1])
+	year, _ := strconv.Atoi(matches[3])
+	return time.Date(2000+year, month, day, 0, 0, 0, 0, time.UTC), nil
+}
+
+/**============================================
+ *               Driver Code
+ *=============================================**/
+
+func main() {
+	zoneTypeFlag := flag.String("type", "", "dataset type: county, zone, fire, marine, offshore or highseas")
+	input := flag.String("in", "", "path to the NWS shapefile (.shp or .zip)")
+	output := flag.String("out", "", "path of the bbolt store to write (default nws_<type>.db)")
+	dateFlag := flag.String("date", "", "dataset effective date as YYYY-MM-DD (default parsed from the file name)")
+	flag.Parse()
+
+	zoneType := SIREN.ZoneType(strings.ToLower(*zoneTypeFlag))
+	valid := false
+	for _, t := range SIREN.ZoneTypes {
+		if t == zoneType {
+			valid = true
+			break
+		}
+	}
+	if !valid || *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	out := *output
+	if out == "" {
+		out = SIREN.StoreFileName(zoneType)
+	}
+
+	var datasetDate time.Time
+	var err error
+	if *dateFlag != "" {
+		datasetDate, err = time.Parse("2006-01-02", *dateFlag)
+	} else {
+		datasetDate, err = datasetDateFromName(*input)
+	}
+	if err != nil {
+		log.Fatal("Failed to determine the dataset date, pass -date", "err", err)
+	}
+
+	features, err := readRecords(*input, zoneType)
+	if err != nil {
+		log.Fatal("Failed to read shapefile", "path", *input, "err", err)
+	}
+	if len(features) == 0 {
+		log.Fatal("Shapefile contained no usable features", "path", *input)
+	}
+
+	// Bump the version of the store we are replacing
+	version := 1
+	if previous, err := SIREN.ReadStoreMetadata(out); err == nil {
+		version = previous.Version + 1
+	}
+
+	meta := SIREN.StoreMetadata{
+		Version:     version,
+		ZoneType:    zoneType,
+		Source:      filepath.Base(*input),
+		DatasetDate: datasetDate,
+		Records:     len(features),
+		BuiltAt:     time.Now().UTC(),
+	}
+
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	if err := SIREN.WriteStore(tmp, meta, features); err != nil {
+		os.Remove(tmp)
+		log.Fatal("Failed to write store", "path", tmp, "err", err)
+	}
+
+	// Rename is atomic, the geo service will see either the old or the new store
+	if err := os.Rename(tmp, out); err != nil {
+		log.Fatal("Failed to replace store", "path", out, "err", err)
+	}
+
+	log.Info("UGC store written", "path", out, "type", zoneType, "version", version, "records", len(features), "dataset", datasetDate.Format("2006-01-02"))
+}
@@ -0,0 +1,118 @@
+/**========================================================================
+ *  						  UGC Migrate
+ *  							SIREN
+ *
+ *  Converts UGC stores written with the legacy untyped feature layout to
+ *  the current typed geometry schema.
+ *
+ *  go run ./cmd/ugc-migrate nws_county.db nws_zone.db
+ *
+ *  With no arguments every nws_*.db in the working directory is migrated.
+ *  Like the loader, the new store is renamed over the old one once it is
+ *  complete, so a running geo service picks it up without a restart.
+ *========================================================================**/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"geoService/SIREN"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Stores from before the loader have no metadata, so the zone type comes from the name
+func zoneTypeFromName(path string) SIREN.ZoneType {
+	for _, zoneType := range SIREN.ZoneTypes {
+		if filepath.Base(path) == SIREN.StoreFileName(zoneType) {
+			return zoneType
+		}
+	}
+	return ""
+}
+
+func migrate(path string, skipInvalid bool, force bool) error {
+	meta, features, err := SIREN.ReadStore(path)
+	if meta.SchemaVersion >= SIREN.SchemaVersion && !force {
+		log.Info("Store is already current", "path", path, "schema", meta.SchemaVersion)
+		return nil
+	}
+	if err != nil {
+		if features == nil || !skipInvalid {
+			return err
+		}
+		// Each invalid feature is its own error, list them so they can be fixed at the source
+		joined, ok := err.(interface{ Unwrap() []error })
+		if !ok {
+			return err
+		}
+		for _, e := range joined.Unwrap() {
+			log.Warn("Dropping feature", "err", e)
+		}
+	}
+	if len(features) == 0 {
+		return errors.New("store has no features")
+	}
+
+	if meta.ZoneType == "" {
+		meta.ZoneType = zoneTypeFromName(path)
+	}
+	if meta.Version == 0 {
+		meta.Version = 1
+	}
+	if meta.Source == "" {
+		meta.Source = "migrated from " + filepath.Base(path)
+	}
+	meta.Records = len(features)
+	meta.BuiltAt = time.Now().UTC()
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := SIREN.WriteStore(tmp, meta, features); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	log.Info("Store migrated", "path", path, "type", meta.ZoneType, "version", meta.Version, "records", len(features), "schema", SIREN.SchemaVersion)
+	return nil
+}
+
+func main() {
+	skipInvalid := flag.Bool("skip-invalid", false, "drop features that can't be decoded instead of failing")
+	force := flag.Bool("force", false, "rewrite stores that already use the current schema")
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		matches, err := filepath.Glob("nws_*.db")
+		if err != nil {
+			log.Fatal("Failed to list stores", "err", err)
+		}
+		paths = matches
+	}
+	if len(paths) == 0 {
+		log.Fatal("No stores to migrate, pass their paths")
+	}
+
+	failed := false
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		if err := migrate(path, *skipInvalid, *force); err != nil {
+			log.Error("Failed to migrate store", "path", path, "err", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
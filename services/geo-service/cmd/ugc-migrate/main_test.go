@@ -0,0 +1,119 @@
+package main
+
+import (
+	"geoService/SIREN"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+	"go.etcd.io/bbolt"
+)
+
+// A record as version 1 stores kept it
+type legacyRecord struct {
+	UGC     string  `msgpack:"UGC"`
+	Lat     float64 `msgpack:"lat"`
+	Lon     float64 `msgpack:"lon"`
+	Name    string  `msgpack:"name"`
+	State   string  `msgpack:"state"`
+	Feature any     `msgpack:"feature"`
+}
+
+var polk = [][]float64{{-94, 41}, {-93, 41}, {-93, 42}, {-94, 42}, {-94, 41}}
+
+// Writes a store from before the loader, with no metadata
+func writeLegacyStore(t *testing.T, path string, records ...legacyRecord) {
+	t.Helper()
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(SIREN.DataBucket))
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			v, err := msgpack.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(record.UGC), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	valid := legacyRecord{UGC: "IAC153", Lat: 41.7, Lon: -93.6, Name: "Polk", State: "IA", Feature: polk}
+	invalid := legacyRecord{UGC: "IAC999", Name: "Nowhere", State: "IA", Feature: "not a shape"}
+
+	tests := []struct {
+		name        string
+		records     []legacyRecord
+		skipInvalid bool
+		fails       bool
+		migrated    int // Records in the migrated store
+	}{
+		{name: "valid store", records: []legacyRecord{valid}, migrated: 1},
+		{name: "invalid feature fails", records: []legacyRecord{valid, invalid}, fails: true},
+		{name: "invalid feature skipped", records: []legacyRecord{valid, invalid}, skipInvalid: true, migrated: 1},
+		{name: "nothing left", records: []legacyRecord{invalid}, skipInvalid: true, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), SIREN.StoreFileName(SIREN.ZoneCounty))
+			writeLegacyStore(t, path, tt.records...)
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = migrate(path, tt.skipInvalid, false)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("migration succeeded")
+				}
+				// The old store is left alone
+				if after, _ := os.ReadFile(path); string(after) != string(before) {
+					t.Error("a failed migration changed the store")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Error("the temporary store was left behind")
+			}
+
+			meta, features, err := SIREN.ReadStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.SchemaVersion != SIREN.SchemaVersion || meta.ZoneType != SIREN.ZoneCounty || meta.Version != 1 || meta.Records != tt.migrated {
+				t.Errorf("metadata %+v", meta)
+			}
+			if len(features) != tt.migrated || features[0].UGC != "IAC153" || features[0].Name != "Polk" || features[0].Geometry.Type != SIREN.GeometryPolygon {
+				t.Fatalf("features %+v", features)
+			}
+
+			// Running it again leaves a current store alone
+			info, _ := os.Stat(path)
+			if err := migrate(path, false, false); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := os.Stat(path); !os.SameFile(info, again) {
+				t.Error("a current store was rewritten")
+			}
+		})
+	}
+}
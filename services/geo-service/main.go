@@ -5,82 +5,225 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"geoService/NWS"
 	"geoService/SIREN"
+	"math"
 	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
 	geojson "github.com/paulmach/go.geojson"
-	"github.com/rubenv/topojson"
-
+	"github.com/paulmach/orb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/vmihailenco/msgpack"
-	"go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /**============================================
- *               BBolt Connection
+ *               Prometheus Metrics
  *=============================================**/
-var CountyStore *bbolt.DB
-var ZoneStore *bbolt.DB
 
-func connectToUGCStore() {
-	var err error
-	CountyStore, err = bbolt.Open("nws_county.db", 0644, nil)
-	if err != nil {
-		log.Fatal("Failed to open NWS County BBolt datastore")
+var ugcGeometryIssues = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "geo_ugc_geometry_issues_total",
+	Help: "Problems found and repaired in UGC geometry, by issue",
+}, []string{"issue"})
+
+var ugcFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "geo_ugc_failures_total",
+	Help: "UGC areas left out of an alert's geometry, by reason",
+}, []string{"reason"})
+
+var incompleteAlerts = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "geo_incomplete_alerts_total",
+	Help: "Alert geometry calculated without some of its areas",
+})
+
+var ugcLookupMisses = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "geo_ugc_lookup_misses_total",
+	Help: "UGC lookups not found in any store",
+})
+
+var buildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "geo_build_duration_seconds",
+	Help:    "Time taken to rebuild the active alert geometry",
+	Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+})
+
+var buildFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "geo_build_failures_total",
+	Help: "Rebuilds of the active alert geometry that failed",
+})
+
+var lastBuild = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "geo_last_build_timestamp_seconds",
+	Help: "When the active alert geometry was last confirmed current",
+})
+
+var unionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "geo_alert_union_seconds",
+	Help:    "Time taken to union the areas of an alert",
+	Buckets: prometheus.DefBuckets,
+})
+
+var simplifyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "geo_simplify_seconds",
+	Help:    "Time taken to repair an alert's geometry, or to simplify the collection to a detail level",
+	Buckets: prometheus.DefBuckets,
+}, []string{"level"})
+
+var topoJSONSize = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "geo_topojson_bytes",
+	Help: "Size of the MsgPack TopoJSON served from /polygons",
+})
+
+var activeAlertGeometry = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "geo_active_alerts",
+	Help: "Alerts in the active alert geometry",
+})
+
+var geometryVersionGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "geo_geometry_version",
+	Help: "Version of the active alert geometry",
+}, func() float64 {
+	return float64(geometryVersions.Version())
+})
+
+// The cache keeps its own counts, these read them at scrape time
+var geometryCacheHits = prometheus.NewCounterFunc(prometheus.CounterOpts{
+	Name: "geo_geometry_cache_hits_total",
+	Help: "Alert geometry served from the cache",
+}, func() float64 {
+	if geometryCache == nil {
+		return 0
 	}
-	ZoneStore, err = bbolt.Open("nws_zone.db", 0644, nil)
-	if err != nil {
-		log.Fatal("Failed to open NWS Zone BBolt datastore")
+	hits, _ := geometryCache.Stats()
+	return float64(hits)
+})
+
+var geometryCacheMisses = prometheus.NewCounterFunc(prometheus.CounterOpts{
+	Name: "geo_geometry_cache_misses_total",
+	Help: "Alert geometry that had to be calculated",
+}, func() float64 {
+	if geometryCache == nil {
+		return 0
 	}
+	_, misses := geometryCache.Stats()
+	return float64(misses)
+})
+
+var geometryCacheEntries = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "geo_geometry_cache_entries",
+	Help: "Alerts in the geometry cache",
+}, func() float64 {
+	if geometryCache == nil {
+		return 0
+	}
+	return float64(geometryCache.Len())
+})
+
+func init() {
+	prometheus.MustRegister(ugcGeometryIssues)
+	prometheus.MustRegister(ugcFailures)
+	prometheus.MustRegister(incompleteAlerts)
+	prometheus.MustRegister(ugcLookupMisses)
+	prometheus.MustRegister(buildDuration)
+	prometheus.MustRegister(buildFailures)
+	prometheus.MustRegister(lastBuild)
+	prometheus.MustRegister(unionDuration)
+	prometheus.MustRegister(simplifyDuration)
+	prometheus.MustRegister(topoJSONSize)
+	prometheus.MustRegister(activeAlertGeometry)
+	prometheus.MustRegister(geometryVersionGauge)
+	prometheus.MustRegister(geometryCacheHits)
+	prometheus.MustRegister(geometryCacheMisses)
+	prometheus.MustRegister(geometryCacheEntries)
 }
 
-func getUGC(ugc string) (SIREN.UGC, error) {
-	var ugcData SIREN.UGC
-	// Determine which store to use based on the UGC type
-	if ugc[2] == 'C' {
-		err := CountyStore.View(func(tx *bbolt.Tx) error {
-			b := tx.Bucket([]byte("Data"))
-			if b == nil {
-				return fmt.Errorf("bucket not found")
-			}
-			v := b.Get([]byte(ugc))
-			if v == nil {
-				return fmt.Errorf("key not found")
+/**============================================
+ *               BBolt Connection
+ *=============================================**/
+// One store per zone type, see SIREN.ZoneTypes
+var UGCStores = make(map[SIREN.ZoneType]*SIREN.UGCStore)
+
+// UGC_STORE_DIR is where the ugc-loader's stores are, e.g. a volume shared with the match service
+func connectToUGCStore() {
+	for _, zoneType := range SIREN.ZoneTypes {
+		path := SIREN.StorePath(os.Getenv("UGC_STORE_DIR"), zoneType)
+		if _, err := os.Stat(path); err != nil {
+			if zoneType == SIREN.ZoneCounty {
+				log.Fatal("Failed to open NWS County BBolt datastore", "err", err)
 			}
-			return msgpack.Unmarshal(v, &ugcData)
-		})
+			log.Warn("UGC store not found, skipping", "type", zoneType, "path", path)
+			continue
+		}
+
+		store, err := SIREN.OpenUGCStore(string(zoneType), path)
 		if err != nil {
-			log.Error("Failed to fetch UGC data", "err", err)
-			return SIREN.UGC{}, err
+			log.Fatal("Failed to open NWS BBolt datastore", "type", zoneType, "err", err)
 		}
-	} else {
-		// This is a marine zone
-		err := ZoneStore.View(func(tx *bbolt.Tx) error {
-			b := tx.Bucket([]byte("Data"))
-			if b == nil {
-				return fmt.Errorf("bucket not found")
+		UGCStores[zoneType] = store
+
+		// Pick up new datasets from the UGC loader without a restart
+		go store.Watch(30 * time.Second)
+	}
+}
+
+func closeUGCStores() {
+	for _, store := range UGCStores {
+		store.Close()
+	}
+}
+
+// Looks up a UGC in the store for its zone type. The product (e.g. "FWW") decides
+// between zone types that share codes, like public and fire weather zones.
+func getUGC(ugc string, product string) (SIREN.UGC, SIREN.ZoneType, error) {
+	order := SIREN.ZoneLookupOrder(ugc, product)
+	if len(order) == 0 {
+		return SIREN.UGC{}, "", fmt.Errorf("invalid UGC %q", ugc)
+	}
+
+	searched := make(map[SIREN.ZoneType]bool)
+	for _, zoneType := range order {
+		store, ok := UGCStores[zoneType]
+		if !ok {
+			continue
+		}
+		searched[zoneType] = true
+		if ugcData, err := store.Get(ugc); err == nil {
+			return ugcData, zoneType, nil
+		}
+	}
+
+	// Older zone stores mixed public and marine zones, so fall back to anything we haven't checked
+	if ugc[2] != 'C' {
+		for _, zoneType := range SIREN.ZoneTypes {
+			store, ok := UGCStores[zoneType]
+			if !ok || searched[zoneType] || zoneType == SIREN.ZoneCounty {
+				continue
 			}
-			v := b.Get([]byte(ugc))
-			if v == nil {
-				return fmt.Errorf("key not found")
+			if ugcData, err := store.Get(ugc); err == nil {
+				return ugcData, zoneType, nil
 			}
-			return msgpack.Unmarshal(v, &ugcData)
-		})
-		if err != nil {
-			return SIREN.UGC{}, err
 		}
 	}
 
-	return ugcData, nil
+	ugcLookupMisses.Inc()
+	log.Error("Failed to fetch UGC data", "ugc", ugc, "product", product)
+	return SIREN.UGC{}, "", fmt.Errorf("UGC %s not found", ugc)
 }
 
 /**============================================
@@ -89,50 +232,37 @@ func getUGC(ugc string) (SIREN.UGC, error) {
 
 func CalculateGeometry(areas []string, id string) (SIREN.AlertGeometry, error) {
 	var geometry SIREN.AlertGeometry
-	var ugcs []SIREN.UGC
+	product := SIREN.ProductFromIdentifier(id)
+
+	var abstractGeoms []SIREN.AbstractGeom
+	var drawnAreas []string
+	fail := func(area string, reason string) {
+		geometry.FailedAreas = append(geometry.FailedAreas, SIREN.AreaFailure{UGC: area, Reason: reason})
+		ugcFailures.WithLabelValues(reason).Inc()
+	}
 
 	for _, area := range areas {
-		ugc, err := getUGC(area)
+		ugc, _, err := getUGC(area, product)
 		if err != nil {
-			// Maybe change this so failing is a bigger deal
+			fail(area, "not_found")
 			continue
 		}
-		ugcs = append(ugcs, ugc)
-	}
-
-	// Merge all the polygons into a single polygon
-	if len(ugcs) == 0 {
-		return geometry, fmt.Errorf("no UGC found for %v", areas)
-	}
-
-	var abstractGeoms []SIREN.AbstractGeom
 
-	for _, ugc := range ugcs {
-		// First, try converting to a multipolygon (assumed to be [][][2]float64).
-		if mp, err := SIREN.ConvertToMultiPolygon(ugc.Feature); err == nil {
-			// Convert each ring in the multipolygon.
-			polygonRings := make([][][]float64, 0, len(mp))
-			for _, ring := range mp {
-				convRing := make([][]float64, len(ring))
-				for j, pt := range ring {
-					convRing[j] = []float64{pt[0], pt[1]}
-				}
-				polygonRings = append(polygonRings, convRing)
-			}
-			abstractGeom := [][][][]float64{polygonRings}
-			abstractGeoms = append(abstractGeoms, abstractGeom)
+		result, err := SIREN.ValidateGeometry(ugc.Geometry)
+		if err != nil {
+			log.Warn("Invalid UGC geometry", "ugc", area, "err", err)
+			fail(area, "invalid_geometry")
 			continue
 		}
-
-		// If multipolygon conversion fails, try converting as a simple polygon (assumed type: [][2]float64).
-		if poly, err := SIREN.ConvertToPolygon(ugc.Feature); err == nil {
-			convRing := make([][]float64, len(poly))
-			for j, pt := range poly {
-				convRing[j] = []float64{pt[0], pt[1]}
-			}
-			abstractGeom := [][][][]float64{{convRing}}
-			abstractGeoms = append(abstractGeoms, abstractGeom)
+		for _, issue := range result.Issues {
+			ugcGeometryIssues.WithLabelValues(string(issue)).Inc()
 		}
+		if len(result.Issues) > 0 {
+			log.Debug("Repaired UGC geometry", "ugc", area, "issues", result.Issues)
+		}
+
+		abstractGeoms = append(abstractGeoms, result.Geometry)
+		drawnAreas = append(drawnAreas, area)
 	}
 
 	// If we have no valid polygons, return an empty geometry
@@ -141,24 +271,106 @@ func CalculateGeometry(areas []string, id string) (SIREN.AlertGeometry, error) {
 	}
 
 	// Merge all the polygons into a single multipolygon
-	mergedPolygon := SIREN.UnionPolygons(abstractGeoms)
+	unionStart := time.Now()
+	mergedPolygon, err := SIREN.UnionPolygons(abstractGeoms)
+	if err != nil {
+		// Add the areas one at a time so only the ones that break the union are left out
+		log.Warn("Failed to merge polygons, merging one at a time", "id", id, "err", err)
+		mergedPolygon = nil
+		for i, geom := range abstractGeoms {
+			if mergedPolygon == nil {
+				// The first area is only a seed once it unions on its own, otherwise every later union fails against it
+				seed, err := SIREN.UnionPolygons([]SIREN.AbstractGeom{geom})
+				if err != nil {
+					fail(drawnAreas[i], "union_failed")
+					continue
+				}
+				mergedPolygon = seed
+				continue
+			}
+			merged, err := SIREN.UnionPolygons([]SIREN.AbstractGeom{mergedPolygon, geom})
+			if err != nil {
+				fail(drawnAreas[i], "union_failed")
+				continue
+			}
+			mergedPolygon = merged
+		}
+	}
+	unionDuration.Observe(time.Since(unionStart).Seconds())
 	if mergedPolygon == nil {
 		return geometry, fmt.Errorf("failed to merge polygons for %v", areas)
 	}
 
+	if len(geometry.FailedAreas) > 0 {
+		incompleteAlerts.Inc()
+		log.Warn("Alert is drawn without some of its areas", "id", id, "failed", geometry.FailedAreas)
+	}
+
 	geometry.GeometryType = "MultiPolygon"
-	simplified := SIREN.SimplifyMultiPolygon(mergedPolygon, 0.1)
-	if simplified == nil {
-		return geometry, fmt.Errorf("failed to simplify multipolygon for %v", areas)
+	// Keep the full detail, the endpoints simplify it to the level each request asks for
+	simplifyStart := time.Now()
+	repaired := SIREN.SimplifyMultiPolygon(mergedPolygon, SIREN.DetailFull.Tolerance())
+	simplifyDuration.WithLabelValues(string(SIREN.DetailFull)).Observe(time.Since(simplifyStart).Seconds())
+	if repaired == nil {
+		return geometry, fmt.Errorf("failed to repair multipolygon for %v", areas)
 	}
-	geometry.Coordinates = simplified
+	geometry.Coordinates = repaired
 	geometry.Identifier = id
 	return geometry, nil
 }
 
-func CreateGeometryForActives() (geojson.FeatureCollection, error) {
+/**============================================
+ *               Geometry Cache
+ *=============================================**/
+
+var geometryCache *SIREN.GeometryCache
+
+func createGeometryCache() {
+	size := 2048
+	if v, err := strconv.Atoi(os.Getenv("GEOMETRY_CACHE_SIZE")); err == nil && v > 0 {
+		size = v
+	}
+
+	var err error
+	// GEOMETRY_CACHE_PATH is optional, without it the cache only lives in memory
+	geometryCache, err = SIREN.NewGeometryCache(size, os.Getenv("GEOMETRY_CACHE_PATH"))
+	if err != nil {
+		log.Fatal("Failed to open the geometry cache", "err", err)
+	}
+}
+
+// The versions of the loaded UGC datasets, so a new dataset invalidates the cache
+func datasetVersions() string {
+	versions := make([]string, 0, len(UGCStores))
+	for _, zoneType := range SIREN.ZoneTypes {
+		if store, ok := UGCStores[zoneType]; ok {
+			versions = append(versions, fmt.Sprintf("%s:%d", zoneType, store.Metadata().Version))
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// Gets the geometry for an alert, only recalculating it if its areas have changed
+func getAlertGeometry(alert SIREN.SirenAlert) (SIREN.AlertGeometry, error) {
+	areaHash := SIREN.AreaSetHash(alert.Areas, fmt.Sprintf("%d|%s", SIREN.GeometryRevision, datasetVersions()))
+	if geometry, ok := geometryCache.Get(alert.Identifier, areaHash); ok {
+		return geometry, nil
+	}
+
+	geometry, err := CalculateGeometry(alert.Areas, alert.Identifier)
+	if err != nil {
+		return geometry, err
+	}
+	geometryCache.Put(alert.Identifier, areaHash, geometry)
+	return geometry, nil
+}
+
+// Calculates the geometry of every active alert, along with the hash of the alerts it was
+// built from. The hash is nil when an alert's geometry failed, so the next rebuild tries again.
+func CreateGeometryForActives(ctx context.Context) (geojson.FeatureCollection, []byte, error) {
 	log.Debug("Calculating geometry for active alerts...")
-	cursor, err := stateCollection.Aggregate(context.TODO(), bson.A{
+	aggregateCtx, aggregateSpan := tracer.Start(ctx, "mongo.aggregate state", trace.WithSpanKind(trace.SpanKindClient))
+	cursor, err := stateCollection.Aggregate(aggregateCtx, bson.A{
 		bson.M{"$match": bson.M{"state": "Active"}},
 		bson.M{
 			"$lookup": bson.M{
@@ -172,41 +384,57 @@ func CreateGeometryForActives() (geojson.FeatureCollection, error) {
 	})
 	if err != nil {
 		log.Error("Failed to aggregate active alerts", "err", err)
-		return geojson.FeatureCollection{}, err
+		aggregateSpan.SetStatus(codes.Error, err.Error())
+		aggregateSpan.End()
+		return geojson.FeatureCollection{}, nil, err
 	}
 	defer cursor.Close(context.TODO())
 
 	var activeAlerts []SIREN.SirenAlert
-	if err := cursor.All(context.TODO(), &activeAlerts); err != nil {
+	if err := cursor.All(aggregateCtx, &activeAlerts); err != nil {
 		log.Error("Failed to decode active alerts", "err", err)
-		return geojson.FeatureCollection{}, err
+		aggregateSpan.SetStatus(codes.Error, err.Error())
+		aggregateSpan.End()
+		return geojson.FeatureCollection{}, nil, err
 	}
+	aggregateSpan.End()
 
-	//Create a , seperated list of active alerts
+	//Create a , seperated list of active alerts and their areas, so an alert changing areas is picked up.
+	//Tiles carry the event and severity, so a change to either is picked up too
 	activeAlertsIds := make([]string, 0, len(activeAlerts))
 	for _, alert := range activeAlerts {
-		activeAlertsIds = append(activeAlertsIds, alert.Identifier)
+		var event, severity string
+		if alert.CapInfo != nil {
+			event, severity = alert.CapInfo.Info.Event, alert.CapInfo.Info.Severity
+		}
+		activeAlertsIds = append(activeAlertsIds, alert.Identifier+":"+SIREN.AreaSetHash(alert.Areas, "")+":"+event+":"+severity)
 	}
 	sort.Strings(activeAlertsIds)
-	//Join with a comma
-	activeAlertsIdsStr := strings.Join(activeAlertsIds, ",")
+	//Join with a comma, with the dataset versions so a reloaded UGC store rebuilds the geometry
+	activeAlertsIdsStr := datasetVersions() + "|" + strings.Join(activeAlertsIds, ",")
 	hash := sha256.Sum256([]byte(activeAlertsIdsStr))
 	if bytes.Equal(hash[:], lastActiveAlertsHash) {
-		return geojson.FeatureCollection{}, SIREN.NotNeededError{Msg: "No new active alerts to process"}
+		return geojson.FeatureCollection{}, nil, SIREN.NotNeededError{Msg: "No new active alerts to process"}
 	}
+	builtHash := hash[:]
 
-	lastActiveAlertsHash = hash[:]
+	_, geometrySpan := tracer.Start(ctx, "geometry.calculate", trace.WithAttributes(attribute.Int("alerts", len(activeAlerts))))
+	defer geometrySpan.End()
 
 	geometryList := make([]SIREN.AlertGeometry, 0)
 	// Iterate over the results and calculate geometry for each active alert
 	for _, alert := range activeAlerts {
 		// Calculate geometry for the alert
 		if alert.CapInfo != nil && alert.CapInfo.Info.Area.Polygon == nil {
-			geometry, err := CalculateGeometry(alert.Areas, alert.Identifier)
+			geometry, err := getAlertGeometry(alert)
 			if err != nil {
 				log.Error("Failed to calculate geometry", "err", err)
+				builtHash = nil
 				continue
 			}
+			// These can change without the areas changing, so they aren't cached
+			geometry.Event = alert.CapInfo.Info.Event
+			geometry.Severity = alert.CapInfo.Info.Severity
 			geometryList = append(geometryList, geometry)
 		}
 	}
@@ -216,7 +444,7 @@ func CreateGeometryForActives() (geojson.FeatureCollection, error) {
 	geoJSON := SIREN.CreateGeoJSON(geometryList)
 	log.Info("Geometry calculation for active alerts completed.")
 
-	return geoJSON, nil
+	return geoJSON, builtHash, nil
 }
 
 func CreateGeometryForMultiple(alertIds []string) (geojson.FeatureCollection, error) {
@@ -235,7 +463,7 @@ func CreateGeometryForMultiple(alertIds []string) (geojson.FeatureCollection, er
 
 	var geometries []SIREN.AlertGeometry
 	for _, alert := range alerts {
-		geometry, err := CalculateGeometry(alert.Areas, alert.Identifier)
+		geometry, err := getAlertGeometry(alert)
 		if err != nil {
 			log.Error("Failed to calculate geometry for alert", "alertId", alert.Identifier, "err", err)
 			continue
@@ -275,31 +503,460 @@ func ConnectToMongo() {
 	stateCollection = client.Database("siren").Collection("state")
 }
 
+/**============================================
+ *            State Change Stream
+ *=============================================**/
+
+// Products that skip the debounce, these need to be on the map as soon as possible
+var urgentProducts = map[string]bool{
+	"TOW": true, // Tornado Warning
+}
+
+// Holds a pending rebuild, true if any of the changes were urgent
+var rebuildRequests = make(chan bool, 1)
+
+func requestRebuild(urgent bool) {
+	for {
+		select {
+		case rebuildRequests <- urgent:
+			return
+		default:
+		}
+
+		// A rebuild is already pending, merge into it
+		select {
+		case pending := <-rebuildRequests:
+			urgent = urgent || pending
+		default:
+		}
+	}
+}
+
+// Runs a rebuild for each burst of changes. Tracking updates an alert several times
+// while processing a single CAP, so changes are collected for the debounce window
+// before rebuilding, unless one of them is urgent.
+func RunRebuilder(debounce time.Duration) {
+	for urgent := range rebuildRequests {
+		if !urgent {
+			timer := time.NewTimer(debounce)
+		collect:
+			for {
+				select {
+				case pending := <-rebuildRequests:
+					if pending {
+						break collect
+					}
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+		}
+		ctx, span := tracer.Start(context.Background(), "geometry.rebuild", trace.WithLinks(takeTraceLinks()...))
+		ConstructTopoJSON(ctx)
+		span.End()
+	}
+}
+
+type stateChangeEvent struct {
+	OperationType string            `bson:"operationType"`
+	FullDocument  *SIREN.SirenAlert `bson:"fullDocument,omitempty"`
+}
+
+// Error code MongoDB returns when change streams are used on a standalone server
+const changeStreamNotSupported = 40573
+
+// Error codes MongoDB returns when a change stream can't resume from its token,
+// InvalidResumeToken, ChangeStreamFatalError and ChangeStreamHistoryLost
+var resumeTokenLost = []int{260, 280, 286}
+
+// Whether the stream can't be resumed, the oplog has moved past the token or the token is no good
+func isResumeTokenLost(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range resumeTokenLost {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watches the state collection and requests a rebuild whenever an alert changes.
+// Change streams need a replica set, on a standalone server this falls back to polling.
+func WatchStateChanges(ctx context.Context, pollInterval time.Duration) {
+	var resumeToken any
+	backoff := time.Second
+
+	for {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := stateCollection.Watch(ctx, bson.A{
+			bson.M{"$match": bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}},
+		}, opts)
+		if err != nil {
+			var serverErr mongo.ServerError
+			if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamNotSupported) {
+				log.Warn("MongoDB does not support change streams, polling for geometry changes instead", "interval", pollInterval)
+				ScheduleTopoJSON(pollInterval)
+				return
+			}
+			if resumeToken != nil && isResumeTokenLost(err) {
+				// Opening the stream again without the token rebuilds everything
+				log.Warn("Can't resume the state change stream, starting over", "err", err)
+				resumeToken = nil
+				continue
+			}
+
+			log.Error("Failed to open state change stream", "err", err, "retry", backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+
+		log.Info("Watching state collection for changes")
+		backoff = time.Second
+		// Catch anything that changed while we weren't watching
+		requestRebuild(false)
+
+		for stream.Next(ctx) {
+			resumeToken = stream.ResumeToken()
+
+			var event stateChangeEvent
+			if err := stream.Decode(&event); err != nil {
+				log.Warn("Failed to decode state change", "err", err)
+				requestRebuild(false)
+				continue
+			}
+
+			urgent := false
+			if event.FullDocument != nil {
+				addTraceLink(event.FullDocument.TraceParent)
+				urgent = urgentProducts[SIREN.ProductFromIdentifier(event.FullDocument.Identifier)]
+				log.Debug("State changed", "op", event.OperationType, "id", event.FullDocument.Identifier, "urgent", urgent)
+			}
+			requestRebuild(urgent)
+		}
+
+		err = stream.Err()
+		stream.Close(context.TODO())
+
+		if ctx.Err() != nil {
+			return
+		}
+		if isResumeTokenLost(err) {
+			// The changes since the token are gone, open the stream fresh and rebuild everything
+			log.Warn("State change stream history lost, starting over", "err", err)
+			resumeToken = nil
+			continue
+		}
+		if err != nil {
+			log.Error("State change stream closed", "err", err)
+		}
+		time.Sleep(backoff)
+	}
+}
+
+/**============================================
+ *               Health Checks
+ *=============================================**/
+
+// The safety net rebuilds every 10 minutes, so anything older means rebuilds are failing
+var staleAfter = 15 * time.Minute
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// The process is up and serving, nothing else is checked
+func HandleHealthz(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte("ok"))
+}
+
+// Ready when Mongo answers, the UGC stores are readable and the geometry isn't stale
+func HandleReadyz(res http.ResponseWriter, req *http.Request) {
+	result := readiness{Status: "ok", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			result.Status = "unavailable"
+			result.Checks[name] = err.Error()
+			return
+		}
+		result.Checks[name] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 2*time.Second)
+	defer cancel()
+	check("mongo", client.Ping(ctx, nil))
+
+	if _, ok := UGCStores[SIREN.ZoneCounty]; !ok {
+		check("store:"+string(SIREN.ZoneCounty), errors.New("not loaded"))
+	}
+	for zoneType, store := range UGCStores {
+		check("store:"+string(zoneType), store.Check())
+	}
+
+	var geometryErr error
+	if built := lastBuildTime.Load(); built == 0 {
+		geometryErr = errors.New("not built yet")
+	} else if age := time.Since(time.Unix(built, 0)); age > staleAfter {
+		geometryErr = fmt.Errorf("stale, last built %s ago", age.Round(time.Second))
+	}
+	check("geometry", geometryErr)
+
+	res.Header().Set("Content-Type", "application/json")
+	if result.Status != "ok" {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		res.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(res).Encode(result)
+}
+
 /**============================================
  *               Driver Code
  *=============================================**/
 var topoRWMutex sync.RWMutex
+
+// Only one rebuild runs at a time, readers are only blocked while the result is swapped in
+var buildMutex sync.Mutex
+
+// Unix time the geometry was last confirmed current, zero until the first build
+var lastBuildTime atomic.Int64
 var lastActiveAlertsHash []byte
-var topoData []byte
+var topoVersion uint64
 
-func HandleGeoRequest(res http.ResponseWriter, req *http.Request) {
+// A way of returning the active geometry
+type representation struct {
+	level  SIREN.DetailLevel
+	format SIREN.OutputFormat
+}
+
+// What the web client gets when it doesn't ask for anything else
+var defaultRepresentation = representation{level: SIREN.DefaultDetail, format: SIREN.FormatMsgPack}
+
+// The full detail active features, and each level and encoding of them made for this version
+var topoCollection geojson.FeatureCollection
+var levelCollections = make(map[SIREN.DetailLevel]geojson.FeatureCollection)
+var formatCache = make(map[representation][]byte)
+
+// Remembers recent versions of the active geometry for /polygons/delta
+var geometryVersions = SIREN.NewGeometryVersions(64)
+
+// Cuts the active geometry into vector tiles for /tiles
+var tileSource = SIREN.NewTileSource()
+
+// Sets the headers shared by the versioned geometry endpoints
+func setVersionHeaders(res http.ResponseWriter, version uint64) string {
+	etag := fmt.Sprintf(`"%d"`, version)
+	res.Header().Set("ETag", etag)
+	res.Header().Set("X-Geometry-Version", strconv.FormatUint(version, 10))
+	// Clients may keep a copy but must check it is still current
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, If-None-Match")
+	res.Header().Set("Access-Control-Expose-Headers", "ETag, X-Geometry-Version, Content-Disposition")
+	return etag
+}
+
+// Checks an If-None-Match header against our ETag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Picks the detail level and output format for a geometry request, writing the error response if there is none
+func negotiateRepresentation(res http.ResponseWriter, req *http.Request) (representation, bool) {
+	level, err := SIREN.ParseDetailLevel(req.URL.Query().Get("detail"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return representation{}, false
+	}
+
+	format, err := SIREN.NegotiateFormat(req.URL.Query().Get("format"), req.Header.Get("Accept"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotAcceptable)
+		return representation{}, false
+	}
+	return representation{level: level, format: format}, true
+}
+
+// Each representation of a version gets its own ETag, the default keeps the plain version
+func representationETag(version uint64, rep representation) string {
+	if rep == defaultRepresentation {
+		return fmt.Sprintf(`"%d"`, version)
+	}
+	return fmt.Sprintf(`"%d-%s-%s"`, version, rep.level, rep.format)
+}
+
+// Writes encoded geometry, formats meant for desktop GIS are sent as a download
+func writeGeometry(res http.ResponseWriter, format SIREN.OutputFormat, name string, data []byte) {
+	res.Header().Set("Content-Type", format.ContentType())
+	if format == SIREN.FormatKML || format == SIREN.FormatShapefile {
+		res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format.FileExtension()))
+	}
+	res.WriteHeader(http.StatusOK)
+	res.Write(data)
+}
+
+func simplifyCollection(collection geojson.FeatureCollection, level SIREN.DetailLevel) geojson.FeatureCollection {
+	start := time.Now()
+	defer func() { simplifyDuration.WithLabelValues(string(level)).Observe(time.Since(start).Seconds()) }()
+	return level.Simplify(collection)
+}
+
+// Returns the active geometry at the given detail, simplifying it at most once per version
+func activeCollection(level SIREN.DetailLevel) (geojson.FeatureCollection, uint64) {
+	topoRWMutex.RLock()
+	version := topoVersion
+	full := topoCollection
+	collection, ok := levelCollections[level]
+	topoRWMutex.RUnlock()
+	if ok {
+		return collection, version
+	}
+
+	collection = simplifyCollection(full, level)
+
+	topoRWMutex.Lock()
+	// Only keep it if the geometry wasn't replaced while we were simplifying
+	if topoVersion == version {
+		levelCollections[level] = collection
+	}
+	topoRWMutex.Unlock()
+	return collection, version
+}
 
+// Returns the active geometry in the given representation, encoding it at most once per version
+func activeGeometry(rep representation) ([]byte, uint64, error) {
 	topoRWMutex.RLock()
-	data := topoData
+	version := topoVersion
+	data, ok := formatCache[rep]
 	topoRWMutex.RUnlock()
+	if ok {
+		return data, version, nil
+	}
+
+	collection, collectionVersion := activeCollection(rep.level)
+	data, err := SIREN.EncodeFeatureCollection(rep.format, collection)
+	if err != nil {
+		return nil, collectionVersion, err
+	}
+
+	topoRWMutex.Lock()
+	if topoVersion == collectionVersion {
+		formatCache[rep] = data
+	}
+	topoRWMutex.Unlock()
+	return data, collectionVersion, nil
+}
+
+func HandleGeoRequest(res http.ResponseWriter, req *http.Request) {
+	rep, ok := negotiateRepresentation(res, req)
+	if !ok {
+		return
+	}
+
+	data, version, err := activeGeometry(rep)
+	if err != nil {
+		log.Error("Failed to encode active geometry", "format", rep.format, "detail", rep.level, "err", err)
+		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
+		return
+	}
+
+	setVersionHeaders(res, version)
+	etag := representationETag(version, rep)
+	res.Header().Set("ETag", etag)
+	res.Header().Set("Vary", "Accept")
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeGeometry(res, rep.format, "siren_active_alerts", data)
+}
+
+// Returns the GeoJSON features added, changed and removed since the version in ?since=
+func HandleDeltaRequest(res http.ResponseWriter, req *http.Request) {
+	since, err := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(res, "Invalid since version", http.StatusBadRequest)
+		return
+	}
+
+	delta, err := geometryVersions.Delta(since)
+	etag := setVersionHeaders(res, delta.Version)
+	if err != nil {
+		if _, ok := err.(SIREN.ErrVersionTooOld); ok {
+			// The client has to start over from /polygons
+			http.Error(res, err.Error(), http.StatusGone)
+			return
+		}
+		http.Error(res, "Failed to create delta", http.StatusInternalServerError)
+		return
+	}
+
+	if since == delta.Version || etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Patch at the same detail the client got the full collection at
+	level, err := SIREN.ParseDetailLevel(req.URL.Query().Get("detail"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delta.Added = simplifyFeatures(delta.Added, level)
+	delta.Changed = simplifyFeatures(delta.Changed, level)
+
+	// Round trip through JSON so the features keep their GeoJSON shape in MsgPack
+	b, err := json.Marshal(delta)
+	if err != nil {
+		http.Error(res, "Failed to create delta", http.StatusInternalServerError)
+		return
+	}
+	var jsonObject map[string]any
+	if err := json.Unmarshal(b, &jsonObject); err != nil {
+		http.Error(res, "Failed to create delta", http.StatusInternalServerError)
+		return
+	}
+	data, err := msgpack.Marshal(jsonObject)
+	if err != nil {
+		http.Error(res, "Failed to create delta", http.StatusInternalServerError)
+		return
+	}
 
 	res.Header().Set("Content-Type", "application/msgpack")
-	res.Header().Set("Access-Control-Allow-Origin", "*")
-	res.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	res.WriteHeader(http.StatusOK)
-
 	res.Write(data)
 }
 
+func simplifyFeatures(features []*geojson.Feature, level SIREN.DetailLevel) []*geojson.Feature {
+	collection := simplifyCollection(geojson.FeatureCollection{Type: "FeatureCollection", Features: features}, level)
+	if collection.Features == nil {
+		return []*geojson.Feature{}
+	}
+	return collection.Features
+}
+
 func HandleSingleGeoRequest(res http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodOptions {
 		res.Header().Set("Access-Control-Allow-Origin", "*")
-		res.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		res.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
 		res.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 		res.WriteHeader(http.StatusNoContent)
 		return
@@ -310,6 +967,11 @@ func HandleSingleGeoRequest(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	rep, ok := negotiateRepresentation(res, req)
+	if !ok {
+		return
+	}
+
 	var alertIds []string
 	if err := json.NewDecoder(req.Body).Decode(&alertIds); err != nil {
 		http.Error(res, "Invalid request body", http.StatusBadRequest)
@@ -327,128 +989,329 @@ func HandleSingleGeoRequest(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	b, err := json.Marshal(geoJSON)
+	geoJSON = simplifyCollection(geoJSON, rep.level)
+	data, err := SIREN.EncodeFeatureCollection(rep.format, geoJSON)
 	if err != nil {
+		log.Error("Failed to encode geometry", "format", rep.format, "err", err)
 		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
 		return
 	}
 
-	goGeoJSON, err := geojson.UnmarshalFeatureCollection(b)
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	res.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+	writeGeometry(res, rep.format, "siren_alerts", data)
+}
+
+// Serves /tiles/{z}/{x}/{y}.mvt, a Mapbox Vector Tile of the active alerts
+func HandleTileRequest(res http.ResponseWriter, req *http.Request) {
+	z, errZ := strconv.ParseUint(req.PathValue("z"), 10, 32)
+	x, errX := strconv.ParseUint(req.PathValue("x"), 10, 32)
+	yPath, hasSuffix := strings.CutSuffix(req.PathValue("y"), ".mvt")
+	y, errY := strconv.ParseUint(yPath, 10, 32)
+	if errZ != nil || errX != nil || errY != nil || !hasSuffix {
+		http.Error(res, "Invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	data, version, err := tileSource.Tile(uint32(z), uint32(x), uint32(y))
 	if err != nil {
-		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	topology := topojson.NewTopology(goGeoJSON, nil)
-	topoJson, err := topology.MarshalJSON()
+	etag := setVersionHeaders(res, version)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	if len(data) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+	res.Write(data)
+}
+
+/**============================================
+ *                 Storm Motion
+ *=============================================**/
+
+type arrivalResponse struct {
+	SIREN.ArrivalWindow
+	Minutes int    `json:"minutes"` // Until the storm arrives, 0 when it is already there
+	Summary string `json:"summary"` // e.g. arriving near you in ~12 minutes
+}
+
+type motionResponse struct {
+	Identifier string            `json:"identifier"`
+	Expires    time.Time         `json:"expires"`
+	Motion     SIREN.StormMotion `json:"motion"`
+	Position   []orb.Point       `json:"position"` // Where the storm is projected to be now
+	Swath      *geojson.Feature  `json:"swath"`
+	Arrival    *arrivalResponse  `json:"arrival,omitempty"`
+}
+
+// Reads an alert with the CAP it was last updated by
+func findAlertWithCap(ctx context.Context, id string) (SIREN.SirenAlert, error) {
+	cursor, err := stateCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"identifier": id}},
+		bson.M{
+			"$lookup": bson.M{
+				"from":         "alerts",
+				"localField":   "mostRecentCAP",
+				"foreignField": "identifier",
+				"as":           "capInfo",
+			},
+		},
+		bson.M{"$unwind": "$capInfo"},
+	})
 	if err != nil {
-		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
+		return SIREN.SirenAlert{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var alerts []SIREN.SirenAlert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return SIREN.SirenAlert{}, err
+	}
+	if len(alerts) == 0 {
+		return SIREN.SirenAlert{}, mongo.ErrNoDocuments
+	}
+	return alerts[0], nil
+}
+
+// Reads the point to estimate an arrival for, either lat and lon or a UGC's centroid
+func motionQueryPoint(req *http.Request, product string) (orb.Point, bool, error) {
+	query := req.URL.Query()
+	if ugc := query.Get("ugc"); ugc != "" {
+		ugcData, _, err := getUGC(strings.ToUpper(ugc), product)
+		if err != nil {
+			return orb.Point{}, false, err
+		}
+		return orb.Point{ugcData.Lon, ugcData.Lat}, true, nil
+	}
+	if query.Get("lat") == "" && query.Get("lon") == "" {
+		return orb.Point{}, false, nil
+	}
+	lat, errLat := strconv.ParseFloat(query.Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(query.Get("lon"), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return orb.Point{}, false, errors.New("invalid lat or lon")
+	}
+	return orb.Point{lon, lat}, true, nil
+}
+
+// Serves /motion/{id}, the projected path of the storm an alert is for until it expires.
+// With ?lat=&lon= or ?ugc= it also estimates when the storm reaches that point.
+func HandleMotionRequest(res http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	ctx, span := tracer.Start(req.Context(), "motion.project", trace.WithAttributes(attribute.String("siren.id", id)))
+	defer span.End()
+
+	alert, err := findAlertWithCap(ctx, id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(res, "Alert not found", http.StatusNotFound)
+			return
+		}
+		log.Error("Failed to find alert", "id", id, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(res, "Failed to find alert", http.StatusInternalServerError)
 		return
 	}
 
-	// Convert to any
-	var jsonObject map[string]any
-	err = json.Unmarshal(topoJson, &jsonObject)
+	var description *NWS.EventMotionDescription
+	if alert.CapInfo != nil && alert.CapInfo.Info.Parameters != nil {
+		description = alert.CapInfo.Info.Parameters.EventMotionDescription
+	}
+	motion, err := SIREN.ParseMotion(description)
 	if err != nil {
-		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
+		http.Error(res, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Convert to MsgPack
-	topoData, err = msgpack.Marshal(jsonObject)
+	point, hasPoint, err := motionQueryPoint(req, SIREN.ProductFromIdentifier(id))
 	if err != nil {
-		http.Error(res, "Failed to create geometry", http.StatusInternalServerError)
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	res.Header().Set("Content-Type", "application/msgpack")
+	now := time.Now()
+	swath, err := motion.Swath(alert.Expires)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	response := motionResponse{
+		Identifier: alert.Identifier,
+		Expires:    alert.Expires,
+		Motion:     motion,
+		Position:   motion.PositionAt(now),
+		Swath:      geojson.NewFeature(geojson.NewMultiPolygonGeometry(swath...)),
+	}
+	response.Swath.Properties["id"] = alert.Identifier
+
+	if hasPoint {
+		if window, ok := motion.Arrival(point, now, alert.Expires); ok {
+			response.Arrival = &arrivalResponse{
+				ArrivalWindow: window,
+				Minutes:       max(0, int(math.Round(window.Start.Sub(now).Minutes()))),
+				Summary:       window.Summary(now),
+			}
+		}
+	}
+
 	res.Header().Set("Access-Control-Allow-Origin", "*")
-	res.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	res.WriteHeader(http.StatusOK)
-	res.Write(topoData)
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(response); err != nil {
+		log.Error("Failed to encode storm motion", "id", id, "err", err)
+	}
 }
 
 func ScheduleTopoJSON(duration time.Duration) {
-	// Schedule the function to run every 5 minutes
 	ticker := time.NewTicker(duration)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		ConstructTopoJSON()
+		requestRebuild(false)
 	}
 }
 
-func ConstructTopoJSON() {
-	topoRWMutex.Lock()
-	defer topoRWMutex.Unlock()
+func ConstructTopoJSON(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+
+	_, lockSpan := tracer.Start(ctx, "build.lock_wait")
+	buildMutex.Lock()
+	lockSpan.End()
+	defer buildMutex.Unlock()
 
 	log.Debug("Creating TopoJSON...")
-	orbGeoJSON, err := CreateGeometryForActives()
+	start := time.Now()
+	orbGeoJSON, builtHash, err := CreateGeometryForActives(ctx)
 	if err != nil {
 		if _, ok := err.(SIREN.NotNeededError); ok {
 			log.Debug("No new active alerts to process")
+			markBuilt()
 			return
 		}
 
+		buildFailures.Inc()
+		span.SetStatus(codes.Error, err.Error())
 		log.Error("Failed to create geometry for active alerts", "err", err)
 		return
 	}
+	defer func() { buildDuration.Observe(time.Since(start).Seconds()) }()
 
-	// Convert orb geojson.FeatureCollection to go.geojson.FeatureCollection via JSON marshalling/unmarshalling
-	b, err := json.Marshal(orbGeoJSON)
+	log.Debug("Serializing to MsgPack")
+	// The web client gets TopoJSON as MsgPack, so encode that up front
+	_, encodeSpan := tracer.Start(ctx, "geometry.encode")
+	defaultCollection := simplifyCollection(orbGeoJSON, defaultRepresentation.level)
+	data, err := SIREN.EncodeFeatureCollection(defaultRepresentation.format, defaultCollection)
+	encodeSpan.End()
 	if err != nil {
-		log.Error("Failed to marshal orb geojson", "err", err)
+		buildFailures.Inc()
+		span.SetStatus(codes.Error, err.Error())
+		log.Error("Failed to encode TopoJSON to MsgPack", "err", err)
 		return
 	}
 
-	goGeoJSON, err := geojson.UnmarshalFeatureCollection(b)
-	if err != nil {
-		log.Error("Failed to unmarshal to go geojson", "err", err)
+	version, changed := geometryVersions.Publish(orbGeoJSON)
+	if !changed {
+		log.Debug("Active geometry unchanged", "version", version)
+		markComplete(builtHash)
 		return
 	}
 
-	topology := topojson.NewTopology(goGeoJSON, nil)
-	// Convert the topology to TopoJSON
-	topoJson, err := topology.MarshalJSON()
-	if err != nil {
-		log.Error("Failed to marshal TopoJSON", "err", err)
-		return
-	}
+	topoRWMutex.Lock()
+	topoVersion = version
+	topoCollection = orbGeoJSON
+	levelCollections = map[SIREN.DetailLevel]geojson.FeatureCollection{defaultRepresentation.level: defaultCollection}
+	formatCache = map[representation][]byte{defaultRepresentation: data}
+	topoRWMutex.Unlock()
 
-	//Convert to any
-	var jsonObject map[string]any
-	err = json.Unmarshal(topoJson, &jsonObject)
-	if err != nil {
-		log.Error("Failed to unmarshal TopoJSON", "err", err)
-		return
-	}
+	tileSource.Update(version, orbGeoJSON)
 
-	log.Debug("Serializing to MsgPack")
-	// Convert to MsgPack
-	topoData, err = msgpack.Marshal(jsonObject)
-	if err != nil {
-		log.Error("Failed to marshal TopoJSON to MsgPack", "err", err)
+	span.SetAttributes(attribute.Int64("geometry.version", int64(version)))
+	topoJSONSize.Set(float64(len(data)))
+	activeAlertGeometry.Set(float64(len(orbGeoJSON.Features)))
+	markComplete(builtHash)
+
+	log.Debug("Successfully serialized TopoJSON to MsgPack", "version", version)
+}
+
+// Remembers the alerts the served geometry was built from, so the next rebuild can skip
+// them if nothing changed. Geometry missing an alert isn't current, it is served but
+// the next rebuild tries again and the build isn't counted as fresh.
+func markComplete(builtHash []byte) {
+	if builtHash == nil {
+		lastActiveAlertsHash = nil
+		buildFailures.Inc()
 		return
 	}
+	lastActiveAlertsHash = builtHash
+	markBuilt()
+}
 
-	log.Debug("Successfully serialized TopoJSON to MsgPack")
+// Records that the served geometry matches the active alerts as of now
+func markBuilt() {
+	now := time.Now()
+	lastBuildTime.Store(now.Unix())
+	lastBuild.Set(float64(now.Unix()))
 }
 
 func main() {
 	lastActiveAlertsHash = make([]byte, 32)
 	log.SetLevel(log.DebugLevel)
 
+	shutdownTracing := initTracing("geo-service")
+	defer shutdownTracing(context.Background())
+
 	ConnectToMongo()
 	defer client.Disconnect(context.TODO())
 
 	connectToUGCStore()
-	defer CountyStore.Close()
-	defer ZoneStore.Close()
+	defer closeUGCStores()
+
+	createGeometryCache()
+	defer geometryCache.Close()
 
 	http.HandleFunc("/polygons", HandleGeoRequest)
+	http.HandleFunc("/polygons/delta", HandleDeltaRequest)
+	http.HandleFunc("/tiles/{z}/{x}/{y}", HandleTileRequest)
 	http.HandleFunc("/polygon", HandleSingleGeoRequest)
+	http.HandleFunc("/motion/{id}", HandleMotionRequest)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", HandleHealthz)
+	http.HandleFunc("/readyz", HandleReadyz)
+
+	// GEOMETRY_TOLERANCE_STANDARD, _MEDIUM and _COARSE override the tolerances in degrees,
+	// GEOMETRY_TOLERANCE_LEGACY the Visvalingam threshold
+	for _, level := range SIREN.DetailLevels {
+		if v, err := strconv.ParseFloat(os.Getenv("GEOMETRY_TOLERANCE_"+strings.ToUpper(string(level))), 64); err == nil && v >= 0 {
+			SIREN.DetailTolerances[level] = v
+		}
+	}
 
-	go ConstructTopoJSON()
-	go ScheduleTopoJSON(1 * time.Minute)
-	http.ListenAndServe(":6906", nil)
+	// GEOMETRY_STALE_AFTER is how long since the last build before /readyz fails
+	if v, err := time.ParseDuration(os.Getenv("GEOMETRY_STALE_AFTER")); err == nil {
+		staleAfter = v
+	}
+
+	debounce := 2 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("GEOMETRY_DEBOUNCE")); err == nil {
+		debounce = v
+	}
+	go RunRebuilder(debounce)
+	requestRebuild(true)
+
+	go WatchStateChanges(context.Background(), 1*time.Minute)
+	// Keep a slow safety net in case a change is missed while the stream reconnects
+	go ScheduleTopoJSON(10 * time.Minute)
+
+	if err := http.ListenAndServe(":6906", nil); err != nil {
+		log.Fatal("HTTP server stopped", "err", err)
+	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("geoService")
+
+// Sets up trace export to the collector at OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318.
+// Without an endpoint nothing is exported, but spans still get ids so the trace context
+// passed to the other services links their spans to ours.
+func initTracing(serviceName string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			log.Error("Failed to create trace exporter, traces will not be exported", "err", err)
+		} else {
+			options = append(options, sdktrace.WithBatcher(exporter))
+			log.Info("Exporting traces to the OpenTelemetry collector")
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// A rebuild covers every change in its debounce window, so instead of continuing one
+// alert's trace it links to the traces of all the changes that went into it
+const maxTraceLinks = 128
+
+var traceLinksMu sync.Mutex
+var pendingTraceLinks []trace.Link
+
+// Remembers the trace that changed a state document, from the traceparent tracking stored on it
+func addTraceLink(traceParent string) {
+	if traceParent == "" {
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier{"traceparent": traceParent})
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return
+	}
+
+	traceLinksMu.Lock()
+	defer traceLinksMu.Unlock()
+	if len(pendingTraceLinks) < maxTraceLinks {
+		pendingTraceLinks = append(pendingTraceLinks, trace.Link{SpanContext: spanContext})
+	}
+}
+
+func takeTraceLinks() []trace.Link {
+	traceLinksMu.Lock()
+	defer traceLinksMu.Unlock()
+	links := pendingTraceLinks
+	pendingTraceLinks = nil
+	return links
+}
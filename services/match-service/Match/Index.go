@@ -0,0 +1,326 @@
+package Match
+
+import (
+	geo "geoService/SIREN"
+	"matchService/SIREN"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// Index keeps every subscription in memory behind inverted indexes, so matching an alert
+// only looks at the subscriptions for its areas and the grid cells under its polygon
+type Index struct {
+	mu      sync.RWMutex
+	next    uint32
+	handles map[string]uint32
+	entries map[uint32]*entry
+
+	byUGC      map[string]posting
+	bySAME     map[string]posting // By SSCCC, the part of the county is checked when matching
+	byResolved map[string]posting // The UGCs the points and polygons fall in
+	grid       map[cell]posting   // The cells under the points and polygons
+}
+
+// Subscriptions are kept by a small handle rather than their id to keep the postings small
+type posting map[uint32]struct{}
+
+type entry struct {
+	sub      Subscription
+	shapes   []Shape
+	cells    []cell
+	severity int
+	events   map[string]bool
+}
+
+func NewIndex() *Index {
+	return &Index{
+		handles:    make(map[string]uint32),
+		entries:    make(map[uint32]*entry),
+		byUGC:      make(map[string]posting),
+		bySAME:     make(map[string]posting),
+		byResolved: make(map[string]posting),
+		grid:       make(map[cell]posting),
+	}
+}
+
+func (p posting) add(handle uint32) {
+	p[handle] = struct{}{}
+}
+
+func addTo[K comparable](postings map[K]posting, key K, handle uint32) {
+	p, ok := postings[key]
+	if !ok {
+		p = make(posting)
+		postings[key] = p
+	}
+	p.add(handle)
+}
+
+func removeFrom[K comparable](postings map[K]posting, key K, handle uint32) {
+	if p, ok := postings[key]; ok {
+		delete(p, handle)
+		if len(p) == 0 {
+			delete(postings, key)
+		}
+	}
+}
+
+// Put adds the subscription, replacing any with the same id. Points and polygons should
+// already be resolved, or they only match alerts with a polygon.
+func (x *Index) Put(sub Subscription) {
+	e := &entry{
+		sub:      sub,
+		shapes:   ShapesOf(sub),
+		severity: severityRank(sub.MinSeverity),
+	}
+	if len(sub.Events) > 0 {
+		e.events = make(map[string]bool, len(sub.Events))
+		for _, event := range sub.Events {
+			e.events[strings.ToUpper(event)] = true
+		}
+	}
+	// Each shape only covers its own cells, points spread across the country stay a few postings
+	seen := make(map[cell]bool)
+	for _, shape := range e.shapes {
+		for _, c := range cellsOf(shape.Bound) {
+			if !seen[c] {
+				seen[c] = true
+				e.cells = append(e.cells, c)
+			}
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(sub.ID)
+	handle := x.next
+	x.next++
+	x.handles[sub.ID] = handle
+	x.entries[handle] = e
+
+	for _, ugc := range sub.UGCs {
+		addTo(x.byUGC, ugc, handle)
+	}
+	for _, code := range sub.SAME {
+		addTo(x.bySAME, code[1:], handle)
+	}
+	for _, ugc := range sub.Resolved {
+		addTo(x.byResolved, ugc, handle)
+	}
+	for _, c := range e.cells {
+		addTo(x.grid, c, handle)
+	}
+}
+
+// Remove drops the subscription, returning whether it was there
+func (x *Index) Remove(id string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.remove(id)
+}
+
+func (x *Index) remove(id string) bool {
+	handle, ok := x.handles[id]
+	if !ok {
+		return false
+	}
+	e := x.entries[handle]
+	for _, ugc := range e.sub.UGCs {
+		removeFrom(x.byUGC, ugc, handle)
+	}
+	for _, code := range e.sub.SAME {
+		removeFrom(x.bySAME, code[1:], handle)
+	}
+	for _, ugc := range e.sub.Resolved {
+		removeFrom(x.byResolved, ugc, handle)
+	}
+	for _, c := range e.cells {
+		removeFrom(x.grid, c, handle)
+	}
+	delete(x.entries, handle)
+	delete(x.handles, id)
+	return true
+}
+
+func (x *Index) Get(id string) (Subscription, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	handle, ok := x.handles[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return x.entries[handle].sub, true
+}
+
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.entries)
+}
+
+// All copies out every subscription, for resolving them again against a new dataset
+func (x *Index) All() []Subscription {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	subs := make([]Subscription, 0, len(x.entries))
+	for _, e := range x.entries {
+		subs = append(subs, e.sub)
+	}
+	return subs
+}
+
+/* ---- Matching ---- */
+
+// Match finds every subscription the alert reaches and passes the filters of, one delivery each
+func (x *Index) Match(alert SIREN.SirenAlertPushNotification) []SIREN.Delivery {
+	return x.match(alert, time.Now())
+}
+
+func (x *Index) match(alert SIREN.SirenAlertPushNotification, now time.Time) []SIREN.Delivery {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	matched := make(map[uint32]string)
+	rejected := make(map[uint32]bool)
+	// The first way a subscription matches is the one reported
+	consider := func(handle uint32, how func(e *entry) string) {
+		if _, ok := matched[handle]; ok || rejected[handle] {
+			return
+		}
+		e := x.entries[handle]
+		if !e.passes(alert) {
+			rejected[handle] = true
+			return
+		}
+		if reason := how(e); reason != "" {
+			matched[handle] = reason
+		}
+	}
+	always := func(reason string) func(*entry) string {
+		return func(*entry) string { return reason }
+	}
+
+	for _, ugc := range alert.Areas {
+		for handle := range x.byUGC[ugc] {
+			consider(handle, always(SIREN.MatchUGC))
+		}
+	}
+
+	for _, code := range alert.SAMECodes {
+		if len(code) != 6 {
+			continue
+		}
+		for handle := range x.bySAME[code[1:]] {
+			consider(handle, func(e *entry) string {
+				for _, subCode := range e.sub.SAME {
+					if sameOverlaps(subCode, code) {
+						return SIREN.MatchSAME
+					}
+				}
+				return ""
+			})
+		}
+	}
+
+	// A storm based warning only covers its polygon, not the whole of the counties it lists
+	if polygon, ok := alertPolygon(alert.Polygon); ok {
+		bound := polygon.Bound()
+		tested := make(map[uint32]bool)
+		for _, c := range cellsOf(bound) {
+			for handle := range x.grid[c] {
+				if tested[handle] {
+					continue
+				}
+				tested[handle] = true
+				consider(handle, func(e *entry) string {
+					for _, shape := range e.shapes {
+						if shape.Intersects(polygon, bound) {
+							return shape.Kind
+						}
+					}
+					return ""
+				})
+			}
+		}
+	} else {
+		for _, ugc := range alert.Areas {
+			for handle := range x.byResolved[ugc] {
+				consider(handle, always(SIREN.MatchArea))
+			}
+		}
+	}
+
+	motion, err := geo.ParseMotion(alert.Motion)
+	hasMotion := err == nil && alert.Expires.After(now)
+
+	deliveries := make([]SIREN.Delivery, 0, len(matched))
+	for handle, reason := range matched {
+		e := x.entries[handle]
+		delivery := SIREN.Delivery{
+			Subscription: e.sub.ID,
+			Subscriber:   e.sub.Subscriber,
+			Matched:      reason,
+			Alert:        alert,
+		}
+		if hasMotion {
+			delivery.Arrival = arrival(e.sub, motion, now, alert.Expires)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// The earliest the storm passes any of the subscription's points, nil if it misses them all
+func arrival(sub Subscription, motion geo.StormMotion, now time.Time, until time.Time) *SIREN.Arrival {
+	var first *geo.ArrivalWindow
+	for _, point := range sub.Points {
+		window, ok := motion.Arrival(orb.Point{point.Lon, point.Lat}, now, until)
+		if ok && (first == nil || window.Start.Before(first.Start)) {
+			first = &window
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return &SIREN.Arrival{
+		Start:   first.Start,
+		End:     first.End,
+		Minutes: max(0, int(math.Round(first.Start.Sub(now).Minutes()))),
+		Summary: first.Summary(now),
+	}
+}
+
+// Whether the alert gets through the subscription's event and severity filters
+func (e *entry) passes(alert SIREN.SirenAlertPushNotification) bool {
+	if e.severity > 0 && severityRank(alert.Severity) < e.severity {
+		return false
+	}
+	if e.events != nil && !e.events[strings.ToUpper(alert.EventCode)] && !e.events[strings.ToUpper(alert.Event)] {
+		return false
+	}
+	return true
+}
+
+// SAME codes start with the part of the county, 0 for all of it
+func sameOverlaps(a string, b string) bool {
+	return a[1:] == b[1:] && (a[0] == '0' || b[0] == '0' || a[0] == b[0])
+}
+
+func alertPolygon(points [][2]float64) (orb.Polygon, bool) {
+	if len(points) < 3 {
+		return nil, false
+	}
+	ring := make(orb.Ring, 0, len(points)+1)
+	for _, pt := range points {
+		ring = append(ring, orb.Point(pt))
+	}
+	if !ring.Closed() {
+		ring = append(ring, ring[0])
+	}
+	return orb.Polygon{ring}, true
+}
@@ -0,0 +1,142 @@
+package Match
+
+import (
+	"geoService/NWS"
+	"matchService/SIREN"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// A subscription with points far apart, one near Miami, one near Seattle
+var spread = Subscription{
+	ID:         "spread",
+	Subscriber: "device",
+	Points: []Circle{
+		{Lat: 25.76, Lon: -80.19},
+		{Lat: 47.61, Lon: -122.33, Radius: 5000},
+	},
+}
+
+func square(minLon, minLat, size float64) [][2]float64 {
+	return [][2]float64{
+		{minLon, minLat}, {minLon + size, minLat}, {minLon + size, minLat + size}, {minLon, minLat + size}, {minLon, minLat},
+	}
+}
+
+func TestPutIndexesEachShapesCells(t *testing.T) {
+	x := NewIndex()
+	x.Put(spread)
+
+	// One cell for the point, up to four for the circle across a cell corner
+	if n := len(x.grid); n > 5 {
+		t.Fatalf("far apart points take %d grid cells, want at most 5", n)
+	}
+
+	x.Remove(spread.ID)
+	if n := len(x.grid); n != 0 {
+		t.Fatalf("removed subscription left %d grid cells", n)
+	}
+}
+
+func TestMatchFarApartPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon [][2]float64
+		matched string
+	}{
+		{"around the Miami point", square(-80.3, 25.7, 0.2), SIREN.MatchPoint},
+		{"overlapping the Seattle circle", square(-122.40, 47.55, 0.05), SIREN.MatchPoint},
+		{"between the points", square(-100, 35, 1), ""},
+		{"near but outside the Seattle circle", square(-122.0, 47.0, 0.1), ""},
+	}
+
+	x := NewIndex()
+	x.Put(spread)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries := x.Match(SIREN.SirenAlertPushNotification{Identifier: "TOW-TEST", Polygon: tt.polygon})
+			switch {
+			case tt.matched == "" && len(deliveries) != 0:
+				t.Fatalf("got %d deliveries, want none", len(deliveries))
+			case tt.matched != "" && (len(deliveries) != 1 || deliveries[0].Matched != tt.matched):
+				t.Fatalf("got %+v, want one %s delivery", deliveries, tt.matched)
+			}
+		})
+	}
+}
+
+func TestMatchArrival(t *testing.T) {
+	now := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	storm := orb.Point{-93.6, 41.6}
+	// 20km east of the storm, which is moving east at 30 knots, and a point it misses
+	ahead := geo.PointAtBearingAndDistance(storm, 90, 20000)
+	aside := geo.PointAtBearingAndDistance(ahead, 0, 30000)
+
+	subs := []Subscription{
+		{ID: "ahead", Subscriber: "device", Points: []Circle{{Lat: aside[1], Lon: aside[0]}, {Lat: ahead[1], Lon: ahead[0]}}},
+		{ID: "aside", Subscriber: "device", Points: []Circle{{Lat: aside[1], Lon: aside[0]}}},
+		{ID: "county", Subscriber: "device", UGCs: []string{"IAC153"}},
+	}
+	x := NewIndex()
+	for _, sub := range subs {
+		x.Put(sub)
+	}
+
+	motion := &NWS.EventMotionDescription{Timestamp: now, Direction: "270DEG", Speed: "30KT", Location: []NWS.Coordinate{{Lat: storm[1], Lon: storm[0]}}}
+	alert := SIREN.SirenAlertPushNotification{
+		Identifier: "TOW-TEST",
+		Areas:      []string{"IAC153"},
+		Polygon:    square(-94, 41, 1.5),
+		Motion:     motion,
+		Expires:    now.Add(45 * time.Minute),
+	}
+
+	tests := []struct {
+		name    string
+		alert   func(SIREN.SirenAlertPushNotification) SIREN.SirenAlertPushNotification
+		summary map[string]string // By subscription, empty for no arrival
+	}{
+		{
+			name:    "storm motion",
+			alert:   func(a SIREN.SirenAlertPushNotification) SIREN.SirenAlertPushNotification { return a },
+			summary: map[string]string{"ahead": "arriving near you in ~13 minutes"},
+		},
+		{
+			name: "no storm motion",
+			alert: func(a SIREN.SirenAlertPushNotification) SIREN.SirenAlertPushNotification {
+				a.Motion = nil
+				return a
+			},
+		},
+		{
+			name: "expired",
+			alert: func(a SIREN.SirenAlertPushNotification) SIREN.SirenAlertPushNotification {
+				a.Expires = now.Add(-time.Minute)
+				return a
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries := x.match(tt.alert(alert), now)
+			if len(deliveries) != len(subs) {
+				t.Fatalf("got %d deliveries, want %d", len(deliveries), len(subs))
+			}
+			for _, delivery := range deliveries {
+				want := tt.summary[delivery.Subscription]
+				switch {
+				case want == "" && delivery.Arrival != nil:
+					t.Errorf("%s got an arrival %+v", delivery.Subscription, *delivery.Arrival)
+				case want != "" && delivery.Arrival == nil:
+					t.Errorf("%s got no arrival", delivery.Subscription)
+				case want != "" && (delivery.Arrival.Summary != want || delivery.Arrival.Minutes != 13):
+					t.Errorf("%s arrival %+v, want %q", delivery.Subscription, *delivery.Arrival, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package Match
+
+import (
+	geo "geoService/SIREN"
+	"slices"
+
+	"github.com/paulmach/orb"
+)
+
+// Resolver finds the UGC areas a point, circle or polygon falls in, from the geo service's
+// UGC stores. Subscriptions are resolved once when saved, so matching an alert against its
+// areas is a map lookup.
+type Resolver struct {
+	Version string // Identifies the datasets, subscriptions resolved against another need resolving again
+
+	areas []area
+	grid  map[cell][]int32
+}
+
+type area struct {
+	ugc   string
+	shape orb.MultiPolygon
+	bound orb.Bound
+}
+
+func NewResolver(version string, features []geo.UGC) *Resolver {
+	r := &Resolver{Version: version, grid: make(map[cell][]int32)}
+	for _, feature := range features {
+		shape := toMultiPolygon(feature.Geometry)
+		if len(shape) == 0 {
+			continue
+		}
+		i := int32(len(r.areas))
+		r.areas = append(r.areas, area{ugc: feature.UGC, shape: shape, bound: shape.Bound()})
+		for _, c := range cellsOf(r.areas[i].bound) {
+			r.grid[c] = append(r.grid[c], i)
+		}
+	}
+	return r
+}
+
+// Len is the number of areas the resolver knows
+func (r *Resolver) Len() int {
+	return len(r.areas)
+}
+
+// Resolve lists the UGCs of the areas the subscription's points and polygons touch
+func (r *Resolver) Resolve(sub Subscription) []string {
+	var ugcs []string
+	for _, shape := range ShapesOf(sub) {
+		tested := make(map[int32]bool)
+		for _, c := range cellsOf(shape.Bound) {
+			for _, i := range r.grid[c] {
+				if tested[i] {
+					continue
+				}
+				tested[i] = true
+				if a := r.areas[i]; shape.IntersectsAny(a.shape, a.bound) && !slices.Contains(ugcs, a.ugc) {
+					ugcs = append(ugcs, a.ugc)
+				}
+			}
+		}
+	}
+	slices.Sort(ugcs)
+	return ugcs
+}
+
+func toMultiPolygon(geometry geo.Geometry) orb.MultiPolygon {
+	mp := make(orb.MultiPolygon, 0, len(geometry.Polygons))
+	for _, polygon := range geometry.Polygons {
+		if len(polygon.Exterior) < 4 {
+			continue
+		}
+		p := orb.Polygon{toRing(polygon.Exterior)}
+		for _, hole := range polygon.Holes {
+			p = append(p, toRing(hole))
+		}
+		mp = append(mp, p)
+	}
+	return mp
+}
+
+func toRing(ring geo.Ring) orb.Ring {
+	converted := make(orb.Ring, len(ring))
+	for i, pt := range ring {
+		converted[i] = orb.Point(pt)
+	}
+	return converted
+}
@@ -0,0 +1,167 @@
+package Match
+
+import (
+	"matchService/SIREN"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// Circles are matched as polygons with this many sides, close enough at warning scale
+const circleSides = 32
+
+// Shape is a subscription point, circle or polygon ready to test against alert geometry
+type Shape struct {
+	Kind  string // SIREN.MatchPoint or SIREN.MatchPolygon
+	Point orb.Point
+	Ring  orb.Ring // Empty for a bare point
+	Bound orb.Bound
+}
+
+func pointShape(kind string, point orb.Point) Shape {
+	return Shape{Kind: kind, Point: point, Bound: point.Bound()}
+}
+
+func ringShape(kind string, ring orb.Ring) Shape {
+	return Shape{Kind: kind, Ring: ring, Bound: ring.Bound()}
+}
+
+// circleRing approximates a circle of radius meters around the point
+func circleRing(center orb.Point, radius float64) orb.Ring {
+	ring := make(orb.Ring, 0, circleSides+1)
+	for i := range circleSides {
+		ring = append(ring, geo.PointAtBearingAndDistance(center, float64(i)*360/circleSides, radius))
+	}
+	return append(ring, ring[0])
+}
+
+func ringBound(ring [][2]float64) orb.Bound {
+	bound := orb.Point(ring[0]).Bound()
+	for _, pt := range ring[1:] {
+		bound = bound.Extend(orb.Point(pt))
+	}
+	return bound
+}
+
+// Intersects is true when the shape touches the polygon, holes excluded
+func (s Shape) Intersects(polygon orb.Polygon, bound orb.Bound) bool {
+	if len(polygon) == 0 || !s.Bound.Intersects(bound) {
+		return false
+	}
+	if len(s.Ring) == 0 {
+		return planar.PolygonContains(polygon, s.Point)
+	}
+
+	// With no crossing edges one is inside the other or they are apart, and one point of
+	// each settles which
+	if planar.PolygonContains(polygon, s.Ring[0]) || planar.RingContains(s.Ring, polygon[0][0]) {
+		return true
+	}
+	for _, ring := range polygon {
+		if ringsCross(s.Ring, s.Bound, ring) {
+			return true
+		}
+	}
+	return false
+}
+
+// IntersectsAny is Intersects for the parts of a multipolygon
+func (s Shape) IntersectsAny(mp orb.MultiPolygon, bound orb.Bound) bool {
+	if !s.Bound.Intersects(bound) {
+		return false
+	}
+	for _, polygon := range mp {
+		if s.Intersects(polygon, polygon.Bound()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ringsCross looks for an edge of a crossing an edge of b. County rings have thousands of
+// points, so their edges outside a's bound are skipped before the real test.
+func ringsCross(a orb.Ring, aBound orb.Bound, b orb.Ring) bool {
+	for j := 1; j < len(b); j++ {
+		q1, q2 := b[j-1], b[j]
+		if math.Max(q1[0], q2[0]) < aBound.Min[0] || math.Min(q1[0], q2[0]) > aBound.Max[0] ||
+			math.Max(q1[1], q2[1]) < aBound.Min[1] || math.Min(q1[1], q2[1]) > aBound.Max[1] {
+			continue
+		}
+		for i := 1; i < len(a); i++ {
+			if segmentsCross(a[i-1], a[i], q1, q2) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func segmentsCross(p1, p2, q1, q2 orb.Point) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	// Touching counts, a shared border is an overlap for alerting
+	return (d1 == 0 && onSegment(q1, q2, p1)) || (d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) || (d4 == 0 && onSegment(p1, p2, q2))
+}
+
+func orientation(a, b, c orb.Point) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func onSegment(a, b, p orb.Point) bool {
+	return p[0] >= math.Min(a[0], b[0]) && p[0] <= math.Max(a[0], b[0]) &&
+		p[1] >= math.Min(a[1], b[1]) && p[1] <= math.Max(a[1], b[1])
+}
+
+/* ---- Grid ---- */
+
+// Shapes and areas are bucketed into cells this many degrees across, so an alert only
+// tests the shapes near it
+const cellSize = 0.5
+
+type cell uint64
+
+func cellOf(x, y float64) (int32, int32) {
+	return int32(math.Floor(x / cellSize)), int32(math.Floor(y / cellSize))
+}
+
+// cellsOf lists the cells a bound covers
+func cellsOf(bound orb.Bound) []cell {
+	minX, minY := cellOf(bound.Min[0], bound.Min[1])
+	maxX, maxY := cellOf(bound.Max[0], bound.Max[1])
+	cells := make([]cell, 0, int(maxX-minX+1)*int(maxY-minY+1))
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			cells = append(cells, cell(uint64(uint32(x))<<32|uint64(uint32(y))))
+		}
+	}
+	return cells
+}
+
+// ShapesOf builds the shapes for a subscription's points and polygons
+func ShapesOf(sub Subscription) []Shape {
+	shapes := make([]Shape, 0, len(sub.Points)+len(sub.Polygons))
+	for _, point := range sub.Points {
+		center := orb.Point{point.Lon, point.Lat}
+		if point.Radius > 0 {
+			shapes = append(shapes, ringShape(SIREN.MatchPoint, circleRing(center, point.Radius)))
+		} else {
+			shapes = append(shapes, pointShape(SIREN.MatchPoint, center))
+		}
+	}
+	for _, polygon := range sub.Polygons {
+		ring := make(orb.Ring, len(polygon))
+		for i, pt := range polygon {
+			ring[i] = orb.Point(pt)
+		}
+		shapes = append(shapes, ringShape(SIREN.MatchPolygon, ring))
+	}
+	return shapes
+}
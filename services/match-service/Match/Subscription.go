@@ -0,0 +1,167 @@
+package Match
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Limits that keep a single subscription cheap to index and match
+const (
+	MaxLocations     = 50     // Of each kind, UGCs, SAME codes, points and polygons
+	MaxRadius        = 100000 // Meters
+	MaxPolygonPoints = 500
+	MaxPolygonSpan   = 5.0 // Degrees of latitude or longitude
+	MaxEvents        = 100
+)
+
+// Circle is a point with a radius, a radius of 0 is just the point
+type Circle struct {
+	Lat    float64 `bson:"lat" json:"lat"`
+	Lon    float64 `bson:"lon" json:"lon"`
+	Radius float64 `bson:"radius,omitempty" json:"radius,omitempty"` // Meters
+}
+
+// Subscription is where and what a subscriber wants to be alerted for. An alert matches
+// if it touches any of the locations and passes every filter.
+type Subscription struct {
+	ID         string `bson:"id" json:"id"`
+	Subscriber string `bson:"subscriber" json:"subscriber"` // Who the deliveries are for, e.g. a device token
+
+	UGCs     []string       `bson:"ugcs,omitempty" json:"ugcs,omitempty"`         // e.g. TXC303 or TXZ035
+	SAME     []string       `bson:"same,omitempty" json:"same,omitempty"`         // PSSCCC, e.g. 048303
+	Points   []Circle       `bson:"points,omitempty" json:"points,omitempty"`     // e.g. a home or a campus
+	Polygons [][][2]float64 `bson:"polygons,omitempty" json:"polygons,omitempty"` // lon/lat rings drawn on a map
+
+	// Filters, empty lets everything through
+	Events      []string `bson:"events,omitempty" json:"events,omitempty"`           // NWS event codes like TOR, or event names
+	MinSeverity string   `bson:"minSeverity,omitempty" json:"minSeverity,omitempty"` // CAP severity, e.g. Severe
+
+	// The UGCs the points and polygons fall in, and the datasets they were resolved against
+	Resolved     []string `bson:"resolved,omitempty" json:"resolved,omitempty"`
+	ResolvedWith string   `bson:"resolvedWith,omitempty" json:"-"`
+
+	Created time.Time `bson:"created" json:"created"`
+	Updated time.Time `bson:"updated" json:"updated"`
+}
+
+// CAP severities, least severe first
+var severityRanks = map[string]int{
+	"Unknown":  0,
+	"Minor":    1,
+	"Moderate": 2,
+	"Severe":   3,
+	"Extreme":  4,
+}
+
+func severityRank(severity string) int {
+	for name, rank := range severityRanks {
+		if strings.EqualFold(name, severity) {
+			return rank
+		}
+	}
+	return 0
+}
+
+var ugcRE = regexp.MustCompile(`^[A-Z]{2}[CZ]\d{3}$`)
+var sameRE = regexp.MustCompile(`^\d{6}$`)
+
+var ErrNoLocations = errors.New("subscription has no UGCs, SAME codes, points or polygons")
+
+// Normalize upper cases the codes, drops duplicates and closes the polygon rings
+func (s *Subscription) Normalize() {
+	s.ID = strings.TrimSpace(s.ID)
+	s.Subscriber = strings.TrimSpace(s.Subscriber)
+	s.UGCs = normalizeCodes(s.UGCs)
+	s.SAME = normalizeCodes(s.SAME)
+	s.Events = normalizeCodes(s.Events)
+	for name := range severityRanks {
+		if strings.EqualFold(name, s.MinSeverity) {
+			s.MinSeverity = name
+		}
+	}
+	for i, ring := range s.Polygons {
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			s.Polygons[i] = append(ring, ring[0])
+		}
+	}
+}
+
+func normalizeCodes(codes []string) []string {
+	var normalized []string
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !slices.Contains(normalized, code) {
+			normalized = append(normalized, code)
+		}
+	}
+	return normalized
+}
+
+// Validate checks a normalized subscription, naming the first problem found
+func (s Subscription) Validate() error {
+	if s.ID == "" {
+		return errors.New("subscription has no id")
+	}
+	if s.Subscriber == "" {
+		return errors.New("subscription has no subscriber")
+	}
+	if len(s.UGCs)+len(s.SAME)+len(s.Points)+len(s.Polygons) == 0 {
+		return ErrNoLocations
+	}
+	if len(s.UGCs) > MaxLocations || len(s.SAME) > MaxLocations || len(s.Points) > MaxLocations || len(s.Polygons) > MaxLocations {
+		return fmt.Errorf("at most %d of each kind of location are allowed", MaxLocations)
+	}
+	if len(s.Events) > MaxEvents {
+		return fmt.Errorf("at most %d events are allowed", MaxEvents)
+	}
+
+	for _, ugc := range s.UGCs {
+		if !ugcRE.MatchString(ugc) {
+			return fmt.Errorf("invalid UGC %q", ugc)
+		}
+	}
+	for _, code := range s.SAME {
+		if !sameRE.MatchString(code) {
+			return fmt.Errorf("invalid SAME code %q", code)
+		}
+	}
+	for _, point := range s.Points {
+		if point.Lat < -90 || point.Lat > 90 || point.Lon < -180 || point.Lon > 180 {
+			return fmt.Errorf("invalid point %g, %g", point.Lat, point.Lon)
+		}
+		if point.Radius < 0 || point.Radius > MaxRadius {
+			return fmt.Errorf("radius %g is outside 0 to %d meters", point.Radius, MaxRadius)
+		}
+	}
+	for i, ring := range s.Polygons {
+		// Closed, so a triangle is four points
+		if len(ring) < 4 || len(ring) > MaxPolygonPoints+1 {
+			return fmt.Errorf("polygon %d must have 3 to %d points", i, MaxPolygonPoints)
+		}
+		bound := ringBound(ring)
+		if bound.Max[0]-bound.Min[0] > MaxPolygonSpan || bound.Max[1]-bound.Min[1] > MaxPolygonSpan {
+			return fmt.Errorf("polygon %d spans more than %g degrees", i, MaxPolygonSpan)
+		}
+		for _, pt := range ring {
+			if pt[1] < -90 || pt[1] > 90 || pt[0] < -180 || pt[0] > 180 {
+				return fmt.Errorf("polygon %d has an invalid point %g, %g", i, pt[1], pt[0])
+			}
+		}
+	}
+
+	if s.MinSeverity != "" {
+		if _, ok := severityRanks[s.MinSeverity]; !ok {
+			return fmt.Errorf("unknown severity %q", s.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// Whether the subscription needs its points and polygons resolved to UGCs
+func (s Subscription) HasShapes() bool {
+	return len(s.Points) > 0 || len(s.Polygons) > 0
+}
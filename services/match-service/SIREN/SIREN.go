@@ -0,0 +1,65 @@
+package SIREN
+
+import (
+	"geoService/NWS"
+	"time"
+)
+
+// SirenAlertPushNotification is what the tracking service sends for every processed alert.
+// Tracking's tags on the first fields are malformed, so those go out under their Go names.
+type SirenAlertPushNotification struct {
+	Identifier   string       `msgpack:"Identifier"`
+	Event        string       `msgpack:"Event"`
+	Areas        []string     `msgpack:"Areas"`
+	Sender       string       `msgpack:"Sender"`
+	EventCode    string       `msgpack:"EventCode"`
+	Action       string       `msgpack:"Action"`
+	Tier         string       `msgpack:"tier,omitempty"`
+	Label        string       `msgpack:"label,omitempty"`
+	Escalated    bool         `msgpack:"escalated,omitempty"`
+	PreviousTier string       `msgpack:"previousTier,omitempty"`
+	WEA          []WEAMessage `msgpack:"wea,omitempty"`
+	SAME         []string     `msgpack:"same,omitempty"` // One header for every 31 locations
+	Severity     string       `msgpack:"severity,omitempty"`
+	SAMECodes    []string     `msgpack:"sameCodes,omitempty"`
+	Polygon      [][2]float64 `msgpack:"polygon,omitempty"` // lon/lat ring of a storm based warning
+	// The storm's TIME...MOT...LOC and when the alert ends, for the arrival at point subscriptions
+	Motion  *NWS.EventMotionDescription `msgpack:"motion,omitempty"`
+	Expires time.Time                   `msgpack:"expires,omitempty"`
+}
+
+// WEAMessage is the text phones receive for an alert in one language
+type WEAMessage struct {
+	Language  string `msgpack:"language"`
+	Class     string `msgpack:"class"`
+	Short     string `msgpack:"short"`
+	Long      string `msgpack:"long"`
+	Generated bool   `msgpack:"generated,omitempty"`
+}
+
+// How a subscription matched an alert
+const (
+	MatchUGC     = "ugc"     // One of the subscription's UGCs is in the alert
+	MatchSAME    = "same"    // One of the subscription's SAME codes is in the alert
+	MatchPoint   = "point"   // A point or circle is inside the alert's polygon
+	MatchPolygon = "polygon" // A drawn polygon overlaps the alert's polygon
+	MatchArea    = "area"    // A point, circle or polygon is in one of the alert's areas
+)
+
+// Delivery is one alert for one subscriber, published to the deliveries queue
+type Delivery struct {
+	Subscription string                     `msgpack:"subscription"`
+	Subscriber   string                     `msgpack:"subscriber"`
+	Matched      string                     `msgpack:"matched"`
+	Alert        SirenAlertPushNotification `msgpack:"alert"`
+	// When the storm reaches the subscription's points, for warnings with a storm motion
+	Arrival *Arrival `msgpack:"arrival,omitempty"`
+}
+
+// Arrival is when a moving storm is projected to pass near one of a subscription's points
+type Arrival struct {
+	Start   time.Time `msgpack:"start"`
+	End     time.Time `msgpack:"end"`
+	Minutes int       `msgpack:"minutes"` // From when the alert was matched, 0 once it has arrived
+	Summary string    `msgpack:"summary"` // e.g. arriving near you in ~12 minutes
+}
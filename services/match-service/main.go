@@ -0,0 +1,608 @@
+/**========================================================================
+ *  						  Match Service
+ *  							SIREN
+ *
+ *  Keeps every subscription in memory and matches each alert from the
+ *  tracking service against them, publishing one delivery per subscriber
+ *  to the deliveries queue. Points and polygons are resolved to the UGC
+ *  areas they fall in with the geo service's UGC stores when they are saved.
+ *========================================================================**/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	geo "geoService/SIREN"
+	"matchService/Match"
+	"matchService/SIREN"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ampq "github.com/rabbitmq/amqp091-go"
+	"github.com/vmihailenco/msgpack"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**============================================
+ *               Prometheus Metrics
+ *=============================================**/
+
+var alertsMatched = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "match_alerts_total",
+	Help: "Alerts matched against the subscriptions",
+})
+
+var deliveriesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "match_deliveries_total",
+	Help: "Deliveries published, by how the subscription matched",
+}, []string{"matched"})
+
+var matchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "match_duration_seconds",
+	Help:    "Time taken to match an alert against the subscriptions",
+	Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+})
+
+var resolveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "match_resolve_duration_seconds",
+	Help:    "Time taken to resolve a subscription's points and polygons to UGCs",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+})
+
+var subscriptionsIndexed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "match_subscriptions",
+	Help: "Subscriptions in the index",
+}, func() float64 {
+	return float64(index.Len())
+})
+
+func init() {
+	prometheus.MustRegister(alertsMatched)
+	prometheus.MustRegister(deliveriesPublished)
+	prometheus.MustRegister(matchDuration)
+	prometheus.MustRegister(resolveDuration)
+	prometheus.MustRegister(subscriptionsIndexed)
+}
+
+/**============================================
+ *               RabbitMQ Connection
+ *=============================================**/
+
+var conn *ampq.Connection
+var ch *ampq.Channel
+var matchQueue ampq.Queue
+var deliveriesQueue ampq.Queue
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func connectToMQ() {
+	var err error
+	conn, err = ampq.Dial(os.Getenv("RABBITMQ_URL"))
+	if err != nil {
+		log.Fatal("Failed to connect to the message queue", "err", err)
+	}
+
+	ch, err = conn.Channel()
+	if err != nil {
+		log.Fatal("Failed to open a channel", "err", err)
+	}
+
+	// The tracking service publishes every push here too when its MATCH_QUEUE is set
+	matchQueue, err = ch.QueueDeclare(envOr("MATCH_QUEUE", "match"), true, false, false, false, nil)
+	if err != nil {
+		log.Fatal("Failed to declare the match queue", "err", err)
+	}
+
+	deliveriesQueue, err = ch.QueueDeclare(envOr("DELIVERIES_QUEUE", "deliveries"), true, false, false, false, nil)
+	if err != nil {
+		log.Fatal("Failed to declare the deliveries queue", "err", err)
+	}
+}
+
+/**============================================
+ *               MongoDB Connection
+ *=============================================**/
+
+var client *mongo.Client
+var subscriptionsCollection *mongo.Collection
+
+func ConnectToMongo() {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		log.Warn("MONGO_URI not set. Using default value, this may not work.")
+		uri = "mongodb://localhost:27017"
+	}
+
+	var err error
+	client, err = mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatal("Failed to connect to MongoDB", "err", err)
+	}
+	subscriptionsCollection = client.Database("siren").Collection("subscriptions")
+
+	if err := createIndexes(context.Background(), subscriptionsCollection); err != nil {
+		log.Fatal("Failed to create the subscription indexes", "err", err)
+	}
+}
+
+// The keys have to be the v2 driver's bson.D, it encodes v1 documents as arrays
+var subscriptionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "subscriber", Value: 1}}},
+}
+
+func createIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, subscriptionIndexes)
+	return err
+}
+
+/**============================================
+ *                 UGC Stores
+ *=============================================**/
+
+var resolver atomic.Pointer[Match.Resolver]
+
+// Fire zones share their codes with public zones, so resolving a point against both would
+// give a zone code that means something else to a public zone alert. They are left out.
+var resolveZones = []geo.ZoneType{geo.ZoneCounty, geo.ZonePublic, geo.ZoneMarine}
+
+// UGC_STORE_DIR is where the geo service's ugc-loader writes the stores, shared with the geo service
+func storePath(zoneType geo.ZoneType) string {
+	return geo.StorePath(os.Getenv("UGC_STORE_DIR"), zoneType)
+}
+
+// Reads the UGC stores into a resolver, the county store is required
+func loadResolver() (*Match.Resolver, error) {
+	var features []geo.UGC
+	var version string
+	for _, zoneType := range resolveZones {
+		path := storePath(zoneType)
+		if _, err := os.Stat(path); err != nil {
+			if zoneType == geo.ZoneCounty {
+				return nil, err
+			}
+			log.Warn("UGC store not found, skipping", "type", zoneType, "path", path)
+			continue
+		}
+
+		meta, ugcs, err := geo.ReadStore(path)
+		if ugcs == nil && err != nil {
+			return nil, fmt.Errorf("%s store: %w", zoneType, err)
+		}
+		if err != nil {
+			log.Warn("Some UGCs could not be read", "type", zoneType, "err", err)
+		}
+		features = append(features, ugcs...)
+		version += fmt.Sprintf("%s:%d@%d;", zoneType, meta.Version, meta.BuiltAt.Unix())
+	}
+	return Match.NewResolver(version, features), nil
+}
+
+// Whether the file at path is not the one described by info. The loader renames a new
+// file over the old one, so a changed inode or modification time means a new dataset.
+func storeChanged(path string, info os.FileInfo) (os.FileInfo, bool) {
+	current, err := os.Stat(path)
+	if err != nil {
+		return info, false
+	}
+	if info == nil {
+		return current, true
+	}
+	return current, !os.SameFile(current, info) || !current.ModTime().Equal(info.ModTime())
+}
+
+// Polls the UGC stores and resolves every subscription again when the loader replaces one
+func watchUGCStores(interval time.Duration) {
+	infos := make(map[geo.ZoneType]os.FileInfo)
+	for _, zoneType := range resolveZones {
+		infos[zoneType], _ = storeChanged(storePath(zoneType), nil)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed := false
+		for _, zoneType := range resolveZones {
+			var changedStore bool
+			infos[zoneType], changedStore = storeChanged(storePath(zoneType), infos[zoneType])
+			changed = changed || changedStore
+		}
+		if !changed {
+			continue
+		}
+
+		r, err := loadResolver()
+		if err != nil {
+			log.Error("Failed to reload the UGC stores", "err", err)
+			continue
+		}
+		resolver.Store(r)
+		log.Info("Reloaded the UGC stores", "areas", r.Len(), "version", r.Version)
+		resolveAll(context.Background())
+	}
+}
+
+/**============================================
+ *                Subscriptions
+ *=============================================**/
+
+var index = Match.NewIndex()
+
+// Serializes changes to subscriptions, so resolving everything again can't undo a save
+var writeMu sync.Mutex
+
+// Resolves the points and polygons of a subscription, returning whether anything changed
+func resolve(sub *Match.Subscription) bool {
+	r := resolver.Load()
+	if !sub.HasShapes() {
+		changed := len(sub.Resolved) > 0
+		sub.Resolved, sub.ResolvedWith = nil, ""
+		return changed
+	}
+	if sub.ResolvedWith == r.Version {
+		return false
+	}
+	start := time.Now()
+	sub.Resolved = r.Resolve(*sub)
+	sub.ResolvedWith = r.Version
+	resolveDuration.Observe(time.Since(start).Seconds())
+	return true
+}
+
+// Loads every subscription into the index, resolving any saved against an older dataset
+func loadSubscriptions(ctx context.Context) error {
+	cursor, err := subscriptionsCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var updates []mongo.WriteModel
+	for cursor.Next(ctx) {
+		var sub Match.Subscription
+		if err := cursor.Decode(&sub); err != nil {
+			log.Warn("Skipping unreadable subscription", "err", err)
+			continue
+		}
+		if resolve(&sub) {
+			updates = append(updates, resolvedUpdate(sub))
+		}
+		index.Put(sub)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return writeResolved(ctx, updates)
+}
+
+// Resolves every subscription in the index against the current resolver
+func resolveAll(ctx context.Context) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	start := time.Now()
+	var updates []mongo.WriteModel
+	for _, sub := range index.All() {
+		if resolve(&sub) {
+			updates = append(updates, resolvedUpdate(sub))
+			index.Put(sub)
+		}
+	}
+	if err := writeResolved(ctx, updates); err != nil {
+		log.Error("Failed to save the resolved subscriptions", "err", err)
+	}
+	log.Info("Resolved subscriptions against the new UGC stores", "updated", len(updates), "took", time.Since(start))
+}
+
+func resolvedUpdate(sub Match.Subscription) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"id": sub.ID}).
+		SetUpdate(bson.M{"$set": bson.M{"resolved": sub.Resolved, "resolvedWith": sub.ResolvedWith}})
+}
+
+// Saves resolved UGCs in batches, so a restart doesn't resolve them all again
+func writeResolved(ctx context.Context, updates []mongo.WriteModel) error {
+	const batchSize = 1000
+	for start := 0; start < len(updates); start += batchSize {
+		batch := updates[start:min(start+batchSize, len(updates))]
+		if _, err := subscriptionsCollection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**============================================
+ *               Subscription API
+ *=============================================**/
+
+// The API is for the SIREN services that manage subscriptions for their users, not for the
+// users themselves. Callers prove they are one with MATCH_API_TOKEN and name the subscriber
+// they act for, and only see and change that subscriber's subscriptions.
+var apiToken string
+
+const subscriberHeader = "X-Siren-Subscriber"
+
+// requireCaller checks the token and hands the handler the subscriber the caller acts for
+func requireCaller(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if apiToken == "" {
+			http.Error(res, "Subscription API is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+			res.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		subscriber := strings.TrimSpace(req.Header.Get(subscriberHeader))
+		if subscriber == "" {
+			http.Error(res, "A "+subscriberHeader+" header is required", http.StatusBadRequest)
+			return
+		}
+		next(res, req, subscriber)
+	}
+}
+
+func writeJSON(res http.ResponseWriter, status int, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		log.Error("Failed to encode response", "err", err)
+	}
+}
+
+// Serves /subscriptions/{id}, GET reads a subscription, PUT creates or replaces it and DELETE removes it.
+// Another subscriber's subscription is reported as not found.
+func HandleSubscription(res http.ResponseWriter, req *http.Request, subscriber string) {
+	id := req.PathValue("id")
+	ctx, span := tracer.Start(req.Context(), "subscription."+req.Method, trace.WithAttributes(attribute.String("subscription.id", id)))
+	defer span.End()
+
+	switch req.Method {
+	case http.MethodGet:
+		sub, ok := index.Get(id)
+		if !ok || sub.Subscriber != subscriber {
+			http.Error(res, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(res, http.StatusOK, sub)
+
+	case http.MethodPut:
+		var sub Match.Subscription
+		if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 1<<20)).Decode(&sub); err != nil {
+			http.Error(res, "Invalid subscription: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sub.Subscriber != "" && sub.Subscriber != subscriber {
+			http.Error(res, "Subscriber does not match the "+subscriberHeader+" header", http.StatusForbidden)
+			return
+		}
+		sub.ID = id
+		sub.Subscriber = subscriber
+		sub.Normalize()
+		if err := sub.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		now := time.Now().UTC()
+		sub.Created, sub.Updated = now, now
+		status := http.StatusCreated
+		if existing, ok := index.Get(id); ok {
+			if existing.Subscriber != subscriber {
+				http.Error(res, "Subscription id is taken", http.StatusConflict)
+				return
+			}
+			sub.Created = existing.Created
+			status = http.StatusOK
+		}
+		sub.Resolved, sub.ResolvedWith = nil, ""
+		resolve(&sub)
+
+		mongoCtx, mongoSpan := startMongoSpan(ctx, "replaceOne", subscriptionsCollection)
+		_, err := subscriptionsCollection.ReplaceOne(mongoCtx, bson.M{"id": id}, sub, options.Replace().SetUpsert(true))
+		endSpan(mongoSpan, err)
+		if err != nil {
+			log.Error("Failed to save subscription", "id", id, "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(res, "Failed to save subscription", http.StatusInternalServerError)
+			return
+		}
+		index.Put(sub)
+		writeJSON(res, status, sub)
+
+	case http.MethodDelete:
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		if existing, ok := index.Get(id); !ok || existing.Subscriber != subscriber {
+			http.Error(res, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		mongoCtx, mongoSpan := startMongoSpan(ctx, "deleteOne", subscriptionsCollection)
+		_, err := subscriptionsCollection.DeleteOne(mongoCtx, bson.M{"id": id, "subscriber": subscriber})
+		endSpan(mongoSpan, err)
+		if err != nil {
+			log.Error("Failed to delete subscription", "id", id, "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(res, "Failed to delete subscription", http.StatusInternalServerError)
+			return
+		}
+		index.Remove(id)
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		res.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Serves /subscriptions, every subscription of the caller's subscriber
+func HandleSubscriberRequest(res http.ResponseWriter, req *http.Request, subscriber string) {
+	ctx, span := startMongoSpan(req.Context(), "find", subscriptionsCollection)
+	cursor, err := subscriptionsCollection.Find(ctx, bson.M{"subscriber": subscriber})
+	endSpan(span, err)
+	if err != nil {
+		log.Error("Failed to find subscriptions", "subscriber", subscriber, "err", err)
+		http.Error(res, "Failed to find subscriptions", http.StatusInternalServerError)
+		return
+	}
+	subs := []Match.Subscription{}
+	if err := cursor.All(req.Context(), &subs); err != nil {
+		log.Error("Failed to read subscriptions", "subscriber", subscriber, "err", err)
+		http.Error(res, "Failed to find subscriptions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(res, http.StatusOK, subs)
+}
+
+func HandleHealthz(res http.ResponseWriter, req *http.Request) {
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte("ok"))
+}
+
+/**============================================
+ *               Alert Matching
+ *=============================================**/
+
+// Matches an alert from the tracking service and publishes a delivery for every subscriber it reaches
+func handleMatchMessage(d ampq.Delivery) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), amqpHeaderCarrier(d.Headers))
+	ctx, span := tracer.Start(ctx, "amqp.process match", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	var alert SIREN.SirenAlertPushNotification
+	if err := msgpack.Unmarshal(d.Body, &alert); err != nil {
+		log.Error("Failed to unmarshal the alert", "err", err)
+		span.SetStatus(codes.Error, "failed to unmarshal alert")
+		return
+	}
+	span.SetAttributes(attribute.String("siren.id", alert.Identifier))
+
+	start := time.Now()
+	deliveries := index.Match(alert)
+	matchDuration.Observe(time.Since(start).Seconds())
+	alertsMatched.Inc()
+	span.SetAttributes(attribute.Int("match.deliveries", len(deliveries)))
+
+	headers := ampq.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, amqpHeaderCarrier(headers))
+	failed := 0
+	for _, delivery := range deliveries {
+		body, err := msgpack.Marshal(delivery)
+		if err == nil {
+			err = ch.PublishWithContext(ctx, "", deliveriesQueue.Name, false, false, ampq.Publishing{
+				ContentType: "application/msgpack",
+				Headers:     headers,
+				Body:        body,
+			})
+		}
+		if err != nil {
+			failed++
+			continue
+		}
+		deliveriesPublished.WithLabelValues(delivery.Matched).Inc()
+	}
+	if failed > 0 {
+		log.Error("Failed to publish some deliveries", "id", alert.Identifier, "failed", failed, "of", len(deliveries))
+		span.SetStatus(codes.Error, "failed to publish deliveries")
+	}
+	log.Info("Matched alert", "id", alert.Identifier, "action", alert.Action, "deliveries", len(deliveries), "took", time.Since(start))
+}
+
+/**============================================
+ *                 Driver Code
+ *=============================================**/
+
+func main() {
+	if os.Getenv("ENV") != "PROD" {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.Print("Starting match service...")
+
+	shutdownTracing := initTracing("match-service")
+	defer shutdownTracing(context.Background())
+
+	r, err := loadResolver()
+	if err != nil {
+		log.Fatal("Failed to open the NWS UGC stores", "err", err)
+	}
+	resolver.Store(r)
+	log.Info("Loaded UGC areas", "areas", r.Len())
+
+	ConnectToMongo()
+	defer client.Disconnect(context.TODO())
+
+	start := time.Now()
+	if err := loadSubscriptions(context.Background()); err != nil {
+		log.Fatal("Failed to load subscriptions", "err", err)
+	}
+	log.Info("Loaded subscriptions", "subscriptions", index.Len(), "took", time.Since(start))
+
+	connectToMQ()
+	defer conn.Close()
+	defer ch.Close()
+
+	msgs, err := ch.Consume(matchQueue.Name, "", true, false, false, false, nil)
+	if err != nil {
+		log.Fatal("Failed to consume messages from the match queue", "err", err)
+	}
+
+	// MATCH_WORKERS is how many alerts are matched at once
+	workers := 4
+	if v, err := strconv.Atoi(os.Getenv("MATCH_WORKERS")); err == nil && v > 0 {
+		workers = v
+	}
+	for range workers {
+		go func() {
+			for d := range msgs {
+				handleMatchMessage(d)
+			}
+		}()
+	}
+
+	// Pick up new datasets from the UGC loader without a restart
+	go watchUGCStores(30 * time.Second)
+
+	if apiToken = os.Getenv("MATCH_API_TOKEN"); apiToken == "" {
+		log.Warn("MATCH_API_TOKEN not set, the subscription API is disabled")
+	}
+	http.HandleFunc("/subscriptions/{id}", requireCaller(HandleSubscription))
+	http.HandleFunc("GET /subscriptions", requireCaller(HandleSubscriberRequest))
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", HandleHealthz)
+
+	port := envOr("PORT", "6907")
+	log.Info("Serving the subscription API", "port", port)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		log.Fatal("HTTP server stopped", "err", err)
+	}
+}
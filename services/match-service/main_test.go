@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"matchService/Match"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// The driver marshals index keys as a document, a type it doesn't know fails before reaching the server
+func TestSubscriptionIndexesEncode(t *testing.T) {
+	for _, model := range subscriptionIndexes {
+		if _, err := bson.Marshal(model.Keys); err != nil {
+			t.Errorf("index keys %v: %v", model.Keys, err)
+		}
+	}
+}
+
+// Runs the startup path against a real server, set MONGO_TEST_URI to run it
+func TestMongoStartup(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(context.Background())
+
+	database := c.Database(fmt.Sprintf("siren_test_%d", time.Now().UnixNano()))
+	defer database.Drop(context.Background())
+	subscriptionsCollection = database.Collection("subscriptions")
+	resolver.Store(Match.NewResolver("test", nil))
+	index = Match.NewIndex()
+
+	if err := createIndexes(ctx, subscriptionsCollection); err != nil {
+		t.Fatalf("creating the indexes: %v", err)
+	}
+	// Creating them again on the next start is a no-op
+	if err := createIndexes(ctx, subscriptionsCollection); err != nil {
+		t.Fatalf("creating the indexes again: %v", err)
+	}
+
+	sub := Match.Subscription{ID: "home", Subscriber: "device", UGCs: []string{"IAC153"}, Created: time.Now(), Updated: time.Now()}
+	if _, err := subscriptionsCollection.InsertOne(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subscriptionsCollection.InsertOne(ctx, sub); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("inserting the same id again gave %v, want a duplicate key error", err)
+	}
+
+	if err := loadSubscriptions(ctx); err != nil {
+		t.Fatalf("loading the subscriptions: %v", err)
+	}
+	if loaded, ok := index.Get("home"); !ok || loaded.Subscriber != "device" {
+		t.Errorf("loaded %+v, want the saved subscription", loaded)
+	}
+}
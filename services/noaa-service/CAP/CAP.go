@@ -499,8 +499,17 @@ func convertResources(oldRes []*ResourceXML) []Resource {
 	return res
 }
 
+// NWS writes the motion as 2025-06-15T18:00:00-00:00...storm...239DEG...28KT...42.1,-93.8
+// with several locations separated by spaces, older feeds used | and ; instead
 func convertEventMotionDescriptionFromString(s string) *EventMotionDescription {
 	parts := strings.Split(s, "|")
+	if len(parts) < 4 {
+		parts = strings.Split(s, "...")
+		// Drop the storm/line label between the time and the direction
+		if len(parts) >= 5 {
+			parts = append(parts[:1], parts[2:]...)
+		}
+	}
 	if len(parts) < 4 {
 		return nil
 	}
@@ -511,7 +520,7 @@ func convertEventMotionDescriptionFromString(s string) *EventMotionDescription {
 	direction := parts[1]
 	speed := parts[2]
 	locationStr := parts[3]
-	locPairs := strings.Split(locationStr, ";")
+	locPairs := strings.FieldsFunc(locationStr, func(r rune) bool { return r == ';' || r == ' ' })
 	var locations []Coordinate
 	for _, pair := range locPairs {
 		coords := strings.Split(pair, ",")
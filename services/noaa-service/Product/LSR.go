@@ -0,0 +1,137 @@
+package Product
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------- Local Storm Reports -------------------------------- */
+
+// LSR is a single report from a Local Storm Report product
+type LSR struct {
+	Time      time.Time `msgpack:"time"`
+	Event     string    `msgpack:"event"` // e.g. TORNADO, HAIL, TSTM WND GST
+	Magnitude Magnitude `msgpack:"magnitude"`
+	Location  string    `msgpack:"location"` // Relative to a city, e.g. 2 NW AMES
+	County    string    `msgpack:"county"`
+	State     string    `msgpack:"state"`
+	Lat       float64   `msgpack:"lat"`
+	Lon       float64   `msgpack:"lon"`
+	Source    string    `msgpack:"source"`
+	Remarks   string    `msgpack:"remarks,omitempty"`
+}
+
+type Magnitude struct {
+	Qualifier string  `msgpack:"qualifier,omitempty"` // E for estimated, M for measured, U for unknown
+	Value     float64 `msgpack:"value,omitempty"`
+	Units     string  `msgpack:"units,omitempty"` // e.g. INCH, MPH
+	Raw       string  `msgpack:"raw,omitempty"`
+}
+
+// Reports are laid out in fixed columns, see NWS Directive 10-517
+//
+//	0510 PM     TORNADO          2 NW AMES               42.05N 93.65W
+//	06/15/2025  E1.00 INCH       STORY              IA   STORM CHASER
+//
+//	            BRIEF TORNADO TOUCHDOWN IN OPEN FIELD.
+const (
+	lsrEventCol    = 12
+	lsrLocationCol = 29
+	lsrCountyCol   = 29
+	lsrStateCol    = 48
+	lsrLatLonCol   = 53
+	lsrSourceCol   = 53
+)
+
+var lsrTimeRE = regexp.MustCompile(`^(\d{4}) (AM|PM)\s`)
+var lsrDateRE = regexp.MustCompile(`^(\d{2}/\d{2}/\d{4})`)
+var latLonRE = regexp.MustCompile(`(\d+(?:\.\d+)?)([NS])\s+(\d+(?:\.\d+)?)([EW])`)
+var magnitudeRE = regexp.MustCompile(`^([EMU])?\s*(\d+(?:\.\d+)?)\s*(.*)$`)
+
+// ParseLSRs reads every report in a Local Storm Report product. Times in the reports are in
+// the zone of the product's issuance line, and are returned in UTC.
+func ParseLSRs(text string) []LSR {
+	loc, _ := ProductZone(text)
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+
+	var reports []LSR
+	for i := 0; i+1 < len(lines); i++ {
+		first := lines[i]
+		second := lines[i+1]
+		timeMatch := lsrTimeRE.FindStringSubmatch(first)
+		dateMatch := lsrDateRE.FindStringSubmatch(second)
+		if timeMatch == nil || dateMatch == nil {
+			continue
+		}
+
+		reportTime, err := time.ParseInLocation("01/02/2006 0304 PM", dateMatch[1]+" "+timeMatch[1]+" "+timeMatch[2], loc)
+		if err != nil {
+			continue
+		}
+
+		report := LSR{
+			Time:      reportTime.UTC(),
+			Event:     column(first, lsrEventCol, lsrLocationCol),
+			Location:  column(first, lsrLocationCol, lsrLatLonCol),
+			Magnitude: parseMagnitude(column(second, lsrEventCol, lsrCountyCol)),
+			County:    column(second, lsrCountyCol, lsrStateCol),
+			State:     column(second, lsrStateCol, lsrSourceCol),
+			Source:    column(second, lsrSourceCol, len(second)),
+		}
+		if m := latLonRE.FindStringSubmatch(column(first, lsrLatLonCol, len(first))); m != nil {
+			report.Lat, _ = strconv.ParseFloat(m[1], 64)
+			report.Lon, _ = strconv.ParseFloat(m[3], 64)
+			if m[2] == "S" {
+				report.Lat = -report.Lat
+			}
+			if m[4] == "W" {
+				report.Lon = -report.Lon
+			}
+		}
+
+		i += 2
+		report.Remarks, i = readRemarks(lines, i)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Collects the indented remark lines that follow a report, returning the line to continue from
+func readRemarks(lines []string, i int) (string, int) {
+	// There is a blank line between the report and its remarks
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+
+	var remarks []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" || lsrTimeRE.MatchString(line) || !strings.HasPrefix(line, " ") {
+			break
+		}
+		remarks = append(remarks, strings.TrimSpace(line))
+	}
+	// Step back so the caller's loop lands on the line that ended the remarks
+	return strings.Join(remarks, " "), i - 1
+}
+
+func parseMagnitude(raw string) Magnitude {
+	magnitude := Magnitude{Raw: raw}
+	if m := magnitudeRE.FindStringSubmatch(raw); m != nil {
+		magnitude.Qualifier = m[1]
+		magnitude.Value, _ = strconv.ParseFloat(m[2], 64)
+		magnitude.Units = strings.TrimSpace(m[3])
+	}
+	return magnitude
+}
+
+// The trimmed text between two columns, tolerating short lines
+func column(line string, start int, end int) string {
+	if start >= len(line) {
+		return ""
+	}
+	end = min(end, len(line))
+	return strings.TrimSpace(line[start:end])
+}
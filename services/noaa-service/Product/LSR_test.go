@@ -0,0 +1,150 @@
+package Product
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseLSRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    []LSR
+	}{
+		{
+			name:    "multiple reports, with and without remarks",
+			fixture: "LSRDMX.txt",
+			want: []LSR{
+				{
+					Time: time.Date(2025, 6, 15, 22, 10, 0, 0, time.UTC), Event: "TORNADO", Location: "2 NW AMES",
+					County: "STORY", State: "IA", Lat: 42.05, Lon: -93.65, Source: "STORM CHASER",
+					Remarks: "BRIEF TORNADO TOUCHDOWN IN OPEN FIELD. NO DAMAGE REPORTED.",
+				},
+				{
+					Time: time.Date(2025, 6, 15, 22, 35, 0, 0, time.UTC), Event: "HAIL", Location: "AMES",
+					Magnitude: Magnitude{Qualifier: "M", Value: 1.75, Units: "INCH", Raw: "M1.75 INCH"},
+					County:    "STORY", State: "IA", Lat: 42.03, Lon: -93.62, Source: "TRAINED SPOTTER",
+				},
+				{
+					Time: time.Date(2025, 6, 15, 22, 40, 0, 0, time.UTC), Event: "TSTM WND GST", Location: "3 E NEVADA",
+					Magnitude: Magnitude{Qualifier: "E", Value: 60, Units: "MPH", Raw: "E60 MPH"},
+					County:    "STORY", State: "IA", Lat: 42.02, Lon: -93.40, Source: "PUBLIC",
+					Remarks: "LARGE TREE LIMBS DOWN.",
+				},
+			},
+		},
+		{
+			name:    "short lines, local evening is the next UTC day",
+			fixture: "LSRSHORT.txt",
+			want: []LSR{
+				{
+					Time: time.Date(2025, 6, 2, 0, 55, 0, 0, time.UTC), Event: "FUNNEL CLOUD",
+					County: "ADAMS", State: "CO",
+				},
+				{
+					Time: time.Date(2025, 6, 2, 1, 2, 0, 0, time.UTC), Event: "HAIL", Location: "BENNETT",
+					Magnitude: Magnitude{Qualifier: "U", Value: 0.75, Units: "INCH", Raw: "U0.75 INCH"},
+					County:    "ADAMS", State: "CO", Lat: 39.76, Lon: -104.43, Source: "PUBLIC",
+				},
+			},
+		},
+		{
+			name:    "eastern hemisphere",
+			fixture: "LSRGUM.txt",
+			want: []LSR{
+				{
+					Time: time.Date(2025, 6, 17, 7, 10, 0, 0, time.UTC), Event: "HEAVY RAIN", Location: "HAGATNA",
+					Magnitude: Magnitude{Qualifier: "M", Value: 2.10, Units: "INCH", Raw: "M2.10 INCH"},
+					County:    "GUAM", State: "GU", Lat: 13.48, Lon: 144.75, Source: "ASOS",
+					Remarks: "2.10 INCHES IN ONE HOUR.",
+				},
+			},
+		},
+		{
+			name:    "southern hemisphere",
+			fixture: "LSRPPG.txt",
+			want: []LSR{
+				{
+					Time: time.Date(2025, 6, 18, 3, 15, 0, 0, time.UTC), Event: "HIGH SUST WINDS", Location: "PAGO PAGO",
+					Magnitude: Magnitude{Qualifier: "E", Value: 45, Units: "MPH", Raw: "E45 MPH"},
+					County:    "TUTUILA", State: "AS", Lat: -14.28, Lon: -170.70, Source: "EMERGENCY MNGR",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLSRs(readFixture(t, tt.fixture))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d reports, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if !got[i].Time.Equal(tt.want[i].Time) {
+					t.Errorf("report %d time = %v, want %v", i, got[i].Time, tt.want[i].Time)
+				}
+				got[i].Time = tt.want[i].Time
+				if got[i] != tt.want[i] {
+					t.Errorf("report %d\n got %+v\nwant %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadRemarks(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		remarks string
+		next    int // The line the caller's loop lands on next
+	}{
+		{
+			name:    "blank line then remarks",
+			lines:   []string{"", "            FIRST LINE.", "            SECOND LINE.", "", "0535 PM     HAIL"},
+			remarks: "FIRST LINE. SECOND LINE.",
+			next:    3,
+		},
+		{
+			name:    "next report right away",
+			lines:   []string{"", "0535 PM     HAIL"},
+			remarks: "",
+			next:    1,
+		},
+		{
+			name:    "end of product",
+			lines:   []string{"", "&&"},
+			remarks: "",
+			next:    1,
+		},
+		{
+			name:    "remarks to the last line",
+			lines:   []string{"            ONLY LINE."},
+			remarks: "ONLY LINE.",
+			next:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remarks, i := readRemarks(tt.lines, 0)
+			if remarks != tt.remarks {
+				t.Errorf("remarks = %q, want %q", remarks, tt.remarks)
+			}
+			if i+1 != tt.next {
+				t.Errorf("continues at line %d, want %d", i+1, tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package Product
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------- Text Products -------------------------------- */
+
+// Product is a raw NWWS text product with its headers parsed
+type Product struct {
+	ID       string               `msgpack:"id"`       // NWWS message id
+	AWIPSID  string               `msgpack:"awipsId"`  // e.g. LSRDMX
+	Category string               `msgpack:"category"` // First three letters of the AWIPS id, e.g. LSR
+	Office   string               `msgpack:"office"`   // The rest of the AWIPS id, e.g. DMX
+	WMO      WMOHeader            `msgpack:"wmo"`
+	Issued   time.Time            `msgpack:"issued"`
+	Text     string               `msgpack:"text"`
+	LSRs     []LSR                `msgpack:"lsrs,omitempty"`
+	Watch    *Watch               `msgpack:"watch,omitempty"`
+	MCD      *MesoscaleDiscussion `msgpack:"mcd,omitempty"`
+}
+
+// WMOHeader is the abbreviated heading on the first line of every product, e.g. NWUS53 KDMX 152315
+type WMOHeader struct {
+	DataType string    `msgpack:"dataType"` // TTAAii, e.g. NWUS53
+	Station  string    `msgpack:"station"`  // CCCC, e.g. KDMX
+	Issued   time.Time `msgpack:"issued"`   // DDHHMM, resolved to a full time
+	BBB      string    `msgpack:"bbb,omitempty"`
+}
+
+var ErrNoWMOHeader = errors.New("no WMO header found")
+
+var wmoHeaderRE = regexp.MustCompile(`^([A-Z]{4}\d{2}) ([A-Z]{4}) (\d{6})(?: ([A-Z]{3}))?\s*$`)
+var awipsLineRE = regexp.MustCompile(`^([A-Z0-9]{4,6})\s*$`)
+
+// Dedup key for a product, the same issuance has the same WMO heading on every NWWS server
+func (p *Product) Key() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s %s %s", p.AWIPSID, p.WMO.DataType, p.WMO.Station, p.WMO.Issued.Format("021504"), p.WMO.BBB))
+}
+
+// ParseProduct parses the headers of a text product. The AWIPS id from the NWWS stanza is
+// used when the product doesn't repeat it, and issued anchors the WMO day of month.
+func ParseProduct(id string, awipsID string, text string, issued time.Time) (*Product, error) {
+	text = strings.ReplaceAll(text, "\r", "")
+	lines := strings.Split(text, "\n")
+
+	product := &Product{
+		ID:     id,
+		Issued: issued,
+		Text:   text,
+	}
+
+	headerLine := -1
+	for i, line := range lines {
+		if i > 5 {
+			break
+		}
+		if m := wmoHeaderRE.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			wmoIssued, err := resolveDayTime(m[3], issued)
+			if err != nil {
+				return nil, err
+			}
+			product.WMO = WMOHeader{DataType: m[1], Station: m[2], Issued: wmoIssued, BBB: m[4]}
+			headerLine = i
+			break
+		}
+	}
+	if headerLine < 0 {
+		return nil, ErrNoWMOHeader
+	}
+
+	product.AWIPSID = awipsID
+	if headerLine+1 < len(lines) {
+		if m := awipsLineRE.FindStringSubmatch(strings.TrimSpace(lines[headerLine+1])); m != nil {
+			product.AWIPSID = m[1]
+		}
+	}
+	if len(product.AWIPSID) >= 3 {
+		product.Category = product.AWIPSID[:3]
+		product.Office = product.AWIPSID[3:]
+	}
+
+	if product.Issued.IsZero() {
+		product.Issued = product.WMO.Issued
+	}
+	return product, nil
+}
+
+// Resolves a DDHHMM group against a reference time, picking the month that puts it closest
+func resolveDayTime(ddhhmm string, ref time.Time) (time.Time, error) {
+	day, _ := strconv.Atoi(ddhhmm[0:2])
+	hour, _ := strconv.Atoi(ddhhmm[2:4])
+	minute, _ := strconv.Atoi(ddhhmm[4:6])
+	if day < 1 || day > 31 || hour > 23 || minute > 59 {
+		return time.Time{}, fmt.Errorf("invalid WMO time %q", ddhhmm)
+	}
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+	ref = ref.UTC()
+
+	best := time.Time{}
+	for _, offset := range []int{-1, 0, 1} {
+		candidate := time.Date(ref.Year(), ref.Month()+time.Month(offset), day, hour, minute, 0, 0, time.UTC)
+		// Skip days that don't exist in the month, Date would roll them over
+		if candidate.Day() != day {
+			continue
+		}
+		if best.IsZero() || absDuration(candidate.Sub(ref)) < absDuration(best.Sub(ref)) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+/* -------------------------------- Local Time -------------------------------- */
+
+// Offsets of the time zones NWS offices write their products in
+var zoneOffsets = map[string]int{
+	"UTC":  0,
+	"GMT":  0,
+	"AST":  -4,
+	"ADT":  -3,
+	"EST":  -5,
+	"EDT":  -4,
+	"CST":  -6,
+	"CDT":  -5,
+	"MST":  -7,
+	"MDT":  -6,
+	"PST":  -8,
+	"PDT":  -7,
+	"AKST": -9,
+	"AKDT": -8,
+	"HST":  -10,
+	"SST":  -11,
+	"CHST": 10,
+}
+
+// Matches the issuance line under the product title, e.g. 515 PM CDT MON JUN 15 2025
+var issuanceRE = regexp.MustCompile(`(?m)^(\d{3,4}) (AM|PM) ([A-Z]{3,4}) [A-Z]{3} ([A-Z]{3}) (\d{1,2}) (\d{4})\s*$`)
+
+// ProductZone finds the time zone the product's local times are written in, from its issuance line
+func ProductZone(text string) (*time.Location, bool) {
+	m := issuanceRE.FindStringSubmatch(text)
+	if m == nil {
+		return time.UTC, false
+	}
+	return zone(m[3])
+}
+
+func zone(abbreviation string) (*time.Location, bool) {
+	offset, ok := zoneOffsets[strings.ToUpper(abbreviation)]
+	if !ok {
+		return time.UTC, false
+	}
+	return time.FixedZone(abbreviation, offset*3600), true
+}
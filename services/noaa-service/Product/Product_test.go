@@ -0,0 +1,142 @@
+package Product
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseProduct(t *testing.T) {
+	issued := time.Date(2025, 6, 15, 23, 16, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		awipsID  string
+		text     string
+		issued   time.Time
+		want     WMOHeader
+		awips    string
+		office   string
+		wantTime time.Time
+		fails    bool
+		err      error // Checked when set
+	}{
+		{
+			name:     "LSR",
+			text:     readFixture(t, "LSRDMX.txt"),
+			issued:   issued,
+			want:     WMOHeader{DataType: "NWUS53", Station: "KDMX", Issued: time.Date(2025, 6, 15, 23, 15, 0, 0, time.UTC)},
+			awips:    "LSRDMX",
+			office:   "DMX",
+			wantTime: issued,
+		},
+		{
+			name:     "correction with CRLF line endings",
+			text:     "000\r\nNWUS53 KDMX 152330 CCA\r\nLSRDMX\r\n\r\nPRELIMINARY LOCAL STORM REPORT...CORRECTED\r\n",
+			issued:   issued,
+			want:     WMOHeader{DataType: "NWUS53", Station: "KDMX", Issued: time.Date(2025, 6, 15, 23, 30, 0, 0, time.UTC), BBB: "CCA"},
+			awips:    "LSRDMX",
+			office:   "DMX",
+			wantTime: issued,
+		},
+		{
+			name:     "AWIPS id only in the stanza",
+			awipsID:  "SWOMCD",
+			text:     "000\nACUS11 KWNS 152012\n\nMesoscale Discussion 1234\n",
+			issued:   issued,
+			want:     WMOHeader{DataType: "ACUS11", Station: "KWNS", Issued: time.Date(2025, 6, 15, 20, 12, 0, 0, time.UTC)},
+			awips:    "SWOMCD",
+			office:   "MCD",
+			wantTime: issued,
+		},
+		{
+			name:     "no issue time uses the WMO heading",
+			text:     "000\nWWUS30 KWNS 152005\nSAW4\n",
+			want:     WMOHeader{DataType: "WWUS30", Station: "KWNS"},
+			awips:    "SAW4",
+			office:   "4",
+			wantTime: time.Time{},
+		},
+		{
+			name:  "no WMO heading",
+			text:  "PRELIMINARY LOCAL STORM REPORT\nNATIONAL WEATHER SERVICE DES MOINES IA\n",
+			fails: true,
+			err:   ErrNoWMOHeader,
+		},
+		{
+			name:   "invalid WMO time",
+			text:   "000\nNWUS53 KDMX 322400\nLSRDMX\n",
+			issued: issued,
+			fails:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product, err := ParseProduct("1", tt.awipsID, tt.text, tt.issued)
+			if tt.fails {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want.Issued.IsZero() {
+				// Resolved against the clock, only the day and time are known
+				if got := product.WMO.Issued.Format("021504"); got != "152005" {
+					t.Errorf("WMO issued = %s, want day 15 at 2005", got)
+				}
+				if !product.Issued.Equal(product.WMO.Issued) {
+					t.Errorf("issued = %v, want the WMO time %v", product.Issued, product.WMO.Issued)
+				}
+				tt.want.Issued = product.WMO.Issued
+			} else if !product.Issued.Equal(tt.wantTime) {
+				t.Errorf("issued = %v, want %v", product.Issued, tt.wantTime)
+			}
+			if product.WMO != tt.want {
+				t.Errorf("WMO = %+v, want %+v", product.WMO, tt.want)
+			}
+			if product.AWIPSID != tt.awips || product.Category != tt.awips[:3] || product.Office != tt.office {
+				t.Errorf("AWIPS = %s %s %s, want %s %s %s", product.AWIPSID, product.Category, product.Office, tt.awips, tt.awips[:3], tt.office)
+			}
+		})
+	}
+}
+
+func TestResolveDayTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		ddhhmm string
+		ref    time.Time
+		want   time.Time
+	}{
+		{"same day", "152315", time.Date(2025, 6, 15, 23, 16, 0, 0, time.UTC), time.Date(2025, 6, 15, 23, 15, 0, 0, time.UTC)},
+		{"next month", "010005", time.Date(2025, 6, 30, 23, 50, 0, 0, time.UTC), time.Date(2025, 7, 1, 0, 5, 0, 0, time.UTC)},
+		{"previous month", "302355", time.Date(2025, 7, 1, 0, 10, 0, 0, time.UTC), time.Date(2025, 6, 30, 23, 55, 0, 0, time.UTC)},
+		{"previous year", "312355", time.Date(2026, 1, 1, 0, 5, 0, 0, time.UTC), time.Date(2025, 12, 31, 23, 55, 0, 0, time.UTC)},
+		{"next year", "010010", time.Date(2025, 12, 31, 23, 58, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 10, 0, 0, time.UTC)},
+		{"day missing from the previous month", "312350", time.Date(2025, 10, 1, 0, 5, 0, 0, time.UTC), time.Date(2025, 10, 31, 23, 50, 0, 0, time.UTC)},
+		{"leap day", "292359", time.Date(2024, 3, 1, 0, 1, 0, 0, time.UTC), time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC)},
+		{"reference in another zone", "010030", time.Date(2025, 6, 30, 20, 0, 0, 0, time.FixedZone("CDT", -5*3600)), time.Date(2025, 7, 1, 0, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDayTime(tt.ddhhmm, tt.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"000000", "320000", "152400", "151260"} {
+		if _, err := resolveDayTime(invalid, time.Now()); err == nil {
+			t.Errorf("%s resolved, want an error", invalid)
+		}
+	}
+}
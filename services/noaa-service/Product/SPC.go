@@ -0,0 +1,347 @@
+package Product
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------- SPC Watches -------------------------------- */
+
+// The kinds of watch SPC issues
+const (
+	WatchTornado      = "Tornado"
+	WatchSevereTstorm = "Severe Thunderstorm"
+)
+
+// Watch holds what one SPC product says about a watch. Each product carries part of it:
+// SEL the public text, WWP the probabilities, WOU the counties and SAW the parallelogram.
+type Watch struct {
+	Number        int                 `msgpack:"number"`
+	Type          string              `msgpack:"type"`
+	PDS           bool                `msgpack:"pds"` // Particularly dangerous situation
+	Issued        time.Time           `msgpack:"issued,omitempty"`
+	Expires       time.Time           `msgpack:"expires,omitempty"`
+	VTEC          string              `msgpack:"vtec,omitempty"`
+	UGCs          []string            `msgpack:"ugcs,omitempty"`
+	Polygon       [][2]float64        `msgpack:"polygon,omitempty"` // lon/lat, closed
+	Probabilities *WatchProbabilities `msgpack:"probabilities,omitempty"`
+	Attributes    *WatchAttributes    `msgpack:"attributes,omitempty"`
+}
+
+// A probability from the WWP table, which writes low values as <05% and high ones as >95%
+type Probability struct {
+	Percent     int  `msgpack:"percent"`
+	LessThan    bool `msgpack:"lessThan,omitempty"`
+	GreaterThan bool `msgpack:"greaterThan,omitempty"`
+}
+
+func (p Probability) String() string {
+	if p.LessThan {
+		return fmt.Sprintf("<%d%%", p.Percent)
+	}
+	if p.GreaterThan {
+		return fmt.Sprintf(">%d%%", p.Percent)
+	}
+	return fmt.Sprintf("%d%%", p.Percent)
+}
+
+type WatchProbabilities struct {
+	Tornadoes       Probability `msgpack:"tornadoes"`       // 2 or more tornadoes
+	StrongTornadoes Probability `msgpack:"strongTornadoes"` // 1 or more EF2-EF5 tornadoes
+	Wind            Probability `msgpack:"wind"`            // 10 or more severe wind events
+	StrongWind      Probability `msgpack:"strongWind"`      // 1 or more wind events of 65 knots or more
+	Hail            Probability `msgpack:"hail"`            // 10 or more severe hail events
+	LargeHail       Probability `msgpack:"largeHail"`       // 1 or more hail events of 2 inches or more
+	Combined        Probability `msgpack:"combined"`        // 6 or more combined severe hail and wind events
+}
+
+type WatchAttributes struct {
+	MaxHail          float64 `msgpack:"maxHail"`          // Inches
+	MaxWindGust      int     `msgpack:"maxWindGust"`      // Knots
+	MaxTops          int     `msgpack:"maxTops"`          // Hundreds of feet
+	StormMotionDir   int     `msgpack:"stormMotionDir"`   // Degrees
+	StormMotionSpeed int     `msgpack:"stormMotionSpeed"` // Knots
+}
+
+var selRE = regexp.MustCompile(`(?i)(Tornado|Severe Thunderstorm) Watch Number (\d+)`)
+var wwpRE = regexp.MustCompile(`WW (\d+) (TORNADO|SEVERE TSTM) .*?(\d{6})Z - (\d{6})Z`)
+var spcVTECRE = regexp.MustCompile(`/[OTEX]\.[A-Z]{3}\.KWNS\.(TO|SV)\.A\.(\d{4})\.(\d{6}T\d{4}Z)-(\d{6}T\d{4}Z)/`)
+var probabilityRE = regexp.MustCompile(`(?m)^[ \t]*PROB OF (.+?)\s*:\s*([<>]?)(\d+)%`)
+var attributeRE = regexp.MustCompile(`(?m)^[ \t]*(MAX HAIL|MAX WIND GUSTS|MAX TOPS|MEAN STORM MOTION VECTOR|PARTICULARLY DANGEROUS SITUATION)[^:]*:\s*(\S+)`)
+
+// ParseWatch reads the watch in a SEL, WWP, WOU or SAW product, or returns nil if there isn't one
+func ParseWatch(product *Product) *Watch {
+	text := product.Text
+	watch := &Watch{}
+
+	switch product.Category {
+	case "SEL":
+		m := selRE.FindStringSubmatch(text)
+		if m == nil {
+			return nil
+		}
+		watch.Type = watchType(m[1])
+		watch.Number, _ = strconv.Atoi(m[2])
+		watch.PDS = strings.Contains(strings.ToUpper(text), "PARTICULARLY DANGEROUS SITUATION")
+	case "WWP", "SAW":
+		m := wwpRE.FindStringSubmatch(text)
+		if m == nil {
+			return nil
+		}
+		watch.Number, _ = strconv.Atoi(m[1])
+		watch.Type = watchType(m[2])
+		watch.Issued, _ = resolveDayTime(m[3], product.Issued)
+		watch.Expires, _ = resolveDayTime(m[4], product.Issued)
+		if product.Category == "WWP" {
+			watch.Probabilities = parseProbabilities(text)
+			watch.Attributes, watch.PDS = parseAttributes(text)
+		}
+	case "WOU":
+		m := spcVTECRE.FindStringSubmatch(text)
+		if m == nil {
+			return nil
+		}
+		watch.VTEC = strings.Trim(m[0], "/")
+		watch.Number, _ = strconv.Atoi(m[2])
+		watch.Type = watchType(m[1])
+		watch.Issued, _ = time.Parse("060102T1504Z", m[3])
+		watch.Expires, _ = time.Parse("060102T1504Z", m[4])
+		watch.UGCs = ParseUGCs(text)
+	default:
+		return nil
+	}
+
+	if watch.Number == 0 || watch.Type == "" {
+		return nil
+	}
+	watch.Polygon = ParseLatLon(text)
+	return watch
+}
+
+func watchType(s string) string {
+	switch strings.ToUpper(s) {
+	case "TORNADO", "TO":
+		return WatchTornado
+	default:
+		return WatchSevereTstorm
+	}
+}
+
+func parseProbabilities(text string) *WatchProbabilities {
+	matches := probabilityRE.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var probabilities WatchProbabilities
+	for _, m := range matches {
+		percent, _ := strconv.Atoi(m[3])
+		p := Probability{Percent: percent, LessThan: m[2] == "<", GreaterThan: m[2] == ">"}
+		label := m[1]
+		switch {
+		case strings.Contains(label, "STRONG") && strings.Contains(label, "TORNADO"):
+			probabilities.StrongTornadoes = p
+		case strings.Contains(label, "TORNADO"):
+			probabilities.Tornadoes = p
+		case strings.Contains(label, "COMBINED"):
+			probabilities.Combined = p
+		case strings.Contains(label, "WIND") && strings.Contains(label, "KNOTS"):
+			probabilities.StrongWind = p
+		case strings.Contains(label, "WIND"):
+			probabilities.Wind = p
+		case strings.Contains(label, "HAIL") && strings.Contains(label, "INCHES"):
+			probabilities.LargeHail = p
+		case strings.Contains(label, "HAIL"):
+			probabilities.Hail = p
+		}
+	}
+	return &probabilities
+}
+
+func parseAttributes(text string) (*WatchAttributes, bool) {
+	matches := attributeRE.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil, false
+	}
+
+	var attributes WatchAttributes
+	pds := false
+	for _, m := range matches {
+		value := m[2]
+		switch m[1] {
+		case "MAX HAIL":
+			attributes.MaxHail, _ = strconv.ParseFloat(value, 64)
+		case "MAX WIND GUSTS":
+			attributes.MaxWindGust, _ = strconv.Atoi(value)
+		case "MAX TOPS":
+			attributes.MaxTops, _ = strconv.Atoi(value)
+		case "MEAN STORM MOTION VECTOR":
+			// Written as DDDSS, direction in degrees then speed in knots
+			if len(value) == 5 {
+				attributes.StormMotionDir, _ = strconv.Atoi(value[:3])
+				attributes.StormMotionSpeed, _ = strconv.Atoi(value[3:])
+			}
+		case "PARTICULARLY DANGEROUS SITUATION":
+			pds = value == "YES"
+		}
+	}
+	return &attributes, pds
+}
+
+/* -------------------------------- Mesoscale Discussions -------------------------------- */
+
+type MesoscaleDiscussion struct {
+	Number           int          `msgpack:"number"`
+	AreasAffected    string       `msgpack:"areasAffected,omitempty"`
+	Concerning       string       `msgpack:"concerning,omitempty"`
+	Watches          []int        `msgpack:"watches,omitempty"`          // Watches the discussion is about
+	WatchProbability int          `msgpack:"watchProbability,omitempty"` // Percent chance a watch will be issued
+	ValidFrom        time.Time    `msgpack:"validFrom,omitempty"`
+	ValidUntil       time.Time    `msgpack:"validUntil,omitempty"`
+	Polygon          [][2]float64 `msgpack:"polygon,omitempty"`
+}
+
+var mcdNumberRE = regexp.MustCompile(`(?i)Mesoscale Discussion (\d+)`)
+var mcdAreasRE = regexp.MustCompile(`(?i)Areas affected\.\.\.(.+)`)
+var mcdConcerningRE = regexp.MustCompile(`(?i)Concerning\.\.\.(.+)`)
+var mcdValidRE = regexp.MustCompile(`(?i)Valid (\d{6})Z - (\d{6})Z`)
+var mcdProbabilityRE = regexp.MustCompile(`(?i)Probability of Watch Issuance\.\.\.(\d+) percent`)
+var mcdWatchRE = regexp.MustCompile(`(?i)Watch (\d+)`)
+
+// ParseMesoscaleDiscussion reads an SWOMCD product, or returns nil if it isn't one
+func ParseMesoscaleDiscussion(product *Product) *MesoscaleDiscussion {
+	if product.AWIPSID != "SWOMCD" {
+		return nil
+	}
+	text := product.Text
+
+	m := mcdNumberRE.FindStringSubmatch(text)
+	if m == nil {
+		return nil
+	}
+	discussion := &MesoscaleDiscussion{Polygon: ParseLatLon(text)}
+	discussion.Number, _ = strconv.Atoi(m[1])
+
+	if m := mcdAreasRE.FindStringSubmatch(text); m != nil {
+		discussion.AreasAffected = strings.TrimSpace(m[1])
+	}
+	if m := mcdConcerningRE.FindStringSubmatch(text); m != nil {
+		discussion.Concerning = strings.TrimSpace(m[1])
+		for _, w := range mcdWatchRE.FindAllStringSubmatch(discussion.Concerning, -1) {
+			number, _ := strconv.Atoi(w[1])
+			discussion.Watches = append(discussion.Watches, number)
+		}
+	}
+	if m := mcdValidRE.FindStringSubmatch(text); m != nil {
+		discussion.ValidFrom, _ = resolveDayTime(m[1], product.Issued)
+		discussion.ValidUntil, _ = resolveDayTime(m[2], product.Issued)
+	}
+	if m := mcdProbabilityRE.FindStringSubmatch(text); m != nil {
+		discussion.WatchProbability, _ = strconv.Atoi(m[1])
+	}
+	return discussion
+}
+
+/* -------------------------------- Shared Fields -------------------------------- */
+
+var latLonStartRE = regexp.MustCompile(`LAT\.\.\.LON`)
+var latLonPairRE = regexp.MustCompile(`^\d{8}$`)
+
+// ParseLatLon reads the LAT...LON block SPC uses for polygons. Each point is written as
+// LLLLOOOO in hundredths of a degree, with the leading 1 of longitudes past 100W dropped.
+func ParseLatLon(text string) [][2]float64 {
+	loc := latLonStartRE.FindStringIndex(text)
+	if loc == nil {
+		return nil
+	}
+
+	var polygon [][2]float64
+	for _, token := range strings.Fields(text[loc[1]:]) {
+		if !latLonPairRE.MatchString(token) {
+			break
+		}
+		lat, _ := strconv.Atoi(token[:4])
+		lon, _ := strconv.Atoi(token[4:])
+		if lon < 5000 {
+			lon += 10000
+		}
+		polygon = append(polygon, [2]float64{-float64(lon) / 100, float64(lat) / 100})
+	}
+	if len(polygon) < 3 {
+		return nil
+	}
+	if polygon[0] != polygon[len(polygon)-1] {
+		polygon = append(polygon, polygon[0])
+	}
+	return polygon
+}
+
+var ugcStartRE = regexp.MustCompile(`^[A-Z]{2}[CZ]\d{3}`)
+
+// ParseUGCs expands the UGC lines of a product, e.g. IAC015-027>029-151900- becomes
+// IAC015, IAC027, IAC028 and IAC029
+func ParseUGCs(text string) []string {
+	var ugcs []string
+	seen := make(map[string]bool)
+	prefix := ""
+	inGroup := false
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if !inGroup && !ugcStartRE.MatchString(line) {
+			continue
+		}
+		inGroup = true
+
+		for _, token := range strings.Split(line, "-") {
+			if token == "" {
+				continue
+			}
+			// The group ends with its expiration time, DDHHMM
+			if len(token) == 6 && isDigits(token) {
+				inGroup = false
+				prefix = ""
+				break
+			}
+			if len(token) >= 6 && !isDigits(token[:3]) {
+				prefix = token[:3]
+				token = token[3:]
+			}
+			if prefix == "" {
+				continue
+			}
+
+			first, last, isRange := strings.Cut(token, ">")
+			start, err := strconv.Atoi(first)
+			if err != nil {
+				continue
+			}
+			end := start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					continue
+				}
+			}
+			for n := start; n <= end; n++ {
+				ugc := fmt.Sprintf("%s%03d", prefix, n)
+				if !seen[ugc] {
+					seen[ugc] = true
+					ugcs = append(ugcs, ugc)
+				}
+			}
+		}
+	}
+	return ugcs
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,248 @@
+package Product
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Parses a fixture as NWWS would hand it over, a minute after the watch was issued
+func parseFixture(t *testing.T, name string) *Product {
+	t.Helper()
+	product, err := ParseProduct("1", "", readFixture(t, name), time.Date(2025, 6, 15, 20, 6, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func TestParseWatch(t *testing.T) {
+	issued := time.Date(2025, 6, 15, 20, 5, 0, 0, time.UTC)
+	expires := time.Date(2025, 6, 16, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		fixture string
+		want    *Watch
+	}{
+		{
+			fixture: "SEL2.txt",
+			want:    &Watch{Number: 412, Type: WatchTornado, PDS: true},
+		},
+		{
+			fixture: "WWP2.txt",
+			want: &Watch{
+				Number: 412, Type: WatchTornado, PDS: true, Issued: issued, Expires: expires,
+				Probabilities: &WatchProbabilities{
+					Tornadoes:       Probability{Percent: 95, GreaterThan: true},
+					StrongTornadoes: Probability{Percent: 80},
+					Wind:            Probability{Percent: 40},
+					StrongWind:      Probability{Percent: 20},
+					Hail:            Probability{Percent: 70},
+					LargeHail:       Probability{Percent: 50},
+					Combined:        Probability{Percent: 5, LessThan: true},
+				},
+				Attributes: &WatchAttributes{MaxHail: 2.5, MaxWindGust: 60, MaxTops: 500, StormMotionDir: 240, StormMotionSpeed: 35},
+			},
+		},
+		{
+			fixture: "WOU2.txt",
+			want: &Watch{
+				Number: 412, Type: WatchTornado, Issued: issued, Expires: expires,
+				VTEC: "O.NEW.KWNS.TO.A.0412.250615T2005Z-250616T0300Z",
+				UGCs: []string{
+					"IAC015", "IAC025", "IAC027", "IAC033", "IAC034", "IAC035", "IAC069", "IAC079", "IAC081",
+					"IAC083", "IAC091", "IAC127", "IAC169", "IAC187", "IAC197", "MNC043", "MNC047", "MNC091",
+				},
+			},
+		},
+		{
+			fixture: "SAW2.txt",
+			want: &Watch{
+				Number: 412, Type: WatchTornado, Issued: issued, Expires: expires,
+				Polygon: [][2]float64{{-95.09, 41.32}, {-94.30, 43.94}, {-92.10, 43.94}, {-92.92, 41.32}, {-95.09, 41.32}},
+			},
+		},
+		{
+			fixture: "SAW3.txt",
+			want: &Watch{
+				Number: 413, Type: WatchSevereTstorm,
+				Issued:  time.Date(2025, 6, 15, 21, 40, 0, 0, time.UTC),
+				Expires: time.Date(2025, 6, 16, 5, 0, 0, 0, time.UTC),
+				Polygon: [][2]float64{{-104.26, 40.63}, {-99.50, 40.88}, {-99.50, 39.16}, {-104.26, 38.91}, {-104.26, 40.63}},
+			},
+		},
+		{
+			fixture: "SWOMCD.txt",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got := ParseWatch(parseFixture(t, tt.fixture))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got %+v\nwant %+v", got, tt.want)
+				if got != nil && tt.want != nil {
+					t.Logf("probabilities %+v, attributes %+v", got.Probabilities, got.Attributes)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProbabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   Probability
+		string string
+	}{
+		{"plain", "   PROB OF 10 OR MORE SEVERE HAIL EVENTS     : 70%", Probability{Percent: 70}, "70%"},
+		{"less than", "   PROB OF 2 OR MORE TORNADOES               : <02%", Probability{Percent: 2, LessThan: true}, "<2%"},
+		{"greater than", "   PROB OF 2 OR MORE TORNADOES               : >95%", Probability{Percent: 95, GreaterThan: true}, ">95%"},
+		{"no space before the value", "PROB OF 2 OR MORE TORNADOES:60%", Probability{Percent: 60}, "60%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probabilities := parseProbabilities(tt.line)
+			if probabilities == nil {
+				t.Fatal("no probabilities parsed")
+			}
+			got := probabilities.Tornadoes
+			if probabilities.Hail != (Probability{}) {
+				got = probabilities.Hail
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.string {
+				t.Errorf("String() = %s, want %s", got.String(), tt.string)
+			}
+		})
+	}
+
+	if probabilities := parseProbabilities("NO TABLE HERE"); probabilities != nil {
+		t.Errorf("got %+v from a product without a table", probabilities)
+	}
+}
+
+func TestParseLatLon(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want [][2]float64
+	}{
+		{
+			name: "closed by the product",
+			text: "LAT...LON   42039552 43109466 43589333\n            42039552\n\nMOST PROBABLE",
+			want: [][2]float64{{-95.52, 42.03}, {-94.66, 43.10}, {-93.33, 43.58}, {-95.52, 42.03}},
+		},
+		{
+			name: "closed by the parser",
+			text: "LAT...LON 41329509 43949430 43949210 41329292\n\nTHIS IS AN APPROXIMATION",
+			want: [][2]float64{{-95.09, 41.32}, {-94.30, 43.94}, {-92.10, 43.94}, {-92.92, 41.32}, {-95.09, 41.32}},
+		},
+		{
+			name: "longitudes past 100W drop their leading 1",
+			text: "LAT...LON 40630426 40889950 39169950 38910001",
+			want: [][2]float64{{-104.26, 40.63}, {-99.50, 40.88}, {-99.50, 39.16}, {-100.01, 38.91}, {-104.26, 40.63}},
+		},
+		{
+			name: "far west",
+			text: "LAT...LON 46282404 48912404 48911706 46281706",
+			want: [][2]float64{{-124.04, 46.28}, {-124.04, 48.91}, {-117.06, 48.91}, {-117.06, 46.28}, {-124.04, 46.28}},
+		},
+		{
+			name: "too few points",
+			text: "LAT...LON 41329509 43949430",
+		},
+		{
+			name: "no block",
+			text: "THIS IS AN APPROXIMATION TO THE WATCH AREA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseLatLon(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUGCs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "ranges",
+			text: "IAC015-027>029-151900-",
+			want: []string{"IAC015", "IAC027", "IAC028", "IAC029"},
+		},
+		{
+			name: "range in the first code",
+			text: "KSZ001>003-005-151900-",
+			want: []string{"KSZ001", "KSZ002", "KSZ003", "KSZ005"},
+		},
+		{
+			name: "group wrapped over two lines",
+			text: "IAC015-025-\n027-151900-\n/O.NEW.KWNS.TO.A.0412.250615T2005Z-250616T0300Z/",
+			want: []string{"IAC015", "IAC025", "IAC027"},
+		},
+		{
+			name: "state changes within a group",
+			text: "NEC001-003-KSC005-151900-",
+			want: []string{"NEC001", "NEC003", "KSC005"},
+		},
+		{
+			name: "duplicates across groups",
+			text: "IAC015-151900-\n\n$$\n\nIAC015-017-151900-",
+			want: []string{"IAC015", "IAC017"},
+		},
+		{
+			name: "text between groups is skipped",
+			text: "IAC015-151900-\nIA\n.    IOWA COUNTIES INCLUDED ARE\n\nBOONE\n\n$$\nMNC043-151900-",
+			want: []string{"IAC015", "MNC043"},
+		},
+		{
+			name: "no groups",
+			text: "TORNADO WATCH 412 IS IN EFFECT UNTIL 1000 PM CDT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUGCs(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMesoscaleDiscussion(t *testing.T) {
+	got := ParseMesoscaleDiscussion(parseFixture(t, "SWOMCD.txt"))
+	want := &MesoscaleDiscussion{
+		Number:           1234,
+		AreasAffected:    "Central and northern Iowa into southern Minnesota",
+		Concerning:       "Severe potential...Tornado Watch 412...",
+		Watches:          []int{412},
+		WatchProbability: 80,
+		ValidFrom:        time.Date(2025, 6, 15, 19, 30, 0, 0, time.UTC),
+		ValidUntil:       time.Date(2025, 6, 15, 21, 0, 0, 0, time.UTC),
+		Polygon: [][2]float64{
+			{-95.52, 42.03}, {-94.66, 43.10}, {-93.33, 43.58}, {-92.08, 43.41}, {-91.56, 42.70},
+			{-92.22, 41.86}, {-93.78, 41.40}, {-95.13, 41.52}, {-95.52, 42.03},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %+v\nwant %+v", got, want)
+	}
+
+	if discussion := ParseMesoscaleDiscussion(parseFixture(t, "SAW2.txt")); discussion != nil {
+		t.Errorf("got %+v from a SAW", discussion)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Remembers recently published CAP identifiers and product keys so the same message
+// arriving from several NWWS sessions, or again in the history of a rejoined room, is
+// published once
+type deduplicator struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newDeduplicator(ttl time.Duration) *deduplicator {
+	return &deduplicator{
+		ttl:       ttl,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Records the identifier and reports whether it hadn't been seen within the TTL
+func (d *deduplicator) firstSighting(identifier string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastSweep) > d.ttl {
+		for id, at := range d.seen {
+			if now.Sub(at) > d.ttl {
+				delete(d.seen, id)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if at, ok := d.seen[identifier]; ok && now.Sub(at) <= d.ttl {
+		return false
+	}
+	d.seen[identifier] = now
+	return true
+}
+
+// Forgets the identifier, for alerts that were accepted but never made it out
+func (d *deduplicator) forget(identifier string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, identifier)
+}
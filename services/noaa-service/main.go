@@ -1,28 +1,181 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"io"
 	"noaaService/CAP"
+	"noaaService/Product"
 	"regexp"
 	"strings"
 
 	"crypto/tls"
 	"encoding/xml"
 	"fmt"
+	"html"
 	"log"
+	"math/rand/v2"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	ampq "github.com/rabbitmq/amqp091-go"
 	"github.com/xmppo/go-xmpp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/vmihailenco/msgpack"
 )
 
+// Prometheus metrics
+var nwwsConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "noaa_nwws_connected",
+	Help: "1 while the session is joined to the NWWS chatroom, 0 otherwise",
+}, []string{"session"})
+
+var nwwsReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_nwws_reconnects_total",
+	Help: "Times the NWWS connection was retried after a failure or disconnect",
+}, []string{"session"})
+
+var nwwsStalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_nwws_stalls_total",
+	Help: "Sessions dropped by the watchdog after NWWS went silent",
+}, []string{"session"})
+
+var nwwsBackoff = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "noaa_nwws_backoff_seconds",
+	Help: "Current wait before the next NWWS reconnect attempt",
+}, []string{"session"})
+
+var stanzasReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_stanzas_received_total",
+	Help: "XMPP stanzas received from NWWS, by type",
+}, []string{"type"})
+
+var capExtracted = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "noaa_cap_extracted_total",
+	Help: "CAP alerts extracted from NWWS messages",
+})
+
+var capRegexMisses = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "noaa_cap_regex_misses_total",
+	Help: "CAP messages with no <alert> element found",
+})
+
+var capFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_cap_failures_total",
+	Help: "CAP alerts dropped before publishing, by stage (unmarshal, convert, encode)",
+}, []string{"stage"})
+
+var capDuplicates = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "noaa_cap_duplicates_total",
+	Help: "CAP alerts dropped because another session or the room history already delivered them",
+})
+
+var productsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_products_published_total",
+	Help: "Text products published to the products queue, by AWIPS category",
+}, []string{"category"})
+
+var productFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "noaa_product_failures_total",
+	Help: "Text products dropped before publishing, by stage (parse, encode, publish)",
+}, []string{"stage"})
+
+var alertsPublished = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "noaa_alerts_published_total",
+	Help: "CAP alerts published to the tracking queue",
+})
+
+var publishFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "noaa_publish_failures_total",
+	Help: "CAP alerts that failed to publish to the tracking queue",
+})
+
+// Alert on time() - noaa_last_cap_timestamp_seconds to catch ingest that has gone quiet
+var lastCAPTime = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "noaa_last_cap_timestamp_seconds",
+	Help: "Unix time the last CAP alert was extracted",
+})
+
+var lastMessageTime atomic.Int64
+
+var secondsSinceLastMessage = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "noaa_seconds_since_last_message",
+	Help: "Seconds since the last stanza of any kind arrived from NWWS",
+}, func() float64 {
+	last := lastMessageTime.Load()
+	if last == 0 {
+		return -1
+	}
+	return time.Since(time.Unix(last, 0)).Seconds()
+})
+
+func init() {
+	prometheus.MustRegister(nwwsConnected)
+	prometheus.MustRegister(nwwsReconnects)
+	prometheus.MustRegister(nwwsStalls)
+	prometheus.MustRegister(nwwsBackoff)
+	prometheus.MustRegister(stanzasReceived)
+	prometheus.MustRegister(capExtracted)
+	prometheus.MustRegister(capRegexMisses)
+	prometheus.MustRegister(capFailures)
+	prometheus.MustRegister(capDuplicates)
+	prometheus.MustRegister(alertsPublished)
+	prometheus.MustRegister(productsPublished)
+	prometheus.MustRegister(productFailures)
+	prometheus.MustRegister(publishFailures)
+	prometheus.MustRegister(lastCAPTime)
+	prometheus.MustRegister(secondsSinceLastMessage)
+}
+
+type keepaliveConfig struct {
+	pingInterval time.Duration
+	stallTimeout time.Duration
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %v", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
 var capAlertRE = regexp.MustCompile(`(?s)(?:<!\[CDATA\[.*?)(<alert.*?>.*?</alert>)(?:.*?\]\]?)`)
 
+// A CAP alert pulled out of a stanza, along with the trace started when the stanza arrived
+type alertMessage struct {
+	ctx  context.Context
+	span trace.Span
+	xml  string
+}
+
+// A text product pulled out of a stanza, along with the trace started when the stanza arrived
+type productMessage struct {
+	ctx       context.Context
+	span      trace.Span
+	messageID string
+	awipsID   string
+	issued    time.Time
+	text      string
+}
+
+// The first three letters of the AWIPS ids of the text products to publish
+var wantedProducts = make(map[string]bool)
+
 func debugLog(msg string) {
 	if os.Getenv("ENV") != "PROD" {
 		log.Println(msg)
@@ -33,6 +186,7 @@ func debugLog(msg string) {
 var conn *ampq.Connection
 var ch *ampq.Channel
 var trackingQueue ampq.Queue
+var productsQueue ampq.Queue
 
 func connectToMQ() {
 	var err error
@@ -58,6 +212,11 @@ func connectToMQ() {
 		log.Fatalf("Failed to declare the tracking queue")
 	}
 
+	productsQueue, err = ch.QueueDeclare("products", true, false, false, false, nil)
+	if err != nil {
+		log.Fatalf("Failed to declare the products queue")
+	}
+
 	log.Println("Successfully connected to RabbitMQ and declared queue")
 }
 
@@ -71,6 +230,9 @@ func main() {
 
 	log.Println("SIREN - Service for Instant Relay of Emergency Notifications")
 
+	shutdownTracing := initTracing("noaa-service")
+	defer shutdownTracing(context.Background())
+
 	// Connect to message queue
 	log.Println("Starting connection to message queue...")
 	connectToMQ()
@@ -78,6 +240,20 @@ func main() {
 	defer conn.Close()
 	defer ch.Close()
 
+	// This server is used to expose the metrics to Prometheus
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		port, ok := os.LookupEnv("METRICS_PORT")
+		if !ok {
+			port = "6902"
+		}
+
+		log.Printf("Starting Prometheus metrics server on %s", port)
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", port), nil); err != nil {
+			log.Fatalf("Failed to start Prometheus metrics server: %v", err)
+		}
+	}()
+
 	log.Println("Starting connection to NWWS ingress server...")
 
 	user := os.Getenv("NWWS_USER")
@@ -93,23 +269,111 @@ func main() {
 		log.Fatal("NWWS_NICKNAME environment variable not set")
 	}
 
+	// NWWS_PING_INTERVAL and NWWS_STALL_TIMEOUT tune the keepalive, NWWS sends several
+	// messages a minute so a few minutes of silence means the connection is dead
+	keepalive := keepaliveConfig{
+		pingInterval: durationFromEnv("NWWS_PING_INTERVAL", time.Minute),
+		stallTimeout: durationFromEnv("NWWS_STALL_TIMEOUT", 3*time.Minute),
+	}
+
+	// NWWS_DEDUP_TTL is how long a CAP identifier is remembered. It needs to cover the gap
+	// between redundant sessions and the history replayed when a session rejoins the room.
+	dedup := newDeduplicator(durationFromEnv("NWWS_DEDUP_TTL", 2*time.Hour))
+
+	// Alert parsing channel and Goroutine
+	alerts := make(chan alertMessage)
+	go handleAlertXML(alerts, dedup)
+
+	// Text product channel and Goroutine, NWWS_PRODUCTS lists the AWIPS categories to publish
+	for _, category := range listFromEnv("NWWS_PRODUCTS", "LSR,SPS,PNS,AFD,SEL,WWP,WOU,SAW,SWO") {
+		wantedProducts[strings.ToUpper(category)] = true
+	}
+	products := make(chan productMessage)
+	go handleProductText(products, dedup)
+
+	sessions := nwwsSessionsFromEnv(user, password, nickname)
+	for _, session := range sessions {
+		go runSession(session, alerts, products, keepalive)
+	}
+	log.Printf("Started %d NWWS session(s)", len(sessions))
+
+	select {}
+}
+
+// One connection to NWWS. Redundant sessions log in with their own resource and join
+// the room under their own nickname, NWWS drops a session that reuses either.
+type nwwsSession struct {
+	name     string
+	host     string
+	user     string
+	password string
+	resource string
+	nickname string
+}
+
+// NWWS_SERVERS and NWWS_RESOURCES are comma separated lists, one session is started for
+// each entry of the longer list. The shorter list repeats its last entry, so two sessions
+// to the same server only need two resources.
+func nwwsSessionsFromEnv(user string, password string, nickname string) []nwwsSession {
+	servers := listFromEnv("NWWS_SERVERS", "nwws-oi.weather.gov")
+	resources := listFromEnv("NWWS_RESOURCES", "nwws")
+	count := max(len(servers), len(resources))
+
+	sessions := make([]nwwsSession, count)
+	for i := range sessions {
+		server := servers[min(i, len(servers)-1)]
+		resource := resources[min(i, len(resources)-1)]
+		if i >= len(resources) {
+			resource = fmt.Sprintf("%s-%d", resource, i+1)
+		}
+
+		sessionNickname := nickname
+		if count > 1 {
+			sessionNickname = fmt.Sprintf("%s-%d", nickname, i+1)
+		}
+
+		sessions[i] = nwwsSession{
+			name:     fmt.Sprintf("%s/%s", server, resource),
+			host:     server,
+			user:     user,
+			password: password,
+			resource: resource,
+			nickname: sessionNickname,
+		}
+	}
+	return sessions
+}
+
+func listFromEnv(key string, fallback string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return []string{fallback}
+	}
+	return list
+}
+
+// Keeps one session connected, reconnecting with backoff whenever it drops
+func runSession(session nwwsSession, alerts chan alertMessage, products chan productMessage, keepalive keepaliveConfig) {
 	// Reconnection Parameters, see https://en.wikipedia.org/wiki/Exponential_backoff
 	minBackoff := 10 * time.Second
 	maxBackoff := 5 * time.Minute
 	backoff := minBackoff
-
-	// Alert parsing channel and Goroutine
-	alerts := make(chan string)
-	go handleAlertXML(alerts)
+	// A session that stays up this long counts as healthy and resets the backoff
+	healthySession := time.Minute
 
 	// Main loop for XMPP connection
 	for {
-		log.Println("Attempting NWWS connection...")
+		log.Printf("[%s] Attempting NWWS connection...", session.name)
 		options := xmpp.Options{
-			Host:          "nwws-oi.weather.gov:5222",
-			User:          fmt.Sprintf("%s@nwws-oi.weather.gov", user),
-			Password:      password,
-			Resource:      "nwws",
+			Host:          fmt.Sprintf("%s:5222", session.host),
+			User:          fmt.Sprintf("%s@nwws-oi.weather.gov", session.user),
+			Password:      session.password,
+			Resource:      session.resource,
 			NoTLS:         true,
 			StartTLS:      true,
 			Debug:         false,
@@ -117,40 +381,59 @@ func main() {
 			Status:        "chat",
 			StatusMessage: "",
 			TLSConfig: &tls.Config{
-				ServerName: "nwws-oi.weather.gov",
+				ServerName: session.host,
 			},
 		}
 
 		client, err := options.NewClient()
 		if err != nil {
-			log.Printf("\nError creating XMPP client (NWWS may be offline): %v", err)
+			log.Printf("\n[%s] Error creating XMPP client (NWWS may be offline): %v", session.name, err)
 		} else {
-			log.Println("Logged into NWWS XMPP client")
+			log.Printf("[%s] Logged into NWWS XMPP client", session.name)
 
-			// Join the NWWS chatroom and get the last 50 messages
-			// TODO: We'll probably want a way to reconcile the last 50 messages so we don't resend alerts. The REDIS cache is a good place to start.
-			_, err = client.JoinMUC("NWWS@conference.nwws-oi.weather.gov", nickname, xmpp.StanzaHistory, 50, nil)
+			// Join the NWWS chatroom and get the last 50 messages. Alerts in the history that
+			// were already published are dropped by the deduplicator.
+			_, err = client.JoinMUC("NWWS@conference.nwws-oi.weather.gov", session.nickname, xmpp.StanzaHistory, 50, nil)
 			if err != nil {
-				log.Printf("\nFailed to join NWWS chatroom: %v", err)
+				log.Printf("\n[%s] Failed to join NWWS chatroom: %v", session.name, err)
 			} else {
-				log.Println("Joined NWWS chatroom")
+				log.Printf("[%s] Joined NWWS chatroom", session.name)
+				nwwsConnected.WithLabelValues(session.name).Set(1)
+				joined := time.Now()
 
-				err = processChatroomMessages(client, alerts)
+				err = processChatroomMessages(client, alerts, products, keepalive, session.name)
 				if err != nil {
-					log.Printf("\nClient disconnected with error: %v", err)
+					log.Printf("\n[%s] Client disconnected with error: %v", session.name, err)
+				}
+				nwwsConnected.WithLabelValues(session.name).Set(0)
+
+				if time.Since(joined) >= healthySession {
+					backoff = minBackoff
 				}
 			}
 
+			// Nothing is receiving any more, the read deadline that stopped the receiver also
+			// makes Close drop the socket right after ending the stream
 			client.Close()
 		}
 
 		// Ensure backoff is handled correctly
-		log.Printf("\nDisconnected. Reconnecting in %v...", backoff)
-		time.Sleep(backoff)
+		wait := jitter(backoff)
+		log.Printf("\n[%s] Disconnected. Reconnecting in %v...", session.name, wait)
+		nwwsBackoff.WithLabelValues(session.name).Set(wait.Seconds())
+		time.Sleep(wait)
+		nwwsReconnects.WithLabelValues(session.name).Inc()
 		backoff = increaseBackoff(backoff, maxBackoff)
 	}
 }
 
+// Spreads reconnects over the upper half of the backoff so instances that dropped
+// together don't all reconnect at the same moment
+func jitter(backoff time.Duration) time.Duration {
+	half := backoff / 2
+	return half + rand.N(half+1)
+}
+
 func increaseBackoff(backoff, maxBackoff time.Duration) time.Duration {
 	if backoff < maxBackoff {
 		backoff *= 2
@@ -162,89 +445,295 @@ func increaseBackoff(backoff, maxBackoff time.Duration) time.Duration {
 	return maxBackoff
 }
 
-func processChatroomMessages(client *xmpp.Client, alerts chan string) error {
+// Reads the chatroom until the connection fails or stalls. Recv blocks until a stanza
+// arrives, so it runs on its own goroutine while this one pings the server and watches
+// for silence. A half-open connection never returns an error, the watchdog is what notices.
+func processChatroomMessages(client *xmpp.Client, alerts chan alertMessage, products chan productMessage, keepalive keepaliveConfig, sessionName string) error {
+	stanzas := make(chan any)
+	recvErr := make(chan error, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		receiveStanzas(client, stanzas, recvErr, done)
+	}()
+	// The client isn't safe to use from two goroutines, so the receiver has to be gone before
+	// the caller closes it. A read deadline in the past is the only way to interrupt Recv.
+	defer func() {
+		close(done)
+		client.SetReadDeadline(time.Now())
+		<-stopped
+	}()
+
+	watchdog := time.NewTimer(keepalive.stallTimeout)
+	defer watchdog.Stop()
+	ping := time.NewTicker(keepalive.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case stanza := <-stanzas:
+			handleStanza(stanza, alerts, products, sessionName)
+			// Reset after handling, so a slow publish isn't mistaken for a silent server
+			watchdog.Reset(keepalive.stallTimeout)
+		case err := <-recvErr:
+			return err
+		case <-ping.C:
+			// The server answers with an IQ result, which resets the watchdog like any stanza
+			if err := client.PingC2S("", ""); err != nil {
+				return fmt.Errorf("failed to ping NWWS: %w", err)
+			}
+		case <-watchdog.C:
+			nwwsStalls.WithLabelValues(sessionName).Inc()
+			return fmt.Errorf("no stanza received from NWWS in %v", keepalive.stallTimeout)
+		}
+	}
+}
+
+func receiveStanzas(client *xmpp.Client, stanzas chan<- any, recvErr chan<- error, done <-chan struct{}) {
 	for {
 		stanza, err := client.Recv()
 		if err != nil {
+			select {
+			case <-done:
+				// The session was abandoned and the client closed under us
+				return
+			default:
+			}
+
 			if errors.Is(err, io.EOF) ||
 				strings.Contains(err.Error(), "unexpected EOF") {
-				return err
+				recvErr <- err
+				return
 			}
 
 			log.Printf("Error receiving XMPP stanza: %v", err)
 			time.Sleep(500 * time.Millisecond)
 			continue
 		}
-		switch v := stanza.(type) {
-		// There is a lot of XMPP stanza parsing here, but the important part is that we are looking for CAP alerts
-		case xmpp.Chat:
-			if v.Type == "groupchat" {
-				// Find the special <x> element with the CAP alert
-				for _, child := range v.OtherElem {
-					if child.XMLName.Local == "x" {
-						awipsID := ""
-						// Look for the awipsid attribute
-						for _, attr := range child.Attr {
-							if attr.Name.Local == "awipsid" {
-								awipsID = attr.Value
-								break
-							}
+		lastMessageTime.Store(time.Now().Unix())
+
+		select {
+		case stanzas <- stanza:
+		case <-done:
+			return
+		}
+	}
+}
+
+func handleStanza(stanza any, alerts chan alertMessage, products chan productMessage, sessionName string) {
+	switch v := stanza.(type) {
+	// There is a lot of XMPP stanza parsing here, but the important part is that we are looking for CAP alerts
+	case xmpp.Chat:
+		stanzasReceived.WithLabelValues("chat").Inc()
+		if v.Type == "groupchat" {
+			// Find the special <x> element with the CAP alert
+			for _, child := range v.OtherElem {
+				if child.XMLName.Local == "x" {
+					var awipsID, messageID, issue string
+					// Look for the awipsid attribute, along with the message id and issue time
+					for _, attr := range child.Attr {
+						switch attr.Name.Local {
+						case "awipsid":
+							awipsID = attr.Value
+						case "id":
+							messageID = attr.Value
+						case "issue":
+							issue = attr.Value
+						}
+					}
+					// If the awipsid attribute is present, and it starts with "CAP", then we have a CAP alert
+					if strings.HasPrefix(awipsID, "CAP") {
+						// The trace covers the alert from here until it is published for tracking
+						ctx, span := tracer.Start(context.Background(), "nwws.receive",
+							trace.WithSpanKind(trace.SpanKindConsumer),
+							trace.WithAttributes(
+								attribute.String("nwws.awipsid", awipsID),
+								attribute.String("nwws.session", sessionName),
+							))
+						// Grab the content of the <x> element
+						content := child.InnerXML
+						// Find the <alert> element within the content
+						matches := capAlertRE.FindStringSubmatch(content)
+						if len(matches) < 2 {
+							log.Println("No <alert> element found in content.")
+							capRegexMisses.Inc()
+							span.SetStatus(codes.Error, "no alert element")
+							span.End()
+							continue
 						}
-						// If the awipsid attribute is present, and it starts with "CAP", then we have a CAP alert
-						if strings.HasPrefix(awipsID, "CAP") {
-							// Grab the content of the <x> element
-							content := child.InnerXML
-							// Find the <alert> element within the content
-							matches := capAlertRE.FindStringSubmatch(content)
-							if len(matches) < 2 {
-								log.Println("No <alert> element found in content.")
-								continue
-							}
-							// Send the alert to the alerts channel
-							alerts <- matches[1]
+						capExtracted.Inc()
+						lastCAPTime.SetToCurrentTime()
+						// Send the alert to the alerts channel
+						alerts <- alertMessage{ctx: ctx, span: span, xml: matches[1]}
+					} else if len(awipsID) >= 3 && wantedProducts[awipsID[:3]] {
+						ctx, span := tracer.Start(context.Background(), "nwws.receive",
+							trace.WithSpanKind(trace.SpanKindConsumer),
+							trace.WithAttributes(
+								attribute.String("nwws.awipsid", awipsID),
+								attribute.String("nwws.session", sessionName),
+							))
+						issued, _ := time.Parse(time.RFC3339, issue)
+						// Text products are escaped rather than wrapped in CDATA
+						products <- productMessage{
+							ctx:       ctx,
+							span:      span,
+							messageID: messageID,
+							awipsID:   awipsID,
+							issued:    issued,
+							text:      html.UnescapeString(child.InnerXML),
 						}
 					}
 				}
 			}
-		case xmpp.Presence:
-			continue
-		case xmpp.IQ:
-			continue
-		default:
-			continue
 		}
+	case xmpp.Presence:
+		stanzasReceived.WithLabelValues("presence").Inc()
+	case xmpp.IQ:
+		stanzasReceived.WithLabelValues("iq").Inc()
+	default:
+		stanzasReceived.WithLabelValues("other").Inc()
 	}
 }
 
-func handleAlertXML(alerts <-chan string) {
-	for alertXML := range alerts {
-		var alert CAP.AlertXML
-		err := xml.Unmarshal([]byte(alertXML), &alert)
-		if err != nil {
-			log.Printf("Failed to unmarshal alert: %v\n", err)
-			continue
-		}
+func handleAlertXML(alerts <-chan alertMessage, dedup *deduplicator) {
+	for msg := range alerts {
+		publishAlert(msg.ctx, msg.xml, dedup)
+		msg.span.End()
+	}
+}
 
-		alertJson, err := CAP.ConvertXMLToJsonStruct(&alert)
-		if err != nil {
-			log.Printf("Failed to convert alert to JSON struct: %v\n", err)
-			continue
-		}
+func publishAlert(ctx context.Context, alertXML string, dedup *deduplicator) {
+	span := trace.SpanFromContext(ctx)
 
-		alertJsonBytes, err := msgpack.Marshal(alertJson)
-		if err != nil {
-			log.Printf("Failed to convert alert to JSON: %v\n", err)
-			continue
-		}
+	_, parseSpan := tracer.Start(ctx, "cap.parse")
+	var alert CAP.AlertXML
+	err := xml.Unmarshal([]byte(alertXML), &alert)
+	parseSpan.End()
+	if err != nil {
+		log.Printf("Failed to unmarshal alert: %v\n", err)
+		capFailures.WithLabelValues("unmarshal").Inc()
+		span.SetStatus(codes.Error, "failed to unmarshal alert")
+		return
+	}
+	span.SetAttributes(attribute.String("cap.identifier", alert.Identifier))
 
-		//Marshall to JSON and Send alert to message queue
-		err = ch.Publish("", trackingQueue.Name, false, false, ampq.Publishing{
-			ContentType: "application/msgpack",
-			Body:        alertJsonBytes,
-		})
-		if err != nil {
-			log.Printf("Failed to publish alert to message queue: %v\n", err)
-			continue
+	// Every session receives every alert, only the first copy is published
+	if !dedup.firstSighting(alert.Identifier) {
+		capDuplicates.Inc()
+		span.SetAttributes(attribute.Bool("cap.duplicate", true))
+		return
+	}
+
+	_, convertSpan := tracer.Start(ctx, "cap.convert")
+	alertJson, err := CAP.ConvertXMLToJsonStruct(&alert)
+	if err != nil {
+		convertSpan.End()
+		log.Printf("Failed to convert alert to JSON struct: %v\n", err)
+		capFailures.WithLabelValues("convert").Inc()
+		span.SetStatus(codes.Error, "failed to convert alert")
+		return
+	}
+
+	alertJsonBytes, err := msgpack.Marshal(alertJson)
+	convertSpan.End()
+	if err != nil {
+		log.Printf("Failed to convert alert to JSON: %v\n", err)
+		capFailures.WithLabelValues("encode").Inc()
+		span.SetStatus(codes.Error, "failed to encode alert")
+		return
+	}
+
+	publishCtx, publishSpan := tracer.Start(ctx, "amqp.publish tracking", trace.WithSpanKind(trace.SpanKindProducer))
+	defer publishSpan.End()
+
+	// The trace context goes along in the headers so tracking can continue it
+	headers := ampq.Table{}
+	otel.GetTextMapPropagator().Inject(publishCtx, amqpHeaderCarrier(headers))
+
+	//Marshall to JSON and Send alert to message queue
+	err = ch.Publish("", trackingQueue.Name, false, false, ampq.Publishing{
+		ContentType: "application/msgpack",
+		// Consumers use this to drop copies published by another noaa-service instance
+		MessageId: alert.Identifier,
+		Headers:   headers,
+		Body:      alertJsonBytes,
+	})
+	if err != nil {
+		log.Printf("Failed to publish alert to message queue: %v\n", err)
+		publishFailures.Inc()
+		publishSpan.SetStatus(codes.Error, err.Error())
+		// Let another session's copy through once the queue is back
+		dedup.forget(alert.Identifier)
+		span.SetStatus(codes.Error, "failed to publish alert")
+		return
+	}
+	alertsPublished.Inc()
+}
+
+func handleProductText(products <-chan productMessage, dedup *deduplicator) {
+	for msg := range products {
+		publishProduct(msg, dedup)
+		msg.span.End()
+	}
+}
+
+func publishProduct(msg productMessage, dedup *deduplicator) {
+	span := msg.span
+
+	_, parseSpan := tracer.Start(msg.ctx, "product.parse")
+	product, err := Product.ParseProduct(msg.messageID, msg.awipsID, msg.text, msg.issued)
+	if err == nil {
+		switch product.Category {
+		case "LSR":
+			product.LSRs = Product.ParseLSRs(product.Text)
+		case "SEL", "WWP", "WOU", "SAW":
+			product.Watch = Product.ParseWatch(product)
+		case "SWO":
+			product.MCD = Product.ParseMesoscaleDiscussion(product)
 		}
 	}
+	parseSpan.End()
+	if err != nil {
+		log.Printf("Failed to parse product %s: %v\n", msg.awipsID, err)
+		productFailures.WithLabelValues("parse").Inc()
+		span.SetStatus(codes.Error, "failed to parse product")
+		return
+	}
+
+	key := product.Key()
+	if !dedup.firstSighting(key) {
+		span.SetAttributes(attribute.Bool("product.duplicate", true))
+		return
+	}
+
+	productBytes, err := msgpack.Marshal(product)
+	if err != nil {
+		log.Printf("Failed to encode product %s: %v\n", key, err)
+		productFailures.WithLabelValues("encode").Inc()
+		span.SetStatus(codes.Error, "failed to encode product")
+		return
+	}
+
+	publishCtx, publishSpan := tracer.Start(msg.ctx, "amqp.publish products", trace.WithSpanKind(trace.SpanKindProducer))
+	defer publishSpan.End()
+
+	headers := ampq.Table{}
+	otel.GetTextMapPropagator().Inject(publishCtx, amqpHeaderCarrier(headers))
+
+	err = ch.Publish("", productsQueue.Name, false, false, ampq.Publishing{
+		ContentType: "application/msgpack",
+		MessageId:   key,
+		Type:        product.Category,
+		Headers:     headers,
+		Body:        productBytes,
+	})
+	if err != nil {
+		log.Printf("Failed to publish product to message queue: %v\n", err)
+		productFailures.WithLabelValues("publish").Inc()
+		publishSpan.SetStatus(codes.Error, err.Error())
+		dedup.forget(key)
+		return
+	}
+	productsPublished.WithLabelValues(product.Category).Inc()
 }
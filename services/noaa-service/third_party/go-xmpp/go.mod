@@ -0,0 +1,8 @@
+module github.com/xmppo/go-xmpp
+
+go 1.21.5
+
+require (
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.34.0
+)
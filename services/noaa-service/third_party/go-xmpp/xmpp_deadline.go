@@ -0,0 +1,15 @@
+package xmpp
+
+import (
+	"errors"
+	"time"
+)
+
+// SetReadDeadline sets the read deadline on the connection to the server. A deadline in
+// the past makes a blocked Recv return, which is the only way to interrupt it.
+func (c *Client) SetReadDeadline(t time.Time) error {
+	if c.conn == nil {
+		return errors.New("xmpp: not connected")
+	}
+	return c.conn.SetReadDeadline(t)
+}
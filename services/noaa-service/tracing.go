@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	ampq "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var tracer = otel.Tracer("noaaService")
+
+// Sets up trace export to the collector at OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318.
+// Without an endpoint nothing is exported, but spans still get ids so the trace context
+// passed to the other services links their spans to ours.
+func initTracing(serviceName string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			log.Printf("Failed to create trace exporter, traces will not be exported: %v", err)
+		} else {
+			options = append(options, sdktrace.WithBatcher(exporter))
+			log.Println("Exporting traces to the OpenTelemetry collector")
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// Carries trace context in the headers of AMQP messages
+type amqpHeaderCarrier ampq.Table
+
+func (c amqpHeaderCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c amqpHeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c amqpHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,227 @@
+package Audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"trackingService/NWS"
+	"trackingService/SAME"
+)
+
+// NOAA Weather Radio style messages: the SAME header three times, the 1050 Hz attention tone,
+// the spoken message, then the end of message three times, see NWS Directive 10-1712
+const (
+	SampleRate = 22050
+
+	BaudRate  = 520.83 // SAME bits per second
+	MarkFreq  = 2083.3 // Hz for a 1 bit
+	SpaceFreq = 1562.5 // Hz for a 0 bit
+	ToneFreq  = 1050.0 // NWS attention tone
+	Amplitude = 0.6    // Of full scale, leaves headroom for encoders
+	preamble  = 0xAB   // Sent 16 times before every burst to sync receivers
+	bursts    = 3      // Every header and end of message is sent three times
+	pause     = 1 * time.Second
+)
+
+// Attention tone length when the message doesn't set one, NWR transmits between 8 and 10 seconds
+const DefaultAttentionTone = 8 * time.Second
+
+var ErrBlocked = errors.New("NOAA Weather Radio is blocked for this alert")
+
+// Speaker turns the message text into audio, a real one calls out to a TTS engine
+type Speaker interface {
+	Speak(ctx context.Context, text string, language string, sampleRate int) ([]int16, error)
+}
+
+// PlaceholderSpeaker stands in for a TTS engine, filling the message slot for as long as the
+// text would take to read. With a Tone it plays a quiet tone instead of silence.
+type PlaceholderSpeaker struct {
+	Tone float64 // Hz, 0 for silence
+}
+
+// Roughly how long a word takes at the pace NWR voices read
+const wordDuration = 400 * time.Millisecond
+
+// The slot never grows past this, long descriptions would make test files huge
+const maxPlaceholder = 2 * time.Minute
+
+func (s PlaceholderSpeaker) Speak(ctx context.Context, text string, language string, sampleRate int) ([]int16, error) {
+	duration := min(time.Duration(len(strings.Fields(text)))*wordDuration, maxPlaceholder)
+	if s.Tone <= 0 {
+		return silence(duration, sampleRate), nil
+	}
+	var osc oscillator
+	return osc.tone(s.Tone, duration, sampleRate, Amplitude/4), nil
+}
+
+// Message is everything that goes into one broadcast
+type Message struct {
+	Header   string // Encoded SAME header
+	Text     string // What the speaker reads
+	Language string
+	// Length of the attention tone, DefaultAttentionTone when 0
+	AttentionTone time.Duration
+}
+
+// MessagesFromAlert builds the broadcasts for a CAP alert, unless NWEM is blocked for it.
+// There is one for each SAME header, an alert for many locations takes more than one.
+func MessagesFromAlert(alert NWS.Alert) ([]Message, error) {
+	if params := alert.Info.Parameters; params != nil && params.BlockChannels.NWEM {
+		return nil, ErrBlocked
+	}
+	headers, err := SAME.FromAlert(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	var text []string
+	for _, part := range []string{alert.Info.Headline, alert.Info.Description, alert.Info.Instruction} {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			text = append(text, part)
+		}
+	}
+	messages := make([]Message, 0, len(headers))
+	for _, header := range headers {
+		messages = append(messages, Message{
+			Header:   header.String(),
+			Text:     strings.Join(text, " "),
+			Language: alert.Info.Language,
+		})
+	}
+	return messages, nil
+}
+
+// Synthesize renders the message as 16 bit mono samples at SampleRate
+func Synthesize(ctx context.Context, msg Message, speaker Speaker) ([]int16, error) {
+	var osc oscillator
+	var samples []int16
+
+	for range bursts {
+		samples = append(samples, osc.afsk(burst(msg.Header), SampleRate)...)
+		samples = append(samples, silence(pause, SampleRate)...)
+	}
+
+	attention := msg.AttentionTone
+	if attention <= 0 {
+		attention = DefaultAttentionTone
+	}
+	samples = append(samples, osc.tone(ToneFreq, attention, SampleRate, Amplitude)...)
+	samples = append(samples, silence(pause, SampleRate)...)
+
+	if speaker != nil && msg.Text != "" {
+		speech, err := speaker.Speak(ctx, msg.Text, msg.Language, SampleRate)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, speech...)
+		samples = append(samples, silence(pause, SampleRate)...)
+	}
+
+	for range bursts {
+		samples = append(samples, osc.afsk(burst(SAME.EndOfMessage), SampleRate)...)
+		samples = append(samples, silence(pause, SampleRate)...)
+	}
+	return samples, nil
+}
+
+// A burst is the preamble followed by the ASCII text
+func burst(text string) []byte {
+	data := make([]byte, 0, 16+len(text))
+	for range 16 {
+		data = append(data, preamble)
+	}
+	return append(data, text...)
+}
+
+/* ---- Tone Generation ---- */
+
+// oscillator keeps its phase between calls so the audio has no clicks where parts meet
+type oscillator struct {
+	phase float64
+}
+
+func (o *oscillator) next(freq float64, sampleRate int, amplitude float64) int16 {
+	sample := math.Sin(o.phase) * amplitude * math.MaxInt16
+	o.phase = math.Mod(o.phase+2*math.Pi*freq/float64(sampleRate), 2*math.Pi)
+	return int16(sample)
+}
+
+func (o *oscillator) tone(freq float64, duration time.Duration, sampleRate int, amplitude float64) []int16 {
+	n := int(duration.Seconds() * float64(sampleRate))
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = o.next(freq, sampleRate, amplitude)
+	}
+	return samples
+}
+
+// afsk modulates the bytes least significant bit first, with no start or stop bits.
+// Bit boundaries fall between samples, so each bit ends where its exact time does.
+func (o *oscillator) afsk(data []byte, sampleRate int) []int16 {
+	samplesPerBit := float64(sampleRate) / BaudRate
+	samples := make([]int16, 0, int(float64(len(data)*8)*samplesPerBit)+1)
+
+	bit := 0
+	for _, b := range data {
+		for i := range 8 {
+			freq := SpaceFreq
+			if b>>i&1 == 1 {
+				freq = MarkFreq
+			}
+			bit++
+			end := int(math.Round(float64(bit) * samplesPerBit))
+			for len(samples) < end {
+				samples = append(samples, o.next(freq, sampleRate, Amplitude))
+			}
+		}
+	}
+	return samples
+}
+
+func silence(duration time.Duration, sampleRate int) []int16 {
+	return make([]int16, int(duration.Seconds()*float64(sampleRate)))
+}
+
+/* ---- WAV ---- */
+
+// WriteWAV writes 16 bit mono PCM samples as a WAV file
+func WriteWAV(w io.Writer, samples []int16, sampleRate int) error {
+	dataSize := uint32(len(samples) * 2)
+	header := struct {
+		ChunkID       [4]byte
+		ChunkSize     uint32
+		Format        [4]byte
+		Subchunk1ID   [4]byte
+		Subchunk1Size uint32
+		AudioFormat   uint16
+		NumChannels   uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Subchunk2ID   [4]byte
+		Subchunk2Size uint32
+	}{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + dataSize,
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		Subchunk1Size: 16,
+		AudioFormat:   1, // PCM
+		NumChannels:   1,
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * 2),
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
+		Subchunk2Size: dataSize,
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, samples)
+}
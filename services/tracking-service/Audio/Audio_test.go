@@ -0,0 +1,257 @@
+package Audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+	"trackingService/NWS"
+	"trackingService/SAME"
+)
+
+const header = "ZCZC-WXR-TOR-019169+0045-1662315-KDMX/NWS-"
+
+// Signal power at freq, the Goertzel algorithm
+func power(samples []int16, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/SampleRate)
+	var s1, s2 float64
+	for _, sample := range samples {
+		s0 := float64(sample) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+// Reads the bytes back out of AFSK samples, least significant bit first
+func demodulate(samples []int16) []byte {
+	samplesPerBit := SampleRate / BaudRate
+	bits := int(math.Round(float64(len(samples)) / samplesPerBit))
+	data := make([]byte, bits/8)
+	for bit := range bits {
+		start := int(math.Round(float64(bit) * samplesPerBit))
+		end := min(int(math.Round(float64(bit+1)*samplesPerBit)), len(samples))
+		if power(samples[start:end], MarkFreq) > power(samples[start:end], SpaceFreq) {
+			data[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return data
+}
+
+func afskLength(bytes int) int {
+	return int(math.Round(float64(bytes*8) * SampleRate / BaudRate))
+}
+
+func seconds(d time.Duration) int {
+	return int(d.Seconds() * SampleRate)
+}
+
+func TestAFSK(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"preamble", []byte{preamble}},
+		{"one bit set", []byte{0x01}},
+		{"high bit set", []byte{0x80}},
+		{"header burst", burst(header)},
+		{"end of message burst", burst(SAME.EndOfMessage)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var osc oscillator
+			samples := osc.afsk(tt.data, SampleRate)
+			// 520.83 baud at 22050 Hz is 42.34 samples a bit, the boundaries can't drift
+			if len(samples) != afskLength(len(tt.data)) {
+				t.Errorf("got %d samples, want %d", len(samples), afskLength(len(tt.data)))
+			}
+			if got := demodulate(samples); !bytes.Equal(got, tt.data) {
+				t.Errorf("demodulated %x, want %x", got, tt.data)
+			}
+		})
+	}
+
+	t.Run("bit order", func(t *testing.T) {
+		var osc oscillator
+		samples := osc.afsk([]byte{0x01}, SampleRate)
+		bit := int(math.Floor(SampleRate / BaudRate))
+		first, last := samples[:bit], samples[len(samples)-bit:]
+		// The least significant bit goes first, so 0x01 starts with the mark and ends with the space
+		if power(first, MarkFreq) <= power(first, SpaceFreq) || power(last, SpaceFreq) <= power(last, MarkFreq) {
+			t.Error("0x01 wasn't sent least significant bit first")
+		}
+	})
+}
+
+func TestSynthesizeLayout(t *testing.T) {
+	words := "Take shelter now in a basement or an interior room"
+	speech := seconds(10 * wordDuration)
+	headerBurst := afskLength(16 + len(header))
+	eomBurst := afskLength(16 + len(SAME.EndOfMessage))
+
+	tests := []struct {
+		name      string
+		msg       Message
+		attention time.Duration
+	}{
+		{"default attention tone", Message{Header: header, Text: words}, DefaultAttentionTone},
+		{"longer attention tone", Message{Header: header, Text: words, AttentionTone: 10 * time.Second}, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := Synthesize(context.Background(), tt.msg, PlaceholderSpeaker{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Each part in order, with whether it is silent
+			type part struct {
+				name   string
+				length int
+				silent bool
+			}
+			var parts []part
+			for range bursts {
+				parts = append(parts, part{"header", headerBurst, false}, part{"pause", seconds(pause), true})
+			}
+			parts = append(parts, part{"attention tone", seconds(tt.attention), false}, part{"pause", seconds(pause), true})
+			parts = append(parts, part{"message", speech, true}, part{"pause", seconds(pause), true})
+			for range bursts {
+				parts = append(parts, part{"end of message", eomBurst, false}, part{"pause", seconds(pause), true})
+			}
+
+			total := 0
+			for _, p := range parts {
+				total += p.length
+			}
+			if len(samples) != total {
+				t.Fatalf("got %d samples, want %d", len(samples), total)
+			}
+
+			offset := 0
+			for i, p := range parts {
+				segment := samples[offset : offset+p.length]
+				offset += p.length
+				silent := !slices.ContainsFunc(segment, func(s int16) bool { return s != 0 })
+				if silent != p.silent {
+					t.Errorf("part %d, the %s, silent %v", i, p.name, silent)
+					continue
+				}
+				switch p.name {
+				case "header":
+					if got := demodulate(segment); !bytes.Equal(got, burst(header)) {
+						t.Errorf("part %d decodes as %q", i, got)
+					}
+				case "end of message":
+					if got := demodulate(segment); !bytes.Equal(got, burst(SAME.EndOfMessage)) {
+						t.Errorf("part %d decodes as %q", i, got)
+					}
+				case "attention tone":
+					if power(segment, ToneFreq) < 100*power(segment, MarkFreq) {
+						t.Error("the attention tone isn't at 1050 Hz")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, math.MaxInt16, math.MinInt16, 12345}
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, samples, SampleRate); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 44+2*len(samples) {
+		t.Fatalf("wrote %d bytes, want a 44 byte header and %d bytes of samples", buf.Len(), 2*len(samples))
+	}
+
+	var wav struct {
+		ChunkID       [4]byte
+		ChunkSize     uint32
+		Format        [4]byte
+		Subchunk1ID   [4]byte
+		Subchunk1Size uint32
+		AudioFormat   uint16
+		NumChannels   uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Subchunk2ID   [4]byte
+		Subchunk2Size uint32
+	}
+	reader := bytes.NewReader(buf.Bytes())
+	if err := binary.Read(reader, binary.LittleEndian, &wav); err != nil {
+		t.Fatal(err)
+	}
+	if string(wav.ChunkID[:]) != "RIFF" || string(wav.Format[:]) != "WAVE" || string(wav.Subchunk1ID[:]) != "fmt " || string(wav.Subchunk2ID[:]) != "data" {
+		t.Errorf("chunk ids %q %q %q %q", wav.ChunkID, wav.Format, wav.Subchunk1ID, wav.Subchunk2ID)
+	}
+	if wav.ChunkSize != uint32(buf.Len()-8) || wav.Subchunk1Size != 16 || wav.Subchunk2Size != uint32(2*len(samples)) {
+		t.Errorf("chunk sizes %d %d %d", wav.ChunkSize, wav.Subchunk1Size, wav.Subchunk2Size)
+	}
+	if wav.AudioFormat != 1 || wav.NumChannels != 1 || wav.SampleRate != SampleRate || wav.ByteRate != 2*SampleRate || wav.BlockAlign != 2 || wav.BitsPerSample != 16 {
+		t.Errorf("format %+v, want 16 bit mono PCM at %d Hz", wav, SampleRate)
+	}
+
+	read := make([]int16, len(samples))
+	if err := binary.Read(reader, binary.LittleEndian, read); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(read, samples) {
+		t.Errorf("samples read back as %v, want %v", read, samples)
+	}
+}
+
+func TestMessagesFromAlert(t *testing.T) {
+	sent := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	var locations []string
+	for i := range SAME.MaxLocations + 9 {
+		locations = append(locations, fmt.Sprintf("048%03d", 2*i+1))
+	}
+	alert := NWS.Alert{
+		Sent: sent,
+		Info: NWS.Info{
+			Headline:   "Flash Flood Warning issued June 15 at 6:10PM CDT",
+			EventCode:  NWS.EventCode{SAME: "FFW", NWS: "FFW"},
+			Expires:    sent.Add(3 * time.Hour),
+			Language:   "en-US",
+			Parameters: &NWS.Parameters{WMOidentifier: "WGUS54 KFWD 152310"},
+			Area:       NWS.Area{Geocodes: NWS.Geocodes{SAME: locations}},
+		},
+	}
+
+	messages, err := MessagesFromAlert(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every location is broadcast, in as many messages as it takes
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	var broadcast []string
+	for _, msg := range messages {
+		header, err := SAME.Decode(msg.Header, sent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		broadcast = append(broadcast, header.Locations...)
+		if msg.Text != alert.Info.Headline || msg.Language != "en-US" {
+			t.Errorf("message %+v, want the headline in en-US", msg)
+		}
+	}
+	if !slices.Equal(broadcast, locations) {
+		t.Errorf("broadcast %v, want %v", broadcast, locations)
+	}
+
+	alert.Info.Parameters.BlockChannels.NWEM = true
+	if _, err := MessagesFromAlert(alert); err != ErrBlocked {
+		t.Errorf("err = %v, want %v", err, ErrBlocked)
+	}
+}
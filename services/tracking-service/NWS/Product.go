@@ -0,0 +1,108 @@
+package NWS
+
+import (
+	"fmt"
+	"time"
+)
+
+// A raw NWWS text product published by noaa-service, see noaaService/Product
+type Product struct {
+	ID       string               `msgpack:"id"`
+	AWIPSID  string               `msgpack:"awipsId"`
+	Category string               `msgpack:"category"`
+	Office   string               `msgpack:"office"`
+	WMO      WMOHeader            `msgpack:"wmo"`
+	Issued   time.Time            `msgpack:"issued"`
+	Text     string               `msgpack:"text"`
+	LSRs     []LSR                `msgpack:"lsrs,omitempty"`
+	Watch    *Watch               `msgpack:"watch,omitempty"`
+	MCD      *MesoscaleDiscussion `msgpack:"mcd,omitempty"`
+}
+
+type WMOHeader struct {
+	DataType string    `msgpack:"dataType"`
+	Station  string    `msgpack:"station"`
+	Issued   time.Time `msgpack:"issued"`
+	BBB      string    `msgpack:"bbb,omitempty"`
+}
+
+// A single Local Storm Report
+type LSR struct {
+	Time      time.Time `msgpack:"time"`
+	Event     string    `msgpack:"event"`
+	Magnitude Magnitude `msgpack:"magnitude"`
+	Location  string    `msgpack:"location"`
+	County    string    `msgpack:"county"`
+	State     string    `msgpack:"state"`
+	Lat       float64   `msgpack:"lat"`
+	Lon       float64   `msgpack:"lon"`
+	Source    string    `msgpack:"source"`
+	Remarks   string    `msgpack:"remarks,omitempty"`
+}
+
+type Magnitude struct {
+	Qualifier string  `msgpack:"qualifier,omitempty"`
+	Value     float64 `msgpack:"value,omitempty"`
+	Units     string  `msgpack:"units,omitempty"`
+	Raw       string  `msgpack:"raw,omitempty"`
+}
+
+// What one SPC product says about a watch
+type Watch struct {
+	Number        int                 `msgpack:"number"`
+	Type          string              `msgpack:"type"` // Tornado or Severe Thunderstorm
+	PDS           bool                `msgpack:"pds"`
+	Issued        time.Time           `msgpack:"issued,omitempty"`
+	Expires       time.Time           `msgpack:"expires,omitempty"`
+	VTEC          string              `msgpack:"vtec,omitempty"`
+	UGCs          []string            `msgpack:"ugcs,omitempty"`
+	Polygon       [][2]float64        `msgpack:"polygon,omitempty"`
+	Probabilities *WatchProbabilities `msgpack:"probabilities,omitempty"`
+	Attributes    *WatchAttributes    `msgpack:"attributes,omitempty"`
+}
+
+type Probability struct {
+	Percent     int  `msgpack:"percent"`
+	LessThan    bool `msgpack:"lessThan,omitempty"`
+	GreaterThan bool `msgpack:"greaterThan,omitempty"`
+}
+
+func (p Probability) String() string {
+	if p.LessThan {
+		return fmt.Sprintf("<%d%%", p.Percent)
+	}
+	if p.GreaterThan {
+		return fmt.Sprintf(">%d%%", p.Percent)
+	}
+	return fmt.Sprintf("%d%%", p.Percent)
+}
+
+type WatchProbabilities struct {
+	Tornadoes       Probability `msgpack:"tornadoes"`
+	StrongTornadoes Probability `msgpack:"strongTornadoes"`
+	Wind            Probability `msgpack:"wind"`
+	StrongWind      Probability `msgpack:"strongWind"`
+	Hail            Probability `msgpack:"hail"`
+	LargeHail       Probability `msgpack:"largeHail"`
+	Combined        Probability `msgpack:"combined"`
+}
+
+type WatchAttributes struct {
+	MaxHail          float64 `msgpack:"maxHail"`
+	MaxWindGust      int     `msgpack:"maxWindGust"`
+	MaxTops          int     `msgpack:"maxTops"`
+	StormMotionDir   int     `msgpack:"stormMotionDir"`
+	StormMotionSpeed int     `msgpack:"stormMotionSpeed"`
+}
+
+// An SPC Mesoscale Discussion
+type MesoscaleDiscussion struct {
+	Number           int          `msgpack:"number"`
+	AreasAffected    string       `msgpack:"areasAffected,omitempty"`
+	Concerning       string       `msgpack:"concerning,omitempty"`
+	Watches          []int        `msgpack:"watches,omitempty"`
+	WatchProbability int          `msgpack:"watchProbability,omitempty"`
+	ValidFrom        time.Time    `msgpack:"validFrom,omitempty"`
+	ValidUntil       time.Time    `msgpack:"validUntil,omitempty"`
+	Polygon          [][2]float64 `msgpack:"polygon,omitempty"`
+}
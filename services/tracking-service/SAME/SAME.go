@@ -0,0 +1,282 @@
+package SAME
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"trackingService/NWS"
+)
+
+// Specific Area Message Encoding, the header EAS and NOAA Weather Radio send before an alert,
+// see 47 CFR 11.31 and NWS Directive 10-1712
+//
+//	ZCZC-WXR-TOR-019169-019015+0045-1662315-KDMX/NWS-
+const (
+	Preamble     = "ZCZC"
+	EndOfMessage = "NNNN"
+	MaxLocations = 31
+)
+
+// Originator codes
+const (
+	OriginatorEAS = "EAS" // Broadcast station or cable system
+	OriginatorCIV = "CIV" // Civil authorities
+	OriginatorWXR = "WXR" // National Weather Service
+	OriginatorPEP = "PEP" // Primary Entry Point system
+)
+
+var originators = map[string]bool{
+	OriginatorEAS: true,
+	OriginatorCIV: true,
+	OriginatorWXR: true,
+	OriginatorPEP: true,
+}
+
+var ErrNoEvent = errors.New("alert has no SAME event code")
+var ErrNoLocations = errors.New("alert has no SAME location codes")
+var ErrBlocked = errors.New("EAS is blocked for this alert")
+
+// Header is a decoded SAME header
+type Header struct {
+	Originator string        `msgpack:"originator"`
+	Event      string        `msgpack:"event"`
+	Locations  []string      `msgpack:"locations"` // PSSCCC, part of county, state FIPS and county FIPS
+	Purge      time.Duration `msgpack:"purge"`     // How long the message is valid
+	Issued     time.Time     `msgpack:"issued"`    // UTC, the header only carries the day of year
+	Sender     string        `msgpack:"sender"`    // LLLLLLLL, e.g. KDMX/NWS
+}
+
+// String encodes the header, ending with the dash that closes the sender
+func (h Header) String() string {
+	return fmt.Sprintf("%s-%s-%s-%s+%s-%s-%s-",
+		Preamble,
+		h.Originator,
+		h.Event,
+		strings.Join(h.Locations, "-"),
+		formatPurge(h.Purge),
+		h.Issued.UTC().Format("002")+h.Issued.UTC().Format("1504"),
+		formatSender(h.Sender),
+	)
+}
+
+/* ---- Encoding ---- */
+
+// FromAlert builds the headers for a CAP alert. The originator comes from the EAS-ORG
+// parameter, the sender from the office in the WMO heading. A header holds at most
+// MaxLocations locations, so like NWS an alert for more is sent as several headers.
+func FromAlert(alert NWS.Alert) ([]Header, error) {
+	params := alert.Info.Parameters
+	if params != nil && params.BlockChannels.EAS {
+		return nil, ErrBlocked
+	}
+
+	event := alert.Info.EventCode.SAME
+	if event == "" {
+		return nil, ErrNoEvent
+	}
+
+	var locations []string
+	for _, location := range alert.Info.Area.Geocodes.SAME {
+		if len(location) == 6 && isDigits(location) && !slices.Contains(locations, location) {
+			locations = append(locations, location)
+		}
+	}
+	if len(locations) == 0 {
+		return nil, ErrNoLocations
+	}
+
+	template := Header{
+		Originator: OriginatorWXR,
+		Event:      event,
+		Issued:     alert.Sent.UTC(),
+		Purge:      PurgeTime(alert.Sent, alert.Info.Expires),
+	}
+	if params != nil {
+		if originators[params.EASORG] {
+			template.Originator = params.EASORG
+		}
+		template.Sender = senderFromWMO(params.WMOidentifier)
+	}
+
+	var headers []Header
+	for chunk := range slices.Chunk(locations, MaxLocations) {
+		header := template
+		header.Locations = chunk
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// PurgeTime rounds the time an alert is valid up to a valid SAME purge time, 15 minute steps
+// up to an hour and 30 minute steps after that, at most 99 hours 30 minutes
+func PurgeTime(issued time.Time, expires time.Time) time.Duration {
+	valid := expires.Sub(issued)
+	step := 15 * time.Minute
+	if valid > time.Hour {
+		step = 30 * time.Minute
+	}
+	purge := ((valid + step - 1) / step) * step
+	return min(max(purge, 15*time.Minute), 99*time.Hour+30*time.Minute)
+}
+
+// NWS offices send as their ICAO id, e.g. KDMX/NWS
+func senderFromWMO(wmo string) string {
+	parts := strings.Fields(wmo)
+	if len(parts) < 2 {
+		return "NWS"
+	}
+	return parts[1] + "/NWS"
+}
+
+func formatPurge(purge time.Duration) string {
+	hours := int(purge.Hours())
+	minutes := int(purge.Minutes()) % 60
+	return fmt.Sprintf("%02d%02d", hours, minutes)
+}
+
+// The sender is always eight characters, padded with spaces
+func formatSender(sender string) string {
+	if len(sender) > 8 {
+		return sender[:8]
+	}
+	return fmt.Sprintf("%-8s", sender)
+}
+
+/* ---- Decoding ---- */
+
+var headerRE = regexp.MustCompile(`^ZCZC-([A-Z]{3})-([A-Z0-9]{3})-((?:\d{6}-?)+)\+(\d{4})-(\d{7})-([^-]{1,8})-?$`)
+
+// Decode parses a SAME header. The header only has the day of the year, so ref anchors the
+// year, normally the time the header was received.
+func Decode(raw string, ref time.Time) (Header, error) {
+	raw = strings.TrimSpace(raw)
+	m := headerRE.FindStringSubmatch(raw)
+	if m == nil {
+		return Header{}, fmt.Errorf("malformed SAME header %q", raw)
+	}
+
+	header := Header{
+		Originator: m[1],
+		Event:      m[2],
+		Sender:     strings.TrimRight(m[6], " "),
+	}
+	if !originators[header.Originator] {
+		return Header{}, fmt.Errorf("unknown SAME originator %q", header.Originator)
+	}
+
+	header.Locations = strings.Split(strings.TrimSuffix(m[3], "-"), "-")
+	if len(header.Locations) > MaxLocations {
+		return Header{}, fmt.Errorf("SAME header has %d locations, at most %d are allowed", len(header.Locations), MaxLocations)
+	}
+
+	hours, _ := strconv.Atoi(m[4][:2])
+	minutes, _ := strconv.Atoi(m[4][2:])
+	if minutes%15 != 0 || (hours > 0 && minutes%30 != 0) {
+		return Header{}, fmt.Errorf("invalid SAME purge time %q", m[4])
+	}
+	header.Purge = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+
+	issued, err := resolveJulian(m[5], ref)
+	if err != nil {
+		return Header{}, err
+	}
+	header.Issued = issued
+	return header, nil
+}
+
+// Resolves JJJHHMM against a reference time, using the year that puts it closest
+func resolveJulian(jjjhhmm string, ref time.Time) (time.Time, error) {
+	day, _ := strconv.Atoi(jjjhhmm[:3])
+	hour, _ := strconv.Atoi(jjjhhmm[3:5])
+	minute, _ := strconv.Atoi(jjjhhmm[5:])
+	if day < 1 || day > 366 || hour > 23 || minute > 59 {
+		return time.Time{}, fmt.Errorf("invalid SAME issue time %q", jjjhhmm)
+	}
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+	ref = ref.UTC()
+
+	var best time.Time
+	for _, year := range []int{ref.Year() - 1, ref.Year(), ref.Year() + 1} {
+		candidate := time.Date(year, 1, day, hour, minute, 0, 0, time.UTC)
+		// Day 366 rolls over outside leap years
+		if candidate.Year() != year {
+			continue
+		}
+		if best.IsZero() || absDuration(candidate.Sub(ref)) < absDuration(best.Sub(ref)) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+/* ---- Validation ---- */
+
+// Validate compares the header built from an alert with one received for it, e.g. from a
+// weather radio receiver, and lists every difference. An alert sent as several headers is
+// compared with the one sharing the most locations with what was received.
+func Validate(alert NWS.Alert, received Header) []error {
+	headers, err := FromAlert(alert)
+	if err != nil {
+		return []error{err}
+	}
+	expected, shared := headers[0], -1
+	for _, header := range headers {
+		n := 0
+		for _, location := range header.Locations {
+			if slices.Contains(received.Locations, location) {
+				n++
+			}
+		}
+		if n > shared {
+			expected, shared = header, n
+		}
+	}
+
+	var problems []error
+	if received.Originator != expected.Originator {
+		problems = append(problems, fmt.Errorf("originator is %s, expected %s", received.Originator, expected.Originator))
+	}
+	if received.Event != expected.Event {
+		problems = append(problems, fmt.Errorf("event is %s, expected %s", received.Event, expected.Event))
+	}
+	for _, location := range expected.Locations {
+		if !slices.Contains(received.Locations, location) {
+			problems = append(problems, fmt.Errorf("location %s is missing", location))
+		}
+	}
+	for _, location := range received.Locations {
+		if !slices.Contains(expected.Locations, location) {
+			problems = append(problems, fmt.Errorf("location %s is not in the alert", location))
+		}
+	}
+	if received.Purge != expected.Purge {
+		problems = append(problems, fmt.Errorf("purge time is %s, expected %s", formatPurge(received.Purge), formatPurge(expected.Purge)))
+	}
+	// Only the day of the year and time are sent, the year is a guess
+	if received.Issued.UTC().Format("0021504") != expected.Issued.Format("0021504") {
+		problems = append(problems, fmt.Errorf("issued at %s, expected %s", received.Issued.UTC().Format("0021504"), expected.Issued.Format("0021504")))
+	}
+	return problems
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
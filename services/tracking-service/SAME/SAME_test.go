@@ -0,0 +1,229 @@
+package SAME
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"trackingService/NWS"
+)
+
+// A tornado warning for two Iowa counties, valid 45 minutes
+func tornadoWarning(sent time.Time, valid time.Duration, locations ...string) NWS.Alert {
+	return NWS.Alert{
+		Sent: sent,
+		Info: NWS.Info{
+			EventCode: NWS.EventCode{SAME: "TOR", NWS: "TOW"},
+			Expires:   sent.Add(valid),
+			Parameters: &NWS.Parameters{
+				WMOidentifier: "WFUS53 KDMX 152310",
+				EASORG:        "WXR",
+			},
+			Area: NWS.Area{Geocodes: NWS.Geocodes{SAME: locations}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		alert  NWS.Alert
+		header string
+	}{
+		{
+			name:   "tornado warning",
+			alert:  tornadoWarning(time.Date(2025, 6, 15, 23, 10, 42, 0, time.UTC), 45*time.Minute, "019169", "019015"),
+			header: "ZCZC-WXR-TOR-019169-019015+0045-1662310-KDMX/NWS-",
+		},
+		{
+			name:   "sent in another zone, sender padded",
+			alert:  withSender(tornadoWarning(time.Date(2025, 1, 2, 6, 5, 0, 0, time.FixedZone("CST", -6*3600)), 2*time.Hour, "119169"), ""),
+			header: "ZCZC-WXR-TOR-119169+0200-0021205-NWS     -",
+		},
+		{
+			name:   "last day of a leap year",
+			alert:  tornadoWarning(time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC), 30*time.Minute, "019169"),
+			header: "ZCZC-WXR-TOR-019169+0030-3662350-KDMX/NWS-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := FromAlert(tt.alert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(headers) != 1 {
+				t.Fatalf("got %d headers, want 1", len(headers))
+			}
+			header := headers[0]
+			if got := header.String(); got != tt.header {
+				t.Fatalf("encoded %q, want %q", got, tt.header)
+			}
+
+			// Received a little after it was sent
+			decoded, err := Decode(header.String(), tt.alert.Sent.Add(2*time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header.Issued = header.Issued.Truncate(time.Minute)
+			header.Sender = strings.TrimRight(formatSender(header.Sender), " ")
+			if !reflect.DeepEqual(decoded, header) {
+				t.Errorf("decoded\n %+v\nwant\n %+v", decoded, header)
+			}
+			if problems := Validate(tt.alert, decoded); len(problems) != 0 {
+				t.Errorf("decoded header doesn't validate: %v", problems)
+			}
+		})
+	}
+}
+
+func withSender(alert NWS.Alert, wmo string) NWS.Alert {
+	alert.Info.Parameters.WMOidentifier = wmo
+	return alert
+}
+
+func TestPurgeTime(t *testing.T) {
+	tests := []struct {
+		valid time.Duration
+		want  time.Duration
+		code  string
+	}{
+		{0, 15 * time.Minute, "0015"},
+		{10 * time.Minute, 15 * time.Minute, "0015"},
+		{45 * time.Minute, 45 * time.Minute, "0045"},
+		{46 * time.Minute, time.Hour, "0100"},
+		{time.Hour, time.Hour, "0100"},
+		{time.Hour + 5*time.Minute, time.Hour + 30*time.Minute, "0130"},
+		{6*time.Hour + 31*time.Minute, 7 * time.Hour, "0700"},
+		{99*time.Hour + 30*time.Minute, 99*time.Hour + 30*time.Minute, "9930"},
+		{120 * time.Hour, 99*time.Hour + 30*time.Minute, "9930"},
+		{-time.Hour, 15 * time.Minute, "0015"},
+	}
+
+	issued := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.valid.String(), func(t *testing.T) {
+			got := PurgeTime(issued, issued.Add(tt.valid))
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if code := formatPurge(got); code != tt.code {
+				t.Errorf("encoded as %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestDecodeDayOfYear(t *testing.T) {
+	tests := []struct {
+		name   string
+		julian string
+		ref    time.Time
+		want   time.Time
+	}{
+		{"day 366 of a leap year", "3662350", time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC), time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)},
+		{"day 366 received in the new year", "3662350", time.Date(2025, 1, 1, 0, 5, 0, 0, time.UTC), time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)},
+		{"day 366 outside a leap year goes to the last leap year", "3661200", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC)},
+		{"day 365 of a leap year is December 30", "3651200", time.Date(2024, 12, 30, 12, 5, 0, 0, time.UTC), time.Date(2024, 12, 30, 12, 0, 0, 0, time.UTC)},
+		{"day 1 received the year before", "0010005", time.Date(2025, 12, 31, 23, 58, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 5, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := Decode("ZCZC-WXR-TOR-019169+0030-"+tt.julian+"-KDMX/NWS-", tt.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !header.Issued.Equal(tt.want) {
+				t.Errorf("got %v, want %v", header.Issued, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"0001200", "3671200", "1662400", "1662360"} {
+		if _, err := Decode("ZCZC-WXR-TOR-019169+0030-"+invalid+"-KDMX/NWS-", time.Now()); err == nil {
+			t.Errorf("%s decoded, want an error", invalid)
+		}
+	}
+}
+
+func TestLocationLimit(t *testing.T) {
+	var locations []string
+	for i := range MaxLocations + 5 {
+		locations = append(locations, fmt.Sprintf("019%03d", 2*i+1))
+	}
+	// Duplicates and malformed codes don't take a place
+	locations = append([]string{"019001", "19003", "01900A"}, locations...)
+
+	sent := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	alert := tornadoWarning(sent, time.Hour, locations...)
+	headers, err := FromAlert(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the way NWS sends long lists, every location is in exactly one header
+	if len(headers) != 2 {
+		t.Fatalf("got %d headers, want 2", len(headers))
+	}
+	if len(headers[0].Locations) != MaxLocations || headers[0].Locations[0] != "019001" || headers[0].Locations[MaxLocations-1] != "019061" {
+		t.Errorf("first header has %v, want the first %d valid codes in order", headers[0].Locations, MaxLocations)
+	}
+	if want := []string{"019063", "019065", "019067", "019069", "019071"}; !reflect.DeepEqual(headers[1].Locations, want) {
+		t.Errorf("second header has %v, want %v", headers[1].Locations, want)
+	}
+	for i, header := range headers {
+		other := headers[1-i]
+		if header.Originator != other.Originator || header.Event != other.Event || header.Purge != other.Purge || !header.Issued.Equal(other.Issued) || header.Sender != other.Sender {
+			t.Errorf("header %d differs from the other beyond its locations: %+v", i, header)
+		}
+		// Each validates on its own against the alert
+		decoded, err := Decode(header.String(), sent.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := Validate(alert, decoded); len(problems) != 0 {
+			t.Errorf("header %d doesn't validate: %v", i, problems)
+		}
+	}
+
+	// A header with more than 31 is not valid SAME
+	raw := "ZCZC-WXR-TOR-" + strings.Join(locations[3:3+MaxLocations+1], "-") + "+0100-1662310-KDMX/NWS-"
+	if _, err := Decode(raw, time.Date(2025, 6, 15, 23, 12, 0, 0, time.UTC)); err == nil {
+		t.Errorf("decoded a header with %d locations", MaxLocations+1)
+	}
+	raw = "ZCZC-WXR-TOR-" + strings.Join(locations[3:3+MaxLocations], "-") + "+0100-1662310-KDMX/NWS-"
+	if _, err := Decode(raw, time.Date(2025, 6, 15, 23, 12, 0, 0, time.UTC)); err != nil {
+		t.Errorf("header with %d locations: %v", MaxLocations, err)
+	}
+}
+
+func TestFromAlertErrors(t *testing.T) {
+	sent := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+
+	blocked := tornadoWarning(sent, time.Hour, "019169")
+	blocked.Info.Parameters.BlockChannels.EAS = true
+	noEvent := tornadoWarning(sent, time.Hour, "019169")
+	noEvent.Info.EventCode.SAME = ""
+
+	tests := []struct {
+		name  string
+		alert NWS.Alert
+		err   error
+	}{
+		{"blocked", blocked, ErrBlocked},
+		{"no event", noEvent, ErrNoEvent},
+		{"no locations", tornadoWarning(sent, time.Hour), ErrNoLocations},
+		{"only malformed locations", tornadoWarning(sent, time.Hour, "19169", "TXC303"), ErrNoLocations},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromAlert(tt.alert); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
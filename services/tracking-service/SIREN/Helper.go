@@ -27,7 +27,8 @@ func GetCanonicalIdentifier(vtec *NWS.VTEC) string {
 	return canonicalIdentifier
 }
 
-// GenerateSpecialAlertID creates a unique ID for alerts without VTEC
+// GenerateSpecialAlertID creates a unique ID for alerts without VTEC. It names the event after
+// the CAP that started it, later CAPs find the event through their references instead.
 func GenerateSpecialAlertID(alert NWS.Alert) string {
 	// Extract useful identifiers from the alert
 	office := ""
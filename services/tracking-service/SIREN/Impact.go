@@ -0,0 +1,155 @@
+package SIREN
+
+import (
+	"strings"
+	"trackingService/NWS"
+)
+
+// ImpactTier is how dangerous an event is, from the impact-based warning tags on its CAPs
+type ImpactTier string
+
+const (
+	TierBase         ImpactTier = "base"
+	TierConsiderable ImpactTier = "considerable"
+	TierPDS          ImpactTier = "pds"         // Particularly dangerous situation
+	TierDestructive  ImpactTier = "destructive" // Destructive severe thunderstorm
+	TierEmergency    ImpactTier = "emergency"   // Catastrophic, Tornado or Flash Flood Emergency
+)
+
+var tierRanks = map[ImpactTier]int{
+	TierBase:         0,
+	TierConsiderable: 1,
+	TierPDS:          2,
+	TierDestructive:  3,
+	TierEmergency:    4,
+}
+
+// Rank orders the tiers, an unknown tier ranks with the base tier
+func (t ImpactTier) Rank() int {
+	return tierRanks[t]
+}
+
+// How the hazard was detected
+const (
+	DetectionRadarIndicated = "RADAR INDICATED"
+	DetectionObserved       = "OBSERVED"
+)
+
+type Impact struct {
+	Tier         ImpactTier `bson:"tier" msgpack:"tier"`
+	Label        string     `bson:"label,omitempty" msgpack:"label,omitempty"` // e.g. Tornado Emergency
+	Detection    string     `bson:"detection,omitempty" msgpack:"detection,omitempty"`
+	DamageThreat string     `bson:"damageThreat,omitempty" msgpack:"damageThreat,omitempty"`
+	MaxHailSize  float64    `bson:"maxHailSize,omitempty" msgpack:"maxHailSize,omitempty"`
+	MaxWindGust  float64    `bson:"maxWindGust,omitempty" msgpack:"maxWindGust,omitempty"`
+}
+
+// Whether the hazard has been seen, rather than inferred from radar
+func (i Impact) Observed() bool {
+	return i.Detection == DetectionObserved
+}
+
+// Classify derives the impact of a CAP from its threat tags, falling back to the wording
+// of its headlines for products that don't carry the tags. The description isn't used, it
+// mentions emergencies in text like "a tornado emergency was in effect earlier". A cancel
+// says nothing about the hazard, so it is always the base tier.
+func Classify(alert NWS.Alert) Impact {
+	impact := Impact{Tier: TierBase}
+	if alert.MsgType == "Cancel" {
+		return impact
+	}
+	text := strings.ToUpper(alert.Info.Headline)
+
+	var params NWS.Parameters
+	if alert.Info.Parameters != nil {
+		params = *alert.Info.Parameters
+		text += " " + strings.ToUpper(params.NWSheadline)
+	}
+	impact.MaxHailSize = params.MaxHailSize
+	impact.MaxWindGust = params.MaxWindGust
+
+	for _, detection := range []string{params.TornadoDetection, params.FlashFloodDetection, params.WaterspoutDetection, params.SnowSquallDetection} {
+		if detection != "" {
+			impact.Detection = strings.ToUpper(detection)
+			break
+		}
+	}
+	for _, threat := range []string{params.TornadoDamageThreat, params.FlashFloodDamageThreat, params.ThunderstormDamageThreat} {
+		if threat != "" {
+			impact.DamageThreat = strings.ToUpper(threat)
+			break
+		}
+	}
+
+	switch {
+	case strings.EqualFold(params.TornadoDamageThreat, "CATASTROPHIC") || strings.Contains(text, "TORNADO EMERGENCY"):
+		impact.Tier = TierEmergency
+		impact.Label = "Tornado Emergency"
+	case strings.EqualFold(params.FlashFloodDamageThreat, "CATASTROPHIC") || strings.Contains(text, "FLASH FLOOD EMERGENCY"):
+		impact.Tier = TierEmergency
+		impact.Label = "Flash Flood Emergency"
+	case strings.EqualFold(params.ThunderstormDamageThreat, "DESTRUCTIVE"):
+		impact.Tier = TierDestructive
+		impact.Label = "Destructive " + alert.Info.Event
+	// A considerable tornado warning is the PDS tornado warning
+	case strings.Contains(text, "PARTICULARLY DANGEROUS SITUATION") || strings.EqualFold(params.TornadoDamageThreat, "CONSIDERABLE"):
+		impact.Tier = TierPDS
+		impact.Label = "PDS " + alert.Info.Event
+	case strings.EqualFold(params.FlashFloodDamageThreat, "CONSIDERABLE") ||
+		strings.EqualFold(params.ThunderstormDamageThreat, "CONSIDERABLE"):
+		impact.Tier = TierConsiderable
+	}
+	return impact
+}
+
+// Escalated is true when the event got more dangerous, a higher tier or a hazard that went
+// from radar indicated to observed
+func Escalated(previous Impact, current Impact) bool {
+	if current.Tier.Rank() > previous.Tier.Rank() {
+		return true
+	}
+	return current.Tier.Rank() == previous.Tier.Rank() && current.Observed() && !previous.Observed()
+}
+
+// ApplyImpact classifies the CAP, records its tier in the event's history and makes it the
+// event's impact if it is the event's newest CAP. Returns whether the CAP escalated the event.
+// Cancels are skipped, the event keeps the impact it had.
+func ApplyImpact(event *SirenAlert, alert NWS.Alert) bool {
+	if alert.MsgType == "Cancel" {
+		return false
+	}
+	impact := Classify(alert)
+	newest := event.MostRecentCAP == alert.Identifier
+	escalated := newest && event.Impact != nil && Escalated(*event.Impact, impact)
+	if newest || event.Impact == nil {
+		event.Impact = &impact
+	}
+
+	for i := range event.History {
+		if event.History[i].CapID == alert.Identifier {
+			event.History[i].Impact = impact.Tier
+			event.History[i].Escalation = escalated
+		}
+	}
+	return escalated
+}
+
+// EscalationFrom reports whether the CAP escalated the event, and the tier it escalated from
+func EscalationFrom(event SirenAlert, capID string) (ImpactTier, bool) {
+	for i, history := range event.History {
+		if history.CapID != capID {
+			continue
+		}
+		if !history.Escalation {
+			return "", false
+		}
+		// History is newest first, so the tier before it is further down
+		for _, older := range event.History[i+1:] {
+			if older.Impact != "" {
+				return older.Impact, true
+			}
+		}
+		return TierBase, true
+	}
+	return "", false
+}
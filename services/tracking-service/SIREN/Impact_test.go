@@ -0,0 +1,180 @@
+package SIREN
+
+import (
+	"testing"
+	"trackingService/NWS"
+)
+
+func warning(event string, params *NWS.Parameters) NWS.Alert {
+	return NWS.Alert{
+		Identifier: "urn:oid:2.49.0.1.840.0.1",
+		MsgType:    "Update",
+		Info: NWS.Info{
+			Event:      event,
+			Headline:   event + " issued June 15 at 6:10PM CDT until June 15 at 6:45PM CDT by NWS Des Moines IA",
+			Parameters: params,
+		},
+	}
+}
+
+func TestClassify(t *testing.T) {
+	emergencyInDescription := warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "OBSERVED"})
+	emergencyInDescription.Info.Description = "The tornado emergency for Des Moines was allowed to expire."
+	pdsHeadline := warning("Tornado Warning", nil)
+	pdsHeadline.Info.Headline = "THIS IS A PARTICULARLY DANGEROUS SITUATION"
+	cancel := warning("Tornado Warning", &NWS.Parameters{TornadoDamageThreat: "CATASTROPHIC", NWSheadline: "TORNADO EMERGENCY FOR DES MOINES"})
+	cancel.MsgType = "Cancel"
+
+	tests := []struct {
+		name      string
+		alert     NWS.Alert
+		tier      ImpactTier
+		label     string
+		detection string
+	}{
+		{
+			name:  "no tags",
+			alert: warning("Severe Thunderstorm Warning", nil),
+			tier:  TierBase,
+		},
+		{
+			name:      "radar indicated tornado",
+			alert:     warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "RADAR INDICATED"}),
+			tier:      TierBase,
+			detection: DetectionRadarIndicated,
+		},
+		{
+			name:      "considerable flash flood",
+			alert:     warning("Flash Flood Warning", &NWS.Parameters{FlashFloodDetection: "RADAR INDICATED", FlashFloodDamageThreat: "CONSIDERABLE"}),
+			tier:      TierConsiderable,
+			detection: DetectionRadarIndicated,
+		},
+		{
+			name:  "considerable thunderstorm",
+			alert: warning("Severe Thunderstorm Warning", &NWS.Parameters{ThunderstormDamageThreat: "CONSIDERABLE"}),
+			tier:  TierConsiderable,
+		},
+		{
+			name:      "considerable tornado is PDS",
+			alert:     warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "OBSERVED", TornadoDamageThreat: "CONSIDERABLE"}),
+			tier:      TierPDS,
+			label:     "PDS Tornado Warning",
+			detection: DetectionObserved,
+		},
+		{
+			name:  "PDS in the headline",
+			alert: pdsHeadline,
+			tier:  TierPDS,
+			label: "PDS Tornado Warning",
+		},
+		{
+			name:  "destructive thunderstorm",
+			alert: warning("Severe Thunderstorm Warning", &NWS.Parameters{ThunderstormDamageThreat: "DESTRUCTIVE"}),
+			tier:  TierDestructive,
+			label: "Destructive Severe Thunderstorm Warning",
+		},
+		{
+			name:      "catastrophic tornado",
+			alert:     warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "OBSERVED", TornadoDamageThreat: "CATASTROPHIC"}),
+			tier:      TierEmergency,
+			label:     "Tornado Emergency",
+			detection: DetectionObserved,
+		},
+		{
+			name:  "flash flood emergency in the NWS headline",
+			alert: warning("Flash Flood Warning", &NWS.Parameters{NWSheadline: "FLASH FLOOD EMERGENCY FOR SOUTHEASTERN POLK COUNTY"}),
+			tier:  TierEmergency,
+			label: "Flash Flood Emergency",
+		},
+		{
+			name:      "emergency only in the description",
+			alert:     emergencyInDescription,
+			tier:      TierBase,
+			detection: DetectionObserved,
+		},
+		{
+			name:  "cancel",
+			alert: cancel,
+			tier:  TierBase,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impact := Classify(tt.alert)
+			if impact.Tier != tt.tier || impact.Label != tt.label || impact.Detection != tt.detection {
+				t.Errorf("got %s %q %q, want %s %q %q", impact.Tier, impact.Label, impact.Detection, tt.tier, tt.label, tt.detection)
+			}
+		})
+	}
+}
+
+func TestEscalated(t *testing.T) {
+	radar := Impact{Tier: TierBase, Detection: DetectionRadarIndicated}
+	observed := Impact{Tier: TierBase, Detection: DetectionObserved}
+	pds := Impact{Tier: TierPDS, Detection: DetectionRadarIndicated}
+
+	tests := []struct {
+		name     string
+		previous Impact
+		current  Impact
+		want     bool
+	}{
+		{"radar indicated to observed", radar, observed, true},
+		{"higher tier", radar, pds, true},
+		{"higher tier, no longer observed", observed, pds, true},
+		{"unchanged", radar, radar, false},
+		{"still observed", observed, observed, false},
+		{"observed to radar indicated", observed, radar, false},
+		{"lower tier but observed", pds, observed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Escalated(tt.previous, tt.current); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyImpactEscalation(t *testing.T) {
+	first := warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "RADAR INDICATED"})
+	first.Identifier = "cap-1"
+	second := warning("Tornado Warning", &NWS.Parameters{TornadoDetection: "OBSERVED"})
+	second.Identifier = "cap-2"
+	cancel := warning("Tornado Warning", nil)
+	cancel.Identifier = "cap-3"
+	cancel.MsgType = "Cancel"
+
+	event := SirenAlert{MostRecentCAP: "cap-1", History: []SirenAlertHistory{{CapID: "cap-1"}}}
+	if ApplyImpact(&event, first) {
+		t.Fatal("the first CAP escalated the event")
+	}
+
+	// History is newest first
+	event.MostRecentCAP = "cap-2"
+	event.History = append([]SirenAlertHistory{{CapID: "cap-2"}}, event.History...)
+	if !ApplyImpact(&event, second) {
+		t.Fatal("radar indicated to observed didn't escalate the event")
+	}
+	if !event.Impact.Observed() || !event.History[0].Escalation || event.History[1].Escalation {
+		t.Fatalf("escalation recorded as %+v, impact %+v", event.History, *event.Impact)
+	}
+	if from, ok := EscalationFrom(event, "cap-2"); !ok || from != TierBase {
+		t.Errorf("escalated from %q %v, want %q", from, ok, TierBase)
+	}
+	if _, ok := EscalationFrom(event, "cap-1"); ok {
+		t.Error("the first CAP is recorded as an escalation")
+	}
+
+	// The cancel leaves the impact alone
+	event.MostRecentCAP = "cap-3"
+	event.History = append([]SirenAlertHistory{{CapID: "cap-3"}}, event.History...)
+	if ApplyImpact(&event, cancel) {
+		t.Error("the cancel escalated the event")
+	}
+	if !event.Impact.Observed() || event.History[0].Impact != "" {
+		t.Errorf("the cancel changed the impact to %+v, history %+v", *event.Impact, event.History[0])
+	}
+}
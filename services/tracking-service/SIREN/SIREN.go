@@ -3,6 +3,7 @@ package SIREN
 import (
 	"time"
 	"trackingService/NWS"
+	"trackingService/WEA"
 )
 
 type SirenAlertHistory struct {
@@ -12,6 +13,11 @@ type SirenAlertHistory struct {
 	AppliesTo             []string       `bson:"appliesTo,omitempty",msgpack:"appliesTo,omitempty"`
 	CapID                 string         `bson:"capID,omitempty",msgpack:"capID,omitempty"`
 	ExpiresAt             time.Time      `bson:"expiresAt",msgpack:"expiresAt"`
+	// Impact tier of the CAP, and whether it escalated the event
+	Impact     ImpactTier `bson:"impact,omitempty" msgpack:"impact,omitempty"`
+	Escalation bool       `bson:"escalation,omitempty" msgpack:"escalation,omitempty"`
+	// When the CAP was sent, RecievedAt is when it was processed
+	Sent time.Time `bson:"sent,omitempty" msgpack:"sent,omitempty"`
 }
 
 type SirenAlert struct {
@@ -24,6 +30,18 @@ type SirenAlert struct {
 	UpgradedTo         string              `bson:"upgradedTo,omitempty",msgpack:"upgradedTo,omitempty"`
 	History            []SirenAlertHistory `bson:"history",msgpack:"history"`
 	Areas              []string            `bson:"areas",msgpack:"areas"`
+	// W3C traceparent of the CAP that last changed the alert
+	TraceParent string `bson:"traceParent,omitempty" msgpack:"-"`
+	// Storm reports that happened inside the event while it was in effect
+	Reports      []StormReport `bson:"reports,omitempty" msgpack:"-"`
+	Verification *Verification `bson:"verification,omitempty" msgpack:"-"`
+	// The SPC watch a TO.A or SV.A event belongs to, see Watch
+	Watch string `bson:"watch,omitempty" msgpack:"watch,omitempty"`
+	// Impact of the newest CAP, see Classify
+	Impact *Impact `bson:"impact,omitempty" msgpack:"impact,omitempty"`
+	// For events without VTEC, the earliest CAP the event's chain references. An update
+	// processed before the CAP it updates waits here for it, see handleSpecialAlert.
+	ThreadRoot string `bson:"threadRoot,omitempty" msgpack:"-"`
 }
 
 type SirenAlertPushNotification struct {
@@ -33,6 +51,24 @@ type SirenAlertPushNotification struct {
 	Sender     string   `bson:"sender",msgpack:"sender"`
 	EventCode  string   `bson:"code",msgpack:"code"`
 	Action     string   `bson:"action",msgpack:"action"`
+	Tier       string   `bson:"tier,omitempty" msgpack:"tier,omitempty"`
+	Label      string   `bson:"label,omitempty" msgpack:"label,omitempty"` // e.g. Tornado Emergency
+	// The CAP raised the event's tier, Action is Escalated and PreviousTier is what it was
+	Escalated    bool   `bson:"escalated,omitempty" msgpack:"escalated,omitempty"`
+	PreviousTier string `bson:"previousTier,omitempty" msgpack:"previousTier,omitempty"`
+	// The text phones receive through WEA, empty when the alert isn't sent over WEA
+	WEA []WEA.Message `bson:"wea,omitempty" msgpack:"wea,omitempty"`
+	// EAS SAME headers for broadcast encoders, empty when EAS is blocked. Each holds at most
+	// 31 locations, an alert for more is sent as several.
+	SAME []string `bson:"same,omitempty" msgpack:"same,omitempty"`
+	// What the match service needs to find the subscribers an alert reaches
+	Severity  string       `bson:"severity,omitempty" msgpack:"severity,omitempty"`
+	SAMECodes []string     `bson:"sameCodes,omitempty" msgpack:"sameCodes,omitempty"`
+	Polygon   [][2]float64 `bson:"polygon,omitempty" msgpack:"polygon,omitempty"` // lon/lat ring of a storm based warning
+	// The storm's TIME...MOT...LOC and when the alert ends, so the match service can tell
+	// point subscribers when the storm reaches them
+	Motion  *NWS.EventMotionDescription `bson:"motion,omitempty" msgpack:"motion,omitempty"`
+	Expires time.Time                   `bson:"expires,omitempty" msgpack:"expires,omitempty"`
 }
 
 type MiniCAP struct {
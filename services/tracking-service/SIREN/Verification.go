@@ -0,0 +1,323 @@
+package SIREN
+
+import (
+	"context"
+	"strings"
+	"time"
+	"trackingService/NWS"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// How a storm report was matched to an event
+const (
+	MatchedByPolygon = "polygon"
+	MatchedByArea    = "area"
+)
+
+// A Local Storm Report that happened inside an event's area while it was in effect
+type StormReport struct {
+	ProductKey string    `bson:"productKey"`
+	Time       time.Time `bson:"time"`
+	Event      string    `bson:"event"`
+	Magnitude  string    `bson:"magnitude,omitempty"`
+	Location   string    `bson:"location"`
+	County     string    `bson:"county"`
+	State      string    `bson:"state"`
+	Lat        float64   `bson:"lat"`
+	Lon        float64   `bson:"lon"`
+	Source     string    `bson:"source"`
+	Remarks    string    `bson:"remarks,omitempty"`
+	MatchedBy  string    `bson:"matchedBy"`
+	Verifies   bool      `bson:"verifies"` // Whether the report is of the hazard the event warned for
+}
+
+type Verification struct {
+	Verified        bool      `bson:"verified"`
+	Reports         int       `bson:"reports"`
+	FirstReport     time.Time `bson:"firstReport,omitempty"` // Time of the first verifying report
+	LeadTimeSeconds float64   `bson:"leadTimeSeconds"`       // From issuance to the first verifying report
+}
+
+// Which reports verify each VTEC phenomenon, following the NWS verification criteria
+var verifyingReports = map[string]func(report NWS.LSR) bool{
+	"TO": func(report NWS.LSR) bool {
+		return report.Event == "TORNADO"
+	},
+	"SV": func(report NWS.LSR) bool {
+		switch report.Event {
+		case "TORNADO", "TSTM WND DMG":
+			return true
+		case "HAIL":
+			return report.Magnitude.Value >= 1
+		case "TSTM WND GST":
+			if report.Magnitude.Units == "KT" || report.Magnitude.Units == "KTS" {
+				return report.Magnitude.Value >= 50
+			}
+			return report.Magnitude.Value >= 58
+		}
+		return false
+	},
+	"FF": func(report NWS.LSR) bool {
+		return report.Event == "FLASH FLOOD" || report.Event == "FLOOD"
+	},
+	"FA": func(report NWS.LSR) bool {
+		return report.Event == "FLASH FLOOD" || report.Event == "FLOOD"
+	},
+	"MA": func(report NWS.LSR) bool {
+		switch report.Event {
+		case "MARINE TSTM WIND", "WATERSPOUT", "HAIL":
+			return true
+		}
+		return false
+	},
+	"SQ": func(report NWS.LSR) bool {
+		return report.Event == "SNOW SQUALL"
+	},
+	"DS": func(report NWS.LSR) bool {
+		return report.Event == "DUST STORM"
+	},
+}
+
+// Whether the report is of the hazard the event was issued for
+func ReportVerifies(sirenId string, report NWS.LSR) bool {
+	if len(sirenId) < 2 {
+		return false
+	}
+	verifies, ok := verifyingReports[sirenId[:2]]
+	return ok && verifies(report)
+}
+
+// When the event was first issued, the sent time of the oldest CAP in its history. Entries
+// stored before the sent time was kept fall back to when they were processed.
+func EventIssued(alert SirenAlert) time.Time {
+	var issued time.Time
+	for _, history := range alert.History {
+		sent := history.Sent
+		if sent.IsZero() {
+			sent = history.RecievedAt
+		}
+		if issued.IsZero() || sent.Before(issued) {
+			issued = sent
+		}
+	}
+	return issued
+}
+
+// FillSentTimes sets the sent time of history entries stored before it was kept from their CAPs
+func FillSentTimes(alert *SirenAlert, caps []NWS.Alert) {
+	sent := make(map[string]time.Time, len(caps))
+	for _, cap := range caps {
+		sent[cap.Identifier] = cap.Sent
+	}
+	for i := range alert.History {
+		if alert.History[i].Sent.IsZero() {
+			alert.History[i].Sent = sent[alert.History[i].CapID]
+		}
+	}
+}
+
+// MatchReport checks that the report happened while the event was in effect and inside it.
+// Reports are placed with the polygons of the event's CAPs, events without polygons, like
+// watches, are matched on the county names in the CAP area description instead.
+func MatchReport(report NWS.LSR, alert SirenAlert, caps []NWS.Alert) (string, bool) {
+	issued := EventIssued(alert).Truncate(time.Minute)
+	if issued.IsZero() || report.Time.Before(issued) || report.Time.After(alert.Expires) {
+		return "", false
+	}
+
+	point := orb.Point{report.Lon, report.Lat}
+	hasPolygon := false
+	for _, cap := range caps {
+		polygon := cap.Info.Area.Polygon
+		if polygon == nil || len(polygon.Coordinates) == 0 {
+			continue
+		}
+		hasPolygon = true
+		if planar.PolygonContains(toOrbPolygon(polygon.Coordinates), point) {
+			return MatchedByPolygon, true
+		}
+	}
+	if hasPolygon {
+		return "", false
+	}
+
+	for _, cap := range caps {
+		if areaDescContains(cap.Info.Area.Description, report.County, report.State) {
+			return MatchedByArea, true
+		}
+	}
+	return "", false
+}
+
+func toOrbPolygon(coordinates [][][]float64) orb.Polygon {
+	polygon := make(orb.Polygon, 0, len(coordinates))
+	for _, ring := range coordinates {
+		orbRing := make(orb.Ring, 0, len(ring))
+		for _, pt := range ring {
+			if len(pt) >= 2 {
+				orbRing = append(orbRing, orb.Point{pt[0], pt[1]})
+			}
+		}
+		polygon = append(polygon, orbRing)
+	}
+	return polygon
+}
+
+// Area descriptions list the areas as "Story, IA; Boone, IA"
+func areaDescContains(areaDesc string, county string, state string) bool {
+	if county == "" {
+		return false
+	}
+	for _, area := range strings.Split(areaDesc, ";") {
+		name, areaState, _ := strings.Cut(area, ",")
+		if strings.EqualFold(strings.TrimSpace(name), county) &&
+			(state == "" || strings.EqualFold(strings.TrimSpace(areaState), state)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddReport records the report on the event and updates its verification, returning false
+// if the report was already recorded
+func AddReport(alert *SirenAlert, report StormReport) bool {
+	for _, existing := range alert.Reports {
+		if existing.ProductKey == report.ProductKey && existing.Time.Equal(report.Time) &&
+			existing.Lat == report.Lat && existing.Lon == report.Lon && existing.Event == report.Event {
+			return false
+		}
+	}
+	alert.Reports = append(alert.Reports, report)
+	verification := Verify(*alert)
+	alert.Verification = &verification
+	return true
+}
+
+// Verify summarizes the reports recorded on the event
+func Verify(alert SirenAlert) Verification {
+	verification := Verification{Reports: len(alert.Reports)}
+	for _, report := range alert.Reports {
+		if !report.Verifies {
+			continue
+		}
+		if !verification.Verified || report.Time.Before(verification.FirstReport) {
+			verification.Verified = true
+			verification.FirstReport = report.Time
+		}
+	}
+	if verification.Verified {
+		lead := verification.FirstReport.Sub(EventIssued(alert).Truncate(time.Minute))
+		verification.LeadTimeSeconds = max(lead, 0).Seconds()
+	}
+	return verification
+}
+
+/**============================================
+ *               Report Correlation
+ *=============================================**/
+
+// Correlator matches storm reports against the events in the state collection
+type Correlator struct {
+	State  *mongo.Collection
+	Alerts *mongo.Collection
+	// Lock, when set, holds the event's lock while it is updated and returns the unlock
+	Lock func(ctx context.Context, sirenId string) func()
+}
+
+// Correlate records the report on every event it falls in, returning their identifiers
+func (c *Correlator) Correlate(ctx context.Context, productKey string, report NWS.LSR) ([]string, error) {
+	if report.Time.IsZero() || (report.Lat == 0 && report.Lon == 0 && report.County == "") {
+		return nil, nil
+	}
+
+	// MatchReport counts the issue time to the minute, so an event sent later in the report's minute is a candidate.
+	// Entries stored before the sent time was kept only have the time they were processed, which is never earlier.
+	issuedBefore := bson.M{"$lt": report.Time.Truncate(time.Minute).Add(time.Minute)}
+	cursor, err := c.State.Find(ctx, bson.M{
+		"expires": bson.M{"$gte": report.Time},
+		"$or": bson.A{
+			bson.M{"history.sent": issuedBefore},
+			bson.M{"history.recievedAt": issuedBefore},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var candidates []SirenAlert
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, candidate := range candidates {
+		ok, err := c.correlateEvent(ctx, candidate.Identifier, productKey, report)
+		if err != nil {
+			return matched, err
+		}
+		if ok {
+			matched = append(matched, candidate.Identifier)
+		}
+	}
+	return matched, nil
+}
+
+func (c *Correlator) correlateEvent(ctx context.Context, sirenId string, productKey string, report NWS.LSR) (bool, error) {
+	if c.Lock != nil {
+		unlock := c.Lock(ctx, sirenId)
+		defer unlock()
+	}
+
+	// Read the event again now that it is locked
+	var alert SirenAlert
+	if err := c.State.FindOne(ctx, bson.M{"identifier": sirenId}).Decode(&alert); err != nil {
+		return false, err
+	}
+
+	capIDs := make([]string, 0, len(alert.History))
+	for _, history := range alert.History {
+		capIDs = append(capIDs, history.CapID)
+	}
+	cursor, err := c.Alerts.Find(ctx, bson.M{"identifier": bson.M{"$in": capIDs}})
+	if err != nil {
+		return false, err
+	}
+	var caps []NWS.Alert
+	if err := cursor.All(ctx, &caps); err != nil {
+		return false, err
+	}
+
+	FillSentTimes(&alert, caps)
+	matchedBy, ok := MatchReport(report, alert, caps)
+	if !ok {
+		return false, nil
+	}
+
+	added := AddReport(&alert, StormReport{
+		ProductKey: productKey,
+		Time:       report.Time,
+		Event:      report.Event,
+		Magnitude:  report.Magnitude.Raw,
+		Location:   report.Location,
+		County:     report.County,
+		State:      report.State,
+		Lat:        report.Lat,
+		Lon:        report.Lon,
+		Source:     report.Source,
+		Remarks:    report.Remarks,
+		MatchedBy:  matchedBy,
+		Verifies:   ReportVerifies(sirenId, report),
+	})
+	if !added {
+		return true, nil
+	}
+
+	_, err = c.State.UpdateOne(ctx, bson.M{"identifier": sirenId}, bson.M{"$set": bson.M{
+		"reports":      alert.Reports,
+		"verification": alert.Verification,
+	}})
+	return true, err
+}
@@ -0,0 +1,76 @@
+package SIREN
+
+import (
+	"testing"
+	"time"
+	"trackingService/NWS"
+)
+
+func TestEventIssued(t *testing.T) {
+	sent := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	// Replayed a day later, so every CAP was processed long after it was sent
+	processed := sent.Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		history []SirenAlertHistory
+		caps    []NWS.Alert
+		want    time.Time
+	}{
+		{
+			name: "sent time of the first CAP",
+			history: []SirenAlertHistory{
+				{CapID: "cap-2", RecievedAt: processed, Sent: sent.Add(20 * time.Minute)},
+				{CapID: "cap-1", RecievedAt: processed.Add(time.Minute), Sent: sent},
+			},
+			want: sent,
+		},
+		{
+			name: "stored before the sent time was kept",
+			history: []SirenAlertHistory{
+				{CapID: "cap-2", RecievedAt: processed.Add(time.Minute)},
+				{CapID: "cap-1", RecievedAt: processed},
+			},
+			want: processed,
+		},
+		{
+			name: "sent time filled from the CAPs",
+			history: []SirenAlertHistory{
+				{CapID: "cap-2", RecievedAt: processed, Sent: sent.Add(20 * time.Minute)},
+				{CapID: "cap-1", RecievedAt: processed},
+			},
+			caps: []NWS.Alert{{Identifier: "cap-1", Sent: sent}, {Identifier: "cap-2", Sent: sent.Add(20 * time.Minute)}},
+			want: sent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := SirenAlert{Identifier: "TO.W.KDMX.0042.2025", History: tt.history}
+			FillSentTimes(&alert, tt.caps)
+			if got := EventIssued(alert); !got.Equal(tt.want) {
+				t.Errorf("issued %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeadTimeFromSent(t *testing.T) {
+	sent := time.Date(2025, 6, 15, 23, 10, 0, 0, time.UTC)
+	alert := SirenAlert{
+		Identifier: "TO.W.KDMX.0042.2025",
+		Expires:    sent.Add(45 * time.Minute),
+		History:    []SirenAlertHistory{{CapID: "cap-1", RecievedAt: sent.Add(24 * time.Hour), Sent: sent}},
+	}
+	report := NWS.LSR{Time: sent.Add(12 * time.Minute), Event: "TORNADO", County: "Polk", State: "IA"}
+	caps := []NWS.Alert{{Identifier: "cap-1", Sent: sent, Info: NWS.Info{Area: NWS.Area{Description: "Polk, IA; Story, IA"}}}}
+
+	matchedBy, ok := MatchReport(report, alert, caps)
+	if !ok || matchedBy != MatchedByArea {
+		t.Fatalf("a report 12 minutes after the CAP was sent didn't match, got %q", matchedBy)
+	}
+	AddReport(&alert, StormReport{Time: report.Time, Event: report.Event, MatchedBy: matchedBy, Verifies: ReportVerifies(alert.Identifier, report)})
+	if alert.Verification == nil || alert.Verification.LeadTimeSeconds != 12*60 {
+		t.Errorf("verification %+v, want 12 minutes of lead time", alert.Verification)
+	}
+}
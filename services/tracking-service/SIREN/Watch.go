@@ -0,0 +1,180 @@
+package SIREN
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"trackingService/NWS"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// An SPC watch, put together from the SEL, WWP, WOU and SAW products that describe it.
+// The county based watch CAPs each office sends become SIREN events that share the watch
+// number as their ETN, and point back here with their Watch field.
+type Watch struct {
+	Identifier    string                  `bson:"identifier"` // e.g. WW-123-2025
+	Number        int                     `bson:"number"`
+	Year          int                     `bson:"year"`
+	Type          string                  `bson:"type"`
+	PDS           bool                    `bson:"pds"`
+	Issued        time.Time               `bson:"issued,omitempty"`
+	Expires       time.Time               `bson:"expires,omitempty"`
+	VTEC          string                  `bson:"vtec,omitempty"`
+	UGCs          []string                `bson:"ugcs,omitempty"`
+	Polygon       [][2]float64            `bson:"polygon,omitempty"` // The watch parallelogram, lon/lat
+	Probabilities *NWS.WatchProbabilities `bson:"probabilities,omitempty"`
+	Attributes    *NWS.WatchAttributes    `bson:"attributes,omitempty"`
+	Discussions   []WatchDiscussion       `bson:"discussions,omitempty"`
+	Products      []string                `bson:"products"`
+	Headline      string                  `bson:"headline"`
+	UpdatedAt     time.Time               `bson:"updatedAt"`
+}
+
+// A Mesoscale Discussion issued about a watch
+type WatchDiscussion struct {
+	Number     int       `bson:"number"`
+	ProductKey string    `bson:"productKey"`
+	Concerning string    `bson:"concerning"`
+	ValidFrom  time.Time `bson:"validFrom,omitempty"`
+	ValidUntil time.Time `bson:"validUntil,omitempty"`
+}
+
+func WatchIdentifier(number int, year int) string {
+	return fmt.Sprintf("WW-%d-%d", number, year)
+}
+
+// The watch a TO.A or SV.A event belongs to, or "" for any other event
+func WatchForEvent(vtec *NWS.VTEC) string {
+	if vtec.Significance != "A" || (vtec.Phenomena != "TO" && vtec.Phenomena != "SV") {
+		return ""
+	}
+	year, _ := strconv.Atoi(vtec.EndDateTime.Format("2006"))
+	return WatchIdentifier(vtec.EventTrackingNumber, year)
+}
+
+// Headline for the dashboard, e.g. Tornado Watch 123: 70% chance of 2+ tornadoes
+func WatchHeadline(watch Watch) string {
+	headline := fmt.Sprintf("%s Watch %d", watch.Type, watch.Number)
+	if watch.PDS {
+		headline = "PDS " + headline
+	}
+	p := watch.Probabilities
+	if p == nil {
+		return headline
+	}
+
+	if watch.Type == "Tornado" {
+		return fmt.Sprintf("%s: %s chance of 2+ tornadoes", headline, p.Tornadoes)
+	}
+	if p.Hail.Percent > p.Wind.Percent {
+		return fmt.Sprintf("%s: %s chance of 10+ severe hail reports", headline, p.Hail)
+	}
+	return fmt.Sprintf("%s: %s chance of 10+ severe wind reports", headline, p.Wind)
+}
+
+/**============================================
+ *               Watch Storage
+ *=============================================**/
+
+// WatchStore merges the SPC products about each watch into the watches collection
+type WatchStore struct {
+	Watches *mongo.Collection
+}
+
+// Record merges what the product says about the watch into it, creating it if this is the
+// first product about it, and returns the watch as it now stands
+func (s *WatchStore) Record(ctx context.Context, productKey string, issued time.Time, watch NWS.Watch) (Watch, error) {
+	// Watch events take their year from when they end, like the SIREN identifiers
+	year := issued.Year()
+	if !watch.Expires.IsZero() {
+		year = watch.Expires.Year()
+	}
+	identifier := WatchIdentifier(watch.Number, year)
+
+	set := bson.M{"updatedAt": time.Now()}
+	if watch.PDS {
+		set["pds"] = true
+	}
+	if !watch.Issued.IsZero() {
+		set["issued"] = watch.Issued
+	}
+	if !watch.Expires.IsZero() {
+		set["expires"] = watch.Expires
+	}
+	if watch.VTEC != "" {
+		set["vtec"] = watch.VTEC
+	}
+	if len(watch.UGCs) > 0 {
+		set["ugcs"] = watch.UGCs
+	}
+	if len(watch.Polygon) > 0 {
+		set["polygon"] = watch.Polygon
+	}
+	if watch.Probabilities != nil {
+		set["probabilities"] = watch.Probabilities
+	}
+	if watch.Attributes != nil {
+		set["attributes"] = watch.Attributes
+	}
+
+	var stored Watch
+	err := s.Watches.FindOneAndUpdate(ctx,
+		bson.M{"identifier": identifier},
+		bson.M{
+			"$set": set,
+			"$setOnInsert": bson.M{
+				"identifier": identifier,
+				"number":     watch.Number,
+				"year":       year,
+				"type":       watch.Type,
+			},
+			"$addToSet": bson.M{"products": productKey},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	if err != nil {
+		return Watch{}, err
+	}
+
+	if headline := WatchHeadline(stored); headline != stored.Headline {
+		stored.Headline = headline
+		_, err = s.Watches.UpdateOne(ctx, bson.M{"identifier": identifier}, bson.M{"$set": bson.M{"headline": headline}})
+	}
+	return stored, err
+}
+
+// RecordDiscussion adds the Mesoscale Discussion to the watches it is about, returning
+// the identifiers of the watches that were found
+func (s *WatchStore) RecordDiscussion(ctx context.Context, productKey string, issued time.Time, mcd NWS.MesoscaleDiscussion) ([]string, error) {
+	discussion := WatchDiscussion{
+		Number:     mcd.Number,
+		ProductKey: productKey,
+		Concerning: mcd.Concerning,
+		ValidFrom:  mcd.ValidFrom,
+		ValidUntil: mcd.ValidUntil,
+	}
+
+	var updated []string
+	for _, number := range mcd.Watches {
+		// Discussions about a watch are written while it is in effect, so it ends this year or the next
+		for _, year := range []int{issued.Year(), issued.Year() + 1} {
+			identifier := WatchIdentifier(number, year)
+			result, err := s.Watches.UpdateOne(ctx,
+				bson.M{"identifier": identifier, "discussions.productKey": bson.M{"$ne": productKey}},
+				bson.M{"$push": bson.M{"discussions": discussion}},
+			)
+			if err != nil {
+				return updated, err
+			}
+			if result.MatchedCount > 0 {
+				updated = append(updated, identifier)
+				break
+			}
+		}
+	}
+	return updated, nil
+}
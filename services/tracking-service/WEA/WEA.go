@@ -0,0 +1,319 @@
+package WEA
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"trackingService/NWS"
+)
+
+// Message lengths phones display, the original 90 character limit and the 360 character
+// messages WEA 3.0 handsets receive alongside it
+const (
+	ShortLength = 90
+	LongLength  = 360
+)
+
+const (
+	English = "en-US"
+	Spanish = "es-US"
+)
+
+// WEA alert classes, which decide the banner phones show and whether users can opt out
+const (
+	ClassPresidential = "Presidential"
+	ClassExtreme      = "Extreme"
+	ClassSevere       = "Severe"
+	ClassPublicSafety = "Public Safety"
+	ClassAmber        = "AMBER"
+)
+
+var ErrBlocked = errors.New("WEA is blocked for this alert")
+var ErrNotEligible = errors.New("alert is not eligible for WEA")
+
+// Message is the text phones receive for an alert in one language
+type Message struct {
+	Language  string `msgpack:"language"`
+	Class     string `msgpack:"class"`
+	Short     string `msgpack:"short"`               // At most 90 characters
+	Long      string `msgpack:"long"`                // At most 360 characters
+	Generated bool   `msgpack:"generated,omitempty"` // The alert had no CMAM text of its own
+}
+
+// Render builds the WEA messages for an alert, English first then Spanish. The CMAM text NWS
+// writes is used as is, alerts without it, or without a Spanish info block for the Spanish
+// message, get text in the style of the NWS templates from their event, area and expiry.
+func Render(alert NWS.Alert) ([]Message, error) {
+	if alert.Status != "Actual" || alert.MsgType == "Cancel" {
+		return nil, ErrNotEligible
+	}
+	params := alert.Info.Parameters
+	if params != nil && params.BlockChannels.CMAS {
+		return nil, ErrBlocked
+	}
+
+	class, ok := Class(alert)
+	if !ok {
+		return nil, ErrNotEligible
+	}
+
+	spanish := alert.Info
+	if alert.InfoSpanish != nil {
+		spanish = *alert.InfoSpanish
+	} else {
+		// Without a Spanish info block the English CMAM text doesn't apply, the fallback is written instead
+		spanish.Parameters = nil
+	}
+	return []Message{
+		render(alert, alert.Info, English, class),
+		render(alert, spanish, Spanish, class),
+	}, nil
+}
+
+// The NWS events that go out over WEA, severe thunderstorm warnings only when destructive
+var weaEvents = map[string]bool{
+	"TOR": true,
+	"FFW": true,
+	"EWW": true,
+	"SQW": true,
+	"DSW": true,
+	"HUW": true,
+	"SSW": true,
+	"TSW": true,
+}
+
+// Whether NWS sends the alert over WEA, it wrote CMAM text for it or the event is one that goes out
+func weaEvent(alert NWS.Alert) bool {
+	params := alert.Info.Parameters
+	if params != nil && (params.CMAMtext != "" || params.CMAMlongtext != "") {
+		return true
+	}
+	code := alert.Info.EventCode.NWS
+	if code == "SVR" {
+		return params != nil && strings.EqualFold(params.ThunderstormDamageThreat, "DESTRUCTIVE")
+	}
+	return weaEvents[code]
+}
+
+// Class picks the WEA class from the handling code, or from the CAP's urgency, severity and
+// certainty when there is no code. Without a code only the events NWS sends over WEA are
+// eligible, and only when they reach the imminent threat criteria.
+func Class(alert NWS.Alert) (string, bool) {
+	if params := alert.Info.Parameters; params != nil && params.WEAHandlingCode != "" {
+		switch strings.ToLower(params.WEAHandlingCode) {
+		case "presidential":
+			return ClassPresidential, true
+		case "public safety":
+			return ClassPublicSafety, true
+		case "child abduction", "amber":
+			return ClassAmber, true
+		case "imminent threat":
+			if alert.Info.Severity == "Extreme" {
+				return ClassExtreme, true
+			}
+			return ClassSevere, true
+		}
+	}
+
+	if !weaEvent(alert) {
+		return "", false
+	}
+	urgent := alert.Info.Urgency == "Immediate" || alert.Info.Urgency == "Expected"
+	certain := alert.Info.Certainty == "Observed" || alert.Info.Certainty == "Likely"
+	if !urgent || !certain {
+		return "", false
+	}
+	switch alert.Info.Severity {
+	case "Extreme":
+		return ClassExtreme, true
+	case "Severe":
+		return ClassSevere, true
+	}
+	return "", false
+}
+
+func render(alert NWS.Alert, info NWS.Info, language string, class string) Message {
+	message := Message{Language: language, Class: class}
+
+	var short, long string
+	if info.Parameters != nil {
+		short = strings.TrimSpace(info.Parameters.CMAMtext)
+		long = strings.TrimSpace(info.Parameters.CMAMlongtext)
+	}
+	if short == "" {
+		short = fallback(alert, info, language, ShortLength)
+		message.Generated = true
+	}
+	if long == "" {
+		long = fallback(alert, info, language, LongLength)
+	}
+
+	message.Short = Truncate(short, ShortLength)
+	message.Long = Truncate(long, LongLength)
+	return message
+}
+
+/* ---- Fallback Text ---- */
+
+type phrases struct {
+	sender   string
+	inArea   string
+	forAreas string
+	until    string
+	closing  string // Last line of every message
+}
+
+var languagePhrases = map[string]phrases{
+	English: {sender: "NWS", inArea: "in this area", forAreas: "for", until: "until", closing: "Check media."},
+	Spanish: {sender: "SNM", inArea: "en esta área", forAreas: "para", until: "hasta las", closing: "Consulte los medios."},
+}
+
+// What to do, by NWS event code
+var actions = map[string]map[string]string{
+	English: {
+		"TOR": "Take shelter now.",
+		"FFW": "Avoid flooded areas.",
+		"EWW": "Take shelter now.",
+		"SQW": "Avoid travel.",
+		"DSW": "Avoid travel.",
+		"HUW": "Follow evacuation orders.",
+		"SSW": "Follow evacuation orders.",
+		"TSW": "Move to high ground now.",
+		"SVR": "Seek shelter indoors.",
+	},
+	Spanish: {
+		"TOR": "Busque refugio ahora.",
+		"FFW": "Evite zonas inundadas.",
+		"EWW": "Busque refugio ahora.",
+		"SQW": "Evite viajar.",
+		"DSW": "Evite viajar.",
+		"HUW": "Siga órdenes de evacuación.",
+		"SSW": "Siga órdenes de evacuación.",
+		"TSW": "Vaya a terreno alto ahora.",
+		"SVR": "Busque refugio bajo techo.",
+	},
+}
+
+// Event names for Spanish alerts that don't come with their own
+var spanishEvents = map[string]string{
+	"TOR": "Aviso de Tornado",
+	"FFW": "Aviso de Inundación Repentina",
+	"EWW": "Aviso de Vientos Extremos",
+	"SQW": "Aviso de Ráfagas de Nieve",
+	"DSW": "Aviso de Tormenta de Polvo",
+	"HUW": "Aviso de Huracán",
+	"SSW": "Aviso de Marejada Ciclónica",
+	"TSW": "Aviso de Tsunami",
+	"SVR": "Aviso de Tronada Severa",
+}
+
+// fallback writes text like the NWS templates,
+// NWS: TORNADO WARNING in this area until 6:15 PM CDT. Take shelter now. Check media.
+// The longer message names the areas instead, as many as fit.
+func fallback(alert NWS.Alert, info NWS.Info, language string, limit int) string {
+	p := languagePhrases[language]
+	code := info.EventCode.NWS
+	if code == "" {
+		code = alert.Info.EventCode.NWS
+	}
+
+	event := info.Event
+	if language == Spanish && (event == "" || event == alert.Info.Event) {
+		if name, ok := spanishEvents[code]; ok {
+			event = name
+		}
+	}
+	event = strings.ToUpper(event)
+
+	expires := ""
+	if !info.Expires.IsZero() {
+		expires = fmt.Sprintf(" %s %s", p.until, localTime(info.Expires, alert.Info.Headline))
+	}
+	// The closing line goes first when there isn't room, then the action
+	action := actions[language][code]
+	closing := strings.TrimSpace(action + " " + p.closing)
+	for _, shorter := range []string{action, ""} {
+		if len([]rune(fmt.Sprintf("%s: %s %s%s. %s", p.sender, event, p.inArea, expires, closing))) <= limit {
+			break
+		}
+		closing = shorter
+	}
+
+	message := func(areas []string) string {
+		where := p.inArea
+		if len(areas) > 0 {
+			where = p.forAreas + " " + strings.Join(areas, ", ")
+		}
+		return strings.TrimSpace(fmt.Sprintf("%s: %s %s%s. %s", p.sender, event, where, expires, closing))
+	}
+
+	text := message(nil)
+	if limit > ShortLength {
+		var areas []string
+		for _, area := range strings.Split(info.Area.Description, ";") {
+			name, _, _ := strings.Cut(strings.TrimSpace(area), ",")
+			if name == "" {
+				continue
+			}
+			candidate := message(append(areas, name))
+			if len([]rune(candidate)) > limit {
+				break
+			}
+			areas = append(areas, name)
+			text = candidate
+		}
+	}
+	return text
+}
+
+// Offsets of the time zones NWS headlines are written in
+var zoneOffsets = map[string]int{
+	"AST":  -4,
+	"ADT":  -3,
+	"EST":  -5,
+	"EDT":  -4,
+	"CST":  -6,
+	"CDT":  -5,
+	"MST":  -7,
+	"MDT":  -6,
+	"PST":  -8,
+	"PDT":  -7,
+	"AKST": -9,
+	"AKDT": -8,
+	"HST":  -10,
+	"SST":  -11,
+	"CHST": 10,
+}
+
+// Headlines give their times like "until June 15 at 6:00PM CDT"
+var headlineZoneRE = regexp.MustCompile(`\d{1,2}:\d{2}\s?[AP]M ([A-Z]{3,4})\b`)
+
+// The time in the zone the alert's headline is written in, like 6:15 PM CDT. CAP times lose
+// their offset on the way through the queue, so the headline is the only place left with it.
+func localTime(t time.Time, headline string) string {
+	if m := headlineZoneRE.FindStringSubmatch(headline); m != nil {
+		if offset, ok := zoneOffsets[m[1]]; ok {
+			return t.In(time.FixedZone(m[1], offset*3600)).Format("3:04 PM MST")
+		}
+	}
+	return t.UTC().Format("3:04 PM") + " UTC"
+}
+
+// Truncate cuts text to the limit in characters at a word boundary
+func Truncate(text string, limit int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+	cut := string(runes[:limit])
+	if runes[limit] == ' ' {
+		// The limit falls between words, so the last word is whole
+		return strings.TrimRight(cut, " ,;")
+	}
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;")
+}
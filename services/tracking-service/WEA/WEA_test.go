@@ -0,0 +1,288 @@
+package WEA
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"trackingService/NWS"
+	"unicode/utf8"
+)
+
+// A tornado warning for the given areas, expiring at 6:45 PM CDT
+func tornadoWarning(areas ...string) NWS.Alert {
+	return NWS.Alert{
+		Status:  "Actual",
+		MsgType: "Alert",
+		Info: NWS.Info{
+			Event:     "Tornado Warning",
+			Urgency:   "Immediate",
+			Severity:  "Extreme",
+			Certainty: "Observed",
+			EventCode: NWS.EventCode{SAME: "TOR", NWS: "TOR"},
+			Expires:   time.Date(2025, 6, 15, 23, 45, 0, 0, time.UTC),
+			Headline:  "Tornado Warning issued June 15 at 6:10PM CDT until June 15 at 6:45PM CDT by NWS Des Moines IA",
+			Area:      NWS.Area{Description: strings.Join(areas, "; ")},
+		},
+	}
+}
+
+func withCMAM(alert NWS.Alert, short string, long string) NWS.Alert {
+	alert.Info.Parameters = &NWS.Parameters{CMAMtext: short, CMAMlongtext: long}
+	return alert
+}
+
+func TestRenderFallback(t *testing.T) {
+	messages, err := Render(tornadoWarning("Polk, IA", "Story, IA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want English and Spanish", len(messages))
+	}
+
+	tests := []struct {
+		message  Message
+		language string
+		short    string
+		long     string
+	}{
+		{
+			message:  messages[0],
+			language: English,
+			short:    "NWS: TORNADO WARNING in this area until 6:45 PM CDT. Take shelter now. Check media.",
+			long:     "NWS: TORNADO WARNING for Polk, Story until 6:45 PM CDT. Take shelter now. Check media.",
+		},
+		{
+			message:  messages[1],
+			language: Spanish,
+			short:    "SNM: AVISO DE TORNADO en esta área hasta las 6:45 PM CDT. Busque refugio ahora.",
+			long:     "SNM: AVISO DE TORNADO para Polk, Story hasta las 6:45 PM CDT. Busque refugio ahora. Consulte los medios.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			if tt.message.Language != tt.language || tt.message.Class != ClassExtreme {
+				t.Fatalf("got %s %s, want %s %s", tt.message.Language, tt.message.Class, tt.language, ClassExtreme)
+			}
+			if !tt.message.Generated {
+				t.Error("fallback text isn't marked generated")
+			}
+			if tt.message.Short != tt.short {
+				t.Errorf("short %q, want %q", tt.message.Short, tt.short)
+			}
+			if tt.message.Long != tt.long {
+				t.Errorf("long %q, want %q", tt.message.Long, tt.long)
+			}
+		})
+	}
+}
+
+func TestRenderSpanish(t *testing.T) {
+	english := withCMAM(tornadoWarning("Polk, IA"), "Tornado Warning in this area til 6:45 PM CDT. Take shelter now. Check media. -NWS", "")
+
+	t.Run("no Spanish info", func(t *testing.T) {
+		messages, err := Render(english)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 2 || messages[1].Language != Spanish {
+			t.Fatalf("got %+v, want a Spanish message", messages)
+		}
+		// The English CMAM text is no use to a Spanish reader
+		if !messages[1].Generated || !strings.HasPrefix(messages[1].Short, "SNM: AVISO DE TORNADO") {
+			t.Errorf("Spanish short %q, want the generated fallback", messages[1].Short)
+		}
+		if messages[0].Generated || messages[0].Short != english.Info.Parameters.CMAMtext {
+			t.Errorf("English short %q, want the CMAM text", messages[0].Short)
+		}
+	})
+
+	t.Run("Spanish info", func(t *testing.T) {
+		alert := english
+		spanish := alert.Info
+		spanish.Language = Spanish
+		spanish.Event = "Aviso de Tornado"
+		spanish.Parameters = &NWS.Parameters{CMAMtext: "Aviso de Tornado en esta area hasta 6:45 PM CDT. Busque refugio. -SNM"}
+		alert.InfoSpanish = &spanish
+
+		messages, err := Render(alert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 2 || messages[1].Generated || messages[1].Short != spanish.Parameters.CMAMtext {
+			t.Fatalf("got %+v, want the Spanish CMAM text", messages)
+		}
+	})
+}
+
+func TestRenderLimits(t *testing.T) {
+	var counties []string
+	for i := range 60 {
+		counties = append(counties, fmt.Sprintf("County%02d, IA", i))
+	}
+	long := strings.Repeat("Take shelter in a basement or an interior room on the lowest floor. ", 10)
+
+	tests := []struct {
+		name  string
+		alert NWS.Alert
+	}{
+		{"fallback with many areas", tornadoWarning(counties...)},
+		{"CMAM text too long", withCMAM(tornadoWarning("Polk, IA"), long, long)},
+		{"unknown event with a handling code", func() NWS.Alert {
+			alert := tornadoWarning(counties...)
+			alert.Info.Event = strings.Repeat("Extremely Long Event Name ", 5)
+			alert.Info.EventCode.NWS = "XXX"
+			alert.Info.Parameters = &NWS.Parameters{WEAHandlingCode: "Imminent Threat"}
+			return alert
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := Render(tt.alert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, message := range messages {
+				if n := utf8.RuneCountInString(message.Short); n > ShortLength || n == 0 {
+					t.Errorf("%s short is %d characters: %q", message.Language, n, message.Short)
+				}
+				if n := utf8.RuneCountInString(message.Long); n > LongLength || n == 0 {
+					t.Errorf("%s long is %d characters: %q", message.Language, n, message.Long)
+				}
+			}
+		})
+	}
+}
+
+func TestAreaPacking(t *testing.T) {
+	var counties, names []string
+	for i := range 60 {
+		name := fmt.Sprintf("County%02d", i)
+		names = append(names, name)
+		counties = append(counties, name+", IA")
+	}
+
+	messages, err := Render(tornadoWarning(counties...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		t.Run(message.Language, func(t *testing.T) {
+			// The areas are listed in order, as many as fit, and the rest of the message is kept
+			packed := 0
+			for packed < len(names) && strings.Contains(message.Long, names[packed]) {
+				packed++
+			}
+			if packed == 0 || packed == len(names) {
+				t.Fatalf("packed %d of %d areas: %q", packed, len(names), message.Long)
+			}
+			if strings.Contains(message.Long, names[packed]) || strings.Contains(message.Long, ", IA") {
+				t.Errorf("areas are out of order or keep their state: %q", message.Long)
+			}
+			if room := LongLength - utf8.RuneCountInString(message.Long); room >= len(", ")+len(names[packed]) {
+				t.Errorf("%d characters left, enough for another area: %q", room, message.Long)
+			}
+			if !strings.HasSuffix(message.Long, languagePhrases[message.Language].closing) {
+				t.Errorf("long %q lost its closing line", message.Long)
+			}
+			// The short message never lists areas
+			if !strings.Contains(message.Short, languagePhrases[message.Language].inArea) {
+				t.Errorf("short %q doesn't say in this area", message.Short)
+			}
+		})
+	}
+}
+
+func TestRenderNotEligible(t *testing.T) {
+	cancel := tornadoWarning("Polk, IA")
+	cancel.MsgType = "Cancel"
+	test := tornadoWarning("Polk, IA")
+	test.Status = "Test"
+	advisory := tornadoWarning("Polk, IA")
+	advisory.Info.Severity = "Minor"
+	blocked := tornadoWarning("Polk, IA")
+	blocked.Info.Parameters = &NWS.Parameters{BlockChannels: NWS.BlockChannels{CMAS: true}}
+	highWind := tornadoWarning("Polk, IA")
+	highWind.Info.Event = "High Wind Warning"
+	highWind.Info.EventCode = NWS.EventCode{SAME: "HWW", NWS: "HWW"}
+	highWind.Info.Severity, highWind.Info.Urgency, highWind.Info.Certainty = "Severe", "Expected", "Likely"
+	thunderstorm := tornadoWarning("Polk, IA")
+	thunderstorm.Info.Event = "Severe Thunderstorm Warning"
+	thunderstorm.Info.EventCode = NWS.EventCode{SAME: "SVR", NWS: "SVR"}
+	thunderstorm.Info.Severity = "Severe"
+	thunderstorm.Info.Parameters = &NWS.Parameters{ThunderstormDamageThreat: "CONSIDERABLE"}
+
+	tests := []struct {
+		name  string
+		alert NWS.Alert
+		err   error
+	}{
+		{"cancel", cancel, ErrNotEligible},
+		{"test", test, ErrNotEligible},
+		{"minor", advisory, ErrNotEligible},
+		{"blocked", blocked, ErrBlocked},
+		{"severe warning phones don't get", highWind, ErrNotEligible},
+		{"severe thunderstorm, not destructive", thunderstorm, ErrNotEligible},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Render(tt.alert); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  string
+	}{
+		{"short enough", 90, "short enough"},
+		{"  extra   spaces\n collapse ", 90, "extra spaces collapse"},
+		{"cut at the last word boundary", 20, "cut at the last word"},
+		{"áéíóú áéíóú áéíóú", 11, "áéíóú áéíóú"},
+		{"Evacuate now, flooding", 14, "Evacuate now"},
+		{"Unbrokenwordthatistoolong", 10, "Unbrokenwo"},
+	}
+	for _, tt := range tests {
+		if got := Truncate(tt.text, tt.limit); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestClassEligibleEvents(t *testing.T) {
+	destructive := tornadoWarning("Polk, IA")
+	destructive.Info.EventCode = NWS.EventCode{SAME: "SVR", NWS: "SVR"}
+	destructive.Info.Severity = "Severe"
+	destructive.Info.Parameters = &NWS.Parameters{ThunderstormDamageThreat: "DESTRUCTIVE"}
+	withText := withCMAM(tornadoWarning("Polk, IA"), "Extreme heat in this area. -NWS", "")
+	withText.Info.EventCode = NWS.EventCode{SAME: "EHW", NWS: "EHW"}
+	handled := tornadoWarning("Polk, IA")
+	handled.Info.EventCode = NWS.EventCode{SAME: "HWW", NWS: "HWW"}
+	handled.Info.Severity = "Minor"
+	handled.Info.Parameters = &NWS.Parameters{WEAHandlingCode: "Imminent Threat"}
+
+	tests := []struct {
+		name  string
+		alert NWS.Alert
+		class string
+	}{
+		{"tornado", tornadoWarning("Polk, IA"), ClassExtreme},
+		{"destructive thunderstorm", destructive, ClassSevere},
+		{"CMAM text for another event", withText, ClassExtreme},
+		{"handling code", handled, ClassSevere},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class, ok := Class(tt.alert); !ok || class != tt.class {
+				t.Errorf("got %q %v, want %q", class, ok, tt.class)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/**========================================================================
+ *  						  LSR Replay
+ *  							SIREN
+ *
+ *  Matches stored Local Storm Reports against the tracked events again,
+ *  for post-event review or after the events were reprocessed.
+ *
+ *  go run ./cmd/lsr-replay -from 2025-06-15 -to 2025-06-16
+ *
+ *  Only MongoDB is needed, the products are read from the products
+ *  collection the tracking service fills as they arrive.
+ *========================================================================**/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+	"trackingService/NWS"
+	"trackingService/SIREN"
+
+	"github.com/charmbracelet/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type storedProduct struct {
+	Key     string      `bson:"key"`
+	Product NWS.Product `bson:"product"`
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+// Like parseTime, but a date on its own means the end of that day
+func parseEndTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return parseTime(s)
+}
+
+func main() {
+	from := flag.String("from", "", "start of the replay, RFC 3339 or YYYY-MM-DD (default 24 hours ago)")
+	to := flag.String("to", "", "end of the replay, RFC 3339 or YYYY-MM-DD for the end of that day (default now)")
+	office := flag.String("office", "", "only replay reports from this office, e.g. DMX")
+	flag.Parse()
+
+	end := time.Now().UTC()
+	start := end.Add(-24 * time.Hour)
+	var err error
+	if *from != "" {
+		if start, err = parseTime(*from); err != nil {
+			log.Fatal("Invalid -from", "err", err)
+		}
+	}
+	if *to != "" {
+		if end, err = parseEndTime(*to); err != nil {
+			log.Fatal("Invalid -to", "err", err)
+		}
+	}
+
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatal("Failed to connect to MongoDB", "err", err)
+	}
+	defer client.Disconnect(context.TODO())
+
+	db := client.Database("siren")
+	correlator := &SIREN.Correlator{
+		State:  db.Collection("state"),
+		Alerts: db.Collection("alerts"),
+	}
+
+	filter := bson.M{
+		"product.category": "LSR",
+		"product.issued":   bson.M{"$gte": start, "$lte": end},
+	}
+	if *office != "" {
+		filter["product.office"] = *office
+	}
+
+	ctx := context.Background()
+	cursor, err := db.Collection("products").Find(ctx, filter, options.Find().SetSort(bson.M{"product.issued": 1}))
+	if err != nil {
+		log.Fatal("Failed to read stored products", "err", err)
+	}
+	var products []storedProduct
+	if err := cursor.All(ctx, &products); err != nil {
+		log.Fatal("Failed to decode stored products", "err", err)
+	}
+
+	reports, unmatched := 0, 0
+	events := make(map[string]bool)
+	for _, stored := range products {
+		for _, report := range stored.Product.LSRs {
+			reports++
+			matched, err := correlator.Correlate(ctx, stored.Key, report)
+			if err != nil {
+				log.Error("Failed to correlate storm report", "key", stored.Key, "event", report.Event, "err", err)
+				continue
+			}
+			if len(matched) == 0 {
+				unmatched++
+			}
+			for _, id := range matched {
+				events[id] = true
+			}
+		}
+	}
+	log.Info("Replay complete", "products", len(products), "reports", reports, "unmatched", unmatched, "events", len(events))
+
+	ids := make([]string, 0, len(events))
+	for id := range events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var alert SIREN.SirenAlert
+		if err := correlator.State.FindOne(ctx, bson.M{"identifier": id}).Decode(&alert); err != nil {
+			log.Error("Failed to read event", "id", id, "err", err)
+			continue
+		}
+		verification := SIREN.Verify(alert)
+		lead := time.Duration(verification.LeadTimeSeconds) * time.Second
+		fmt.Printf("%-28s reports=%-3d verified=%-5t lead=%s\n", id, verification.Reports, verification.Verified, lead)
+	}
+}
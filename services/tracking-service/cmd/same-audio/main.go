@@ -0,0 +1,66 @@
+/**========================================================================
+ *  						  SAME Audio
+ *  							SIREN
+ *
+ *  Writes a NOAA Weather Radio style WAV file for a SAME header, to test
+ *  encoders and decoders without waiting for a real alert.
+ *
+ *  go run ./cmd/same-audio -header "ZCZC-WXR-TOR-019169+0045-1662315-KDMX/NWS-" -o tor.wav
+ *
+ *  The spoken part is a placeholder as long as -text would take to read,
+ *  silent or a quiet tone with -tone.
+ *========================================================================**/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"os"
+	"time"
+	"trackingService/Audio"
+	"trackingService/SAME"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	header := flag.String("header", "", "SAME header to encode, e.g. ZCZC-WXR-RWT-019169+0030-1662315-KDMX/NWS-")
+	text := flag.String("text", "This is a test of the SIREN broadcast audio.", "message text, sets the length of the spoken slot")
+	tone := flag.Float64("tone", 0, "frequency of the placeholder tone in Hz, 0 for silence")
+	attention := flag.Duration("attention", Audio.DefaultAttentionTone, "length of the 1050 Hz attention tone")
+	output := flag.String("o", "same.wav", "WAV file to write")
+	flag.Parse()
+
+	if *header == "" {
+		log.Fatal("A -header is required")
+	}
+	decoded, err := SAME.Decode(*header, time.Now())
+	if err != nil {
+		log.Fatal("Invalid SAME header", "err", err)
+	}
+	log.Info("Encoding header", "originator", decoded.Originator, "event", decoded.Event,
+		"locations", decoded.Locations, "purge", decoded.Purge, "issued", decoded.Issued, "sender", decoded.Sender)
+
+	msg := Audio.Message{Header: *header, Text: *text, AttentionTone: *attention}
+	samples, err := Audio.Synthesize(context.Background(), msg, Audio.PlaceholderSpeaker{Tone: *tone})
+	if err != nil {
+		log.Fatal("Failed to synthesize audio", "err", err)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatal("Failed to create output file", "err", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := Audio.WriteWAV(writer, samples, Audio.SampleRate); err != nil {
+		log.Fatal("Failed to write WAV", "err", err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatal("Failed to write WAV", "err", err)
+	}
+	log.Info("Wrote broadcast audio", "file", *output, "seconds", float64(len(samples))/Audio.SampleRate)
+}
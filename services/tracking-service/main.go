@@ -6,16 +6,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"trackingService/Audio"
 	"trackingService/NWS"
+	"trackingService/SAME"
 	"trackingService/SIREN"
+	"trackingService/WEA"
 
 	"github.com/charmbracelet/log"
 	"github.com/joho/godotenv"
@@ -27,6 +34,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/vmihailenco/msgpack"
 )
 
@@ -52,6 +64,49 @@ var alertsProcessed = prometheus.NewCounter(prometheus.CounterOpts{
 	Help: "Total number of alerts processed successfully",
 })
 
+var alertsDuplicate = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "alerts_duplicate_total",
+	Help: "Total number of alert messages skipped because the CAP was already stored",
+})
+
+var productsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "products_received_total",
+	Help: "Total number of text products received, by AWIPS category",
+}, []string{"category"})
+
+var stormReportsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "storm_reports_received_total",
+	Help: "Total number of Local Storm Reports received",
+})
+
+var stormReportMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "storm_report_matches_total",
+	Help: "Local Storm Reports by whether they fell inside any event",
+}, []string{"matched"})
+
+var alertsEscalated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "alerts_escalated_total",
+	Help: "Events whose impact tier rose, by the tier they rose to",
+}, []string{"tier"})
+
+var watchesUpdated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "watches_updated_total",
+	Help: "SPC watch updates by the product that carried them",
+}, []string{"category"})
+
+var audioDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "alert_audio_dropped_total",
+	Help: "Alerts whose broadcast audio was skipped because the audio queue was full",
+})
+
+var lockWaitTime = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "alert_lock_wait_seconds",
+		Help:    "Time workers spend waiting for an alert lock",
+		Buckets: []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10},
+	},
+)
+
 /**============================================
  *           Message Queue Connection
  *=============================================**/
@@ -60,6 +115,10 @@ var conn *ampq.Connection
 var ch *ampq.Channel
 var trackingQueue ampq.Queue
 var liveQueue ampq.Queue
+var productsQueue ampq.Queue
+
+// MATCH_QUEUE also sends every push to the match service, which finds the subscribers it reaches
+var matchQueue string
 
 // Connect to message queue
 func connectToMQ() {
@@ -88,6 +147,18 @@ func connectToMQ() {
 		log.Fatal("Failed to declare the push queue")
 	}
 
+	//Connect to the products queue, text products from noaa-service
+	productsQueue, err = ch.QueueDeclare("products", true, false, false, false, nil)
+	if err != nil {
+		log.Fatal("Failed to declare the products queue")
+	}
+
+	if matchQueue = os.Getenv("MATCH_QUEUE"); matchQueue != "" {
+		if _, err = ch.QueueDeclare(matchQueue, true, false, false, false, nil); err != nil {
+			log.Fatal("Failed to declare the match queue", "queue", matchQueue)
+		}
+	}
+
 }
 
 /**============================================
@@ -97,6 +168,9 @@ func connectToMQ() {
 var client *mongo.Client
 var alertsCollection *mongo.Collection
 var stateCollection *mongo.Collection
+var productsCollection *mongo.Collection
+var correlator *SIREN.Correlator
+var watchStore *SIREN.WatchStore
 
 func ConnectToMongo() {
 	//Connect to MongoDB
@@ -114,6 +188,18 @@ func ConnectToMongo() {
 
 	alertsCollection = client.Database("siren").Collection("alerts")
 	stateCollection = client.Database("siren").Collection("state")
+	productsCollection = client.Database("siren").Collection("products")
+	watchStore = &SIREN.WatchStore{Watches: client.Database("siren").Collection("watches")}
+
+	correlator = &SIREN.Correlator{
+		State:  stateCollection,
+		Alerts: alertsCollection,
+		Lock: func(ctx context.Context, sirenId string) func() {
+			alertLock := getLock(sirenId)
+			alertLock.lockTraced(ctx, sirenId)
+			return alertLock.mu.Unlock
+		},
+	}
 
 }
 
@@ -142,6 +228,15 @@ func getLock(key string) *AlertLock {
 	return actual.(*AlertLock)
 }
 
+// Blocks until the lock is held, timing the wait so contention shows up in traces and metrics
+func (l *AlertLock) lockTraced(ctx context.Context, key string) {
+	_, span := tracer.Start(ctx, "alert.lock_wait", trace.WithAttributes(attribute.String("siren.id", key)))
+	start := time.Now()
+	l.mu.Lock()
+	lockWaitTime.Observe(time.Since(start).Seconds())
+	span.End()
+}
+
 func cleanupAlertLocker(expiration time.Duration) {
 	timer := time.NewTicker(expiration)
 	defer timer.Stop()
@@ -173,6 +268,14 @@ func init() {
 	prometheus.MustRegister(processingTime)
 	prometheus.MustRegister(alertsReceived)
 	prometheus.MustRegister(alertsProcessed)
+	prometheus.MustRegister(alertsDuplicate)
+	prometheus.MustRegister(lockWaitTime)
+	prometheus.MustRegister(productsReceived)
+	prometheus.MustRegister(stormReportsReceived)
+	prometheus.MustRegister(stormReportMatches)
+	prometheus.MustRegister(watchesUpdated)
+	prometheus.MustRegister(alertsEscalated)
+	prometheus.MustRegister(audioDropped)
 }
 
 func main() {
@@ -185,6 +288,9 @@ func main() {
 	}
 
 	log.Print("Starting tracking service...")
+
+	shutdownTracing := initTracing("tracking-service")
+	defer shutdownTracing(context.Background())
 	log.Print("Connecting to message queue and MongoDB...")
 
 	connectToMQ()
@@ -207,6 +313,30 @@ func main() {
 		log.Fatal("Failed to consume messages from the tracking queue")
 	}
 
+	products, err := ch.Consume(productsQueue.Name, "", true, false, false, false, nil)
+	if err != nil {
+		log.Fatal("Failed to consume messages from the products queue")
+	}
+
+	if audioDir = os.Getenv("AUDIO_DIR"); audioDir != "" {
+		if err := os.MkdirAll(audioDir, 0o755); err != nil {
+			log.Fatal("Failed to create the audio directory", "dir", audioDir, "err", err)
+		}
+		retention := 24 * time.Hour
+		if value := os.Getenv("AUDIO_RETENTION"); value != "" {
+			if retention, err = time.ParseDuration(value); err != nil {
+				log.Fatal("Invalid AUDIO_RETENTION", "value", value, "err", err)
+			}
+		}
+		// Synthesis takes far longer than tracking an alert, so it gets a worker of its own
+		go func() {
+			for job := range audioJobs {
+				writeAlertAudio(job.ctx, job.sirenId, job.alert)
+			}
+		}()
+		go pruneAlertAudio(retention)
+	}
+
 	forever := make(chan bool)
 
 	// Start a goroutine to cleanup the alert locker every 5 minutes
@@ -219,11 +349,18 @@ func main() {
 	for i := 0; i < 10; i++ {
 		go func(workerID int) {
 			for d := range msgs {
-				handleAlertMessage(d.Body, workerID)
+				handleAlertMessage(d, workerID)
 			}
 		}(i)
 	}
 
+	// Products are far less frequent than alerts, a single worker keeps up
+	go func() {
+		for d := range products {
+			handleProductMessage(d)
+		}
+	}()
+
 	// This server is used to expose the metrics to Prometheus
 	http.Handle("/metrics", promhttp.Handler())
 	go func() {
@@ -254,12 +391,17 @@ func findReference(ctx context.Context, reference NWS.Reference, sirenId string,
 	}
 	visited[reference.Identifier] = true
 
+	ctx, span := tracer.Start(ctx, "alert.resolve_reference", trace.WithAttributes(attribute.String("cap.identifier", reference.Identifier)))
+	defer span.End()
+
 	var referencedAlert NWS.CapResponseData
 
 	//Check if the reference is already in the database
 	var hasReference bool = true
 	var existingAlert NWS.Alert
-	err := alertsCollection.FindOne(ctx, bson.M{"identifier": reference.Identifier}).Decode(&existingAlert)
+	findCtx, findSpan := startMongoSpan(ctx, "findOne", alertsCollection)
+	err := alertsCollection.FindOne(findCtx, bson.M{"identifier": reference.Identifier}).Decode(&existingAlert)
+	endSpan(findSpan, err)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			hasReference = false
@@ -283,7 +425,9 @@ func findReference(ctx context.Context, reference NWS.Reference, sirenId string,
 			},
 		}
 	} else {
-		err := SIREN.GetJSON(ctx, fmt.Sprintf("https://api.weather.gov/alerts/%s", reference.Identifier), &referencedAlert)
+		fetchCtx, fetchSpan := tracer.Start(ctx, "nws.fetch_alert", trace.WithSpanKind(trace.SpanKindClient))
+		err := SIREN.GetJSON(fetchCtx, fmt.Sprintf("https://api.weather.gov/alerts/%s", reference.Identifier), &referencedAlert)
+		endSpan(fetchSpan, err)
 		if err != nil {
 			debugLog(fmt.Sprintf("Failed to fetch referenced alert: %s", err))
 			return nil
@@ -347,10 +491,13 @@ func findReference(ctx context.Context, reference NWS.Reference, sirenId string,
 }
 
 // Recitifies the history and areas for a given alert using CAP references
-func findAlertHistory(cap NWS.Alert, vtec *NWS.VTEC, currentHistory []string, workerId int) SIREN.Rectification {
+func findAlertHistory(ctx context.Context, cap NWS.Alert, vtec *NWS.VTEC, currentHistory []string, workerId int) SIREN.Rectification {
 	sirenId := SIREN.GetCanonicalIdentifier(vtec)
 	log.Debug("Rectifying history for alert", "sirenId", sirenId, "worker", workerId)
 
+	ctx, span := tracer.Start(ctx, "alert.resolve_references")
+	defer span.End()
+
 	var references []NWS.Reference
 	if len(cap.References) > 0 {
 		references = append(references, cap.References...)
@@ -365,9 +512,10 @@ func findAlertHistory(cap NWS.Alert, vtec *NWS.VTEC, currentHistory []string, wo
 	}
 
 	var miniCAPs []SIREN.MiniCAP
+	span.SetAttributes(attribute.Int("cap.references", len(references)))
 	for _, ref := range references {
 		// For each reference, fetch its chain of references (if any)
-		refFetch := findReference(context.TODO(), ref, sirenId, visited)
+		refFetch := findReference(ctx, ref, sirenId, visited)
 		miniCAPs = append(miniCAPs, refFetch...)
 	}
 
@@ -396,6 +544,7 @@ func findAlertHistory(cap NWS.Alert, vtec *NWS.VTEC, currentHistory []string, wo
 			AppliesTo:             miniCAP.Areas,
 			CapID:                 miniCAP.Identifier,
 			ExpiresAt:             miniCAP.Expires,
+			Sent:                  miniCAP.Sent,
 		})
 	}
 
@@ -405,7 +554,7 @@ func findAlertHistory(cap NWS.Alert, vtec *NWS.VTEC, currentHistory []string, wo
 	}
 }
 
-func handleAlertHistory(existingAlert *SIREN.SirenAlert, alert NWS.Alert, vtec *NWS.VTEC, workerId int) {
+func handleAlertHistory(ctx context.Context, existingAlert *SIREN.SirenAlert, alert NWS.Alert, vtec *NWS.VTEC, workerId int) {
 	// Create the history entry for this CAP
 	history := SIREN.SirenAlertHistory{
 		RecievedAt:            time.Now(),
@@ -413,6 +562,7 @@ func handleAlertHistory(existingAlert *SIREN.SirenAlert, alert NWS.Alert, vtec *
 		VtecAction:            vtec.Action,
 		AppliesTo:             alert.Info.Area.Geocodes.UGC,
 		CapID:                 alert.Identifier,
+		Sent:                  alert.Sent,
 	}
 	// Add the history entry to the existing alert
 	existingAlert.History = append([]SIREN.SirenAlertHistory{history}, existingAlert.History...)
@@ -426,7 +576,7 @@ func handleAlertHistory(existingAlert *SIREN.SirenAlert, alert NWS.Alert, vtec *
 
 	// Attempt to rectify any history we are missing. If were not missing anything
 	// this won't do anything, it'll run a single O(n log n) operation.
-	rectifiedHistory := findAlertHistory(alert, vtec, idsInHistory, workerId)
+	rectifiedHistory := findAlertHistory(ctx, alert, vtec, idsInHistory, workerId)
 	// Add the rectified history to the existing alert
 	existingAlert.History = append(existingAlert.History, rectifiedHistory.History...)
 
@@ -484,7 +634,7 @@ func handleAlertHistory(existingAlert *SIREN.SirenAlert, alert NWS.Alert, vtec *
 
 // Processes the alert and updates the database, this is the main meat of the alert processing logic.
 // It is run in a goroutine, and uses a mutex to ensure that only one goroutine can process a given event at a time.
-func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAlert, error) {
+func handleAlert(ctx context.Context, alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAlert, error) {
 	// Generate a unique identifier for the alert based on its VTEC
 	sirenID := SIREN.GetCanonicalIdentifier(vtec)
 
@@ -492,7 +642,7 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 	alertLock := getLock(sirenID)
 	log.Debug("Attempting to aquire mutex lock", "id", sirenID, "worker", workerId)
 	// Block until the lock becomes available and then acquire it
-	alertLock.mu.Lock()
+	alertLock.lockTraced(ctx, sirenID)
 	log.Debug("Worker has now acquired lock on alert", "id", sirenID, "worker", workerId)
 
 	// Store the starting time of the alert processing execution
@@ -522,13 +672,19 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 					AppliesTo:             alert.Info.Area.Geocodes.UGC,
 					CapID:                 alert.Identifier,
 					ExpiresAt:             alert.Info.Expires,
+					Sent:                  alert.Sent,
 				},
 			},
 			MostRecentCAP: alert.Identifier,
 			Areas:         alert.Info.Area.Geocodes.UGC,
+			TraceParent:   traceParent(ctx),
+			Watch:         SIREN.WatchForEvent(vtec),
 		}
+		SIREN.ApplyImpact(&newAlert, alert)
 
-		_, err := stateCollection.InsertOne(context.TODO(), newAlert)
+		insertCtx, insertSpan := startMongoSpan(ctx, "insertOne", stateCollection)
+		_, err := stateCollection.InsertOne(insertCtx, newAlert)
+		endSpan(insertSpan, err)
 		if err != nil {
 			log.Error("Failed to insert the alert into the database", "id", sirenID, "worker", workerId, "err", err)
 			return SIREN.SirenAlert{}, err
@@ -539,7 +695,9 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 
 	// Check if the alert is already in the database
 	var existingAlert SIREN.SirenAlert
-	err := stateCollection.FindOne(context.TODO(), bson.M{"identifier": sirenID}).Decode(&existingAlert)
+	findCtx, findSpan := startMongoSpan(ctx, "findOne", stateCollection)
+	err := stateCollection.FindOne(findCtx, bson.M{"identifier": sirenID}).Decode(&existingAlert)
+	endSpan(findSpan, err)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			// Create a new alert for us to work with
@@ -553,6 +711,7 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 				History:            []SIREN.SirenAlertHistory{},
 				Areas:              []string{},
 				MostRecentCAP:      alert.Identifier,
+				Watch:              SIREN.WatchForEvent(vtec),
 			}
 		} else {
 			log.Error("Failed to insert the alert into the database", "id", sirenID, "worker", workerId, "err", err)
@@ -561,19 +720,26 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 	}
 
 	//We'll process the history here.
-	handleAlertHistory(&existingAlert, alert, vtec, workerId)
+	handleAlertHistory(ctx, &existingAlert, alert, vtec, workerId)
 
 	//Update the most recent sent time and CAP ID
 	existingAlert.MostRecentSentTime = alert.Sent
 	existingAlert.MostRecentCAP = alert.Identifier
+	existingAlert.TraceParent = traceParent(ctx)
+	existingAlert.Watch = SIREN.WatchForEvent(vtec)
+	if SIREN.ApplyImpact(&existingAlert, alert) {
+		log.Info("Alert escalated", "id", sirenID, "tier", existingAlert.Impact.Tier, "worker", workerId)
+	}
 
 	//Upsert the alert in the database
+	updateCtx, updateSpan := startMongoSpan(ctx, "updateOne", stateCollection)
 	_, err = stateCollection.UpdateOne(
-		context.TODO(),
+		updateCtx,
 		bson.M{"identifier": sirenID},
 		bson.M{"$set": existingAlert},
 		options.UpdateOne().SetUpsert(true),
 	)
+	endSpan(updateSpan, err)
 	if err != nil {
 		log.Error("Failed to upsert the alert in the database", "id", sirenID, "worker", workerId, "err", err)
 		return SIREN.SirenAlert{}, err
@@ -582,109 +748,255 @@ func handleAlert(alert NWS.Alert, vtec *NWS.VTEC, workerId int) (SIREN.SirenAler
 	return existingAlert, nil
 }
 
-// Handles alerts without VTEC codes
-func handleSpecialAlert(alert NWS.Alert, workerId int) (SIREN.SirenAlert, string, error) {
-	// Generate a unique ID for this special alert
-	specialID := SIREN.GenerateSpecialAlertID(alert)
+// The CAP a chain of CAPs without VTEC hangs from as far as this CAP knows, its earliest
+// reference, or the CAP itself when it references nothing
+func threadRoot(alert NWS.Alert) string {
+	root := alert.Identifier
+	var rootSent time.Time
+	for _, ref := range alert.References {
+		if rootSent.IsZero() || ref.Sent.Before(rootSent) {
+			root, rootSent = ref.Identifier, ref.Sent
+		}
+	}
+	return root
+}
+
+// Finds the event a CAP without VTEC continues by following its references to the CAPs
+// already in an event's history, or to an event started by another update of the same CAP.
+// A CAP that references nothing we track starts a new event.
+func findSpecialEvent(ctx context.Context, alert NWS.Alert) (SIREN.SirenAlert, bool) {
+	if len(alert.References) == 0 {
+		return SIREN.SirenAlert{}, false
+	}
+	referenced := make([]string, 0, len(alert.References))
+	for _, ref := range alert.References {
+		referenced = append(referenced, ref.Identifier)
+	}
+
+	var thread SIREN.SirenAlert
+	findCtx, findSpan := startMongoSpan(ctx, "findOne", stateCollection)
+	err := stateCollection.FindOne(
+		findCtx,
+		bson.M{"$or": bson.A{
+			bson.M{"history.capID": bson.M{"$in": referenced}},
+			bson.M{"threadRoot": bson.M{"$in": referenced}},
+		}},
+		options.FindOne().SetSort(bson.M{"mostRecentSentTime": -1}),
+	).Decode(&thread)
+	endSpan(findSpan, err)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Warn("Failed to look up the referenced event", "id", alert.Identifier, "err", err)
+		}
+		return SIREN.SirenAlert{}, false
+	}
+	return thread, true
+}
+
+// Finds the events started by updates that were processed before this CAP, they are
+// waiting for it to arrive and join them to the rest of the chain
+func findOrphanEvents(ctx context.Context, alert NWS.Alert, except string) []SIREN.SirenAlert {
+	findCtx, findSpan := startMongoSpan(ctx, "find", stateCollection)
+	cursor, err := stateCollection.Find(findCtx, bson.M{"threadRoot": alert.Identifier, "identifier": bson.M{"$ne": except}})
+	var orphans []SIREN.SirenAlert
+	if err == nil {
+		err = cursor.All(findCtx, &orphans)
+	}
+	endSpan(findSpan, err)
+	if err != nil {
+		log.Warn("Failed to look up events waiting for the CAP", "id", alert.Identifier, "err", err)
+		return nil
+	}
+	return orphans
+}
+
+// Moves an orphan event's history into the event and deletes the orphan
+func mergeOrphanEvent(ctx context.Context, event *SIREN.SirenAlert, orphan SIREN.SirenAlert, workerId int) {
+	event.History = append(event.History, orphan.History...)
+	slices.SortFunc(event.History, func(a, b SIREN.SirenAlertHistory) int {
+		return b.RecievedAt.Compare(a.RecievedAt)
+	})
+	event.Reports = append(event.Reports, orphan.Reports...)
+	if orphan.MostRecentSentTime.After(event.MostRecentSentTime) {
+		event.MostRecentSentTime = orphan.MostRecentSentTime
+		event.MostRecentCAP = orphan.MostRecentCAP
+		event.Areas = orphan.Areas
+		event.Expires = orphan.Expires
+		event.State = orphan.State
+		event.Impact = orphan.Impact
+	}
+
+	deleteCtx, deleteSpan := startMongoSpan(ctx, "deleteOne", stateCollection)
+	_, err := stateCollection.DeleteOne(deleteCtx, bson.M{"identifier": orphan.Identifier})
+	endSpan(deleteSpan, err)
+	if err != nil {
+		log.Error("Failed to delete a merged orphan event", "id", orphan.Identifier, "into", event.Identifier, "worker", workerId, "err", err)
+		return
+	}
+	log.Info("Merged an orphan event into its thread", "id", orphan.Identifier, "into", event.Identifier, "worker", workerId)
+}
+
+// Handles alerts without VTEC codes, like Special Weather Statements. Updates reference the
+// CAPs before them, so each chain of CAPs is tracked as one event with its own lifecycle.
+func handleSpecialAlert(ctx context.Context, alert NWS.Alert, workerId int) (SIREN.SirenAlert, string, error) {
+	// CAPs of one chain can race on different workers, and an update can even be processed
+	// before the CAP it updates. Holding the chain's root, and this CAP's own key for the
+	// updates that may be waiting on it, serializes them. Locks are taken older CAP first.
+	root := threadRoot(alert)
+	rootLock := getLock("thread:" + root)
+	rootLock.lockTraced(ctx, root)
+	defer rootLock.mu.Unlock()
+	if root != alert.Identifier {
+		ownLock := getLock("thread:" + alert.Identifier)
+		ownLock.lockTraced(ctx, alert.Identifier)
+		defer ownLock.mu.Unlock()
+	}
+
+	// Continue the event the CAP references, or one waiting for this CAP, or name a new one after it
+	existingAlert, threaded := findSpecialEvent(ctx, alert)
+	orphans := findOrphanEvents(ctx, alert, existingAlert.Identifier)
+	if !threaded && len(orphans) > 0 {
+		existingAlert, orphans, threaded = orphans[0], orphans[1:], true
+	}
+	specialID := existingAlert.Identifier
+	if !threaded {
+		specialID = SIREN.GenerateSpecialAlertID(alert)
+	}
 
 	// Get a lock for this alert
 	alertLock := getLock(specialID)
-	alertLock.mu.Lock()
+	alertLock.lockTraced(ctx, specialID)
 	defer alertLock.mu.Unlock()
 
-	log.Debug("Processing special alert (no VTEC)", "id", specialID, "worker", workerId)
+	log.Debug("Processing special alert (no VTEC)", "id", specialID, "threaded", threaded, "orphans", len(orphans), "worker", workerId)
 
-	// Check if this alert already exists in the database
-	var existingAlert SIREN.SirenAlert
-	err := stateCollection.FindOne(context.TODO(), bson.M{"identifier": specialID}).Decode(&existingAlert)
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Create a new alert
-			newAlert := SIREN.SirenAlert{
-				Identifier:         specialID,
-				State:              "Active", // Default state
-				Expires:            alert.Info.Expires,
-				MostRecentSentTime: alert.Sent,
-				LastUpdatedTime:    time.Now(),
-				History: []SIREN.SirenAlertHistory{
-					{
-						RecievedAt:            time.Now(),
-						VtecActionDescription: "New",
-						VtecAction:            NWS.VTEC_NEW,
-						AppliesTo:             alert.Info.Area.Geocodes.UGC,
-						CapID:                 alert.Identifier,
-						ExpiresAt:             alert.Info.Expires,
-					},
+	if !threaded {
+		// Create a new alert
+		newAlert := SIREN.SirenAlert{
+			Identifier:         specialID,
+			State:              "Active", // Default state
+			Expires:            alert.Info.Expires,
+			MostRecentSentTime: alert.Sent,
+			LastUpdatedTime:    time.Now(),
+			History: []SIREN.SirenAlertHistory{
+				{
+					RecievedAt:            time.Now(),
+					VtecActionDescription: "New",
+					VtecAction:            NWS.VTEC_NEW,
+					AppliesTo:             alert.Info.Area.Geocodes.UGC,
+					CapID:                 alert.Identifier,
+					ExpiresAt:             alert.Info.Expires,
+					Sent:                  alert.Sent,
 				},
-				MostRecentCAP: alert.Identifier,
-				Areas:         alert.Info.Area.Geocodes.UGC,
-			}
+			},
+			MostRecentCAP: alert.Identifier,
+			Areas:         alert.Info.Area.Geocodes.UGC,
+			TraceParent:   traceParent(ctx),
+			ThreadRoot:    root,
+		}
+		SIREN.ApplyImpact(&newAlert, alert)
 
-			// Insert the new alert
-			_, err = stateCollection.InsertOne(context.TODO(), newAlert)
-			if err != nil {
-				log.Error("Failed to insert special alert", "id", specialID, "worker", workerId, "err", err)
-				return SIREN.SirenAlert{}, "Error", err
-			}
-			log.Debug("Special alert inserted", "id", specialID, "worker", workerId)
-			return newAlert, "New", nil
-		} else {
-			log.Error("Error querying database for special alert", "id", specialID, "worker", workerId, "err", err)
+		// Insert the new alert
+		insertCtx, insertSpan := startMongoSpan(ctx, "insertOne", stateCollection)
+		_, err := stateCollection.InsertOne(insertCtx, newAlert)
+		endSpan(insertSpan, err)
+		if err != nil {
+			log.Error("Failed to insert special alert", "id", specialID, "worker", workerId, "err", err)
 			return SIREN.SirenAlert{}, "Error", err
 		}
-	} else {
-		// Update existing alert
-		existingAlert.MostRecentSentTime = alert.Sent
-		existingAlert.LastUpdatedTime = time.Now()
-		existingAlert.MostRecentCAP = alert.Identifier
-		if existingAlert.Expires.Before(alert.Info.Expires) {
-			existingAlert.Expires = alert.Info.Expires
-		}
+		log.Debug("Special alert inserted", "id", specialID, "worker", workerId)
+		return newAlert, "New", nil
+	}
 
-		// Add a new history entry
-		historyEntry := SIREN.SirenAlertHistory{
-			RecievedAt:            time.Now(),
-			VtecActionDescription: "Updated",
-			VtecAction:            NWS.VTEC_CON,
-			AppliesTo:             alert.Info.Area.Geocodes.UGC,
-			CapID:                 alert.Identifier,
-			ExpiresAt:             alert.Info.Expires,
-		}
+	// Updates that got here first started events of their own, they belong to this one
+	for _, orphan := range orphans {
+		mergeOrphanEvent(ctx, &existingAlert, orphan, workerId)
+	}
+	// The event now waits for this CAP's root, if it is older than what it waited for
+	if existingAlert.ThreadRoot == alert.Identifier {
+		existingAlert.ThreadRoot = root
+	}
 
-		existingAlert.History = append([]SIREN.SirenAlertHistory{historyEntry}, existingAlert.History...)
+	// Add a new history entry, a Cancel ends the event like a VTEC CAN
+	historyEntry := SIREN.SirenAlertHistory{
+		RecievedAt:            time.Now(),
+		VtecActionDescription: NWS.GetLongStateName(NWS.VTEC_CON),
+		VtecAction:            NWS.VTEC_CON,
+		AppliesTo:             alert.Info.Area.Geocodes.UGC,
+		CapID:                 alert.Identifier,
+		ExpiresAt:             alert.Info.Expires,
+		Sent:                  alert.Sent,
+	}
+	action := "Continued"
+	if alert.MsgType == "Cancel" {
+		historyEntry.VtecAction = NWS.VTEC_CAN
+		historyEntry.VtecActionDescription = NWS.GetLongStateName(NWS.VTEC_CAN)
+		action = historyEntry.VtecActionDescription
+	}
+	existingAlert.History = append([]SIREN.SirenAlertHistory{historyEntry}, existingAlert.History...)
+	existingAlert.LastUpdatedTime = time.Now()
+	existingAlert.TraceParent = traceParent(ctx)
 
-		// Update areas if needed
-		for _, area := range alert.Info.Area.Geocodes.UGC {
-			if !slices.Contains(existingAlert.Areas, area) {
-				existingAlert.Areas = append(existingAlert.Areas, area)
-			}
+	// A CAP delayed behind a newer one only adds to the history
+	if !alert.Sent.Before(existingAlert.MostRecentSentTime) {
+		existingAlert.MostRecentSentTime = alert.Sent
+		existingAlert.MostRecentCAP = alert.Identifier
+		// Without VTEC there is no per-area action, the latest CAP describes the whole event.
+		// Statements follow their storm, so the areas and expiry move with it.
+		existingAlert.Areas = alert.Info.Area.Geocodes.UGC
+		existingAlert.Expires = alert.Info.Expires
+		if alert.MsgType == "Cancel" {
+			existingAlert.State = "Inactive"
+		} else if alert.Info.Expires.After(time.Now()) {
+			existingAlert.State = "Active"
 		}
+	}
+	if SIREN.ApplyImpact(&existingAlert, alert) {
+		log.Info("Special alert escalated", "id", specialID, "tier", existingAlert.Impact.Tier, "worker", workerId)
+	}
 
-		// Update the alert in the database
-		_, err = stateCollection.UpdateOne(
-			context.TODO(),
-			bson.M{"identifier": specialID},
-			bson.M{"$set": existingAlert},
-		)
+	// Update the alert in the database
+	updateCtx, updateSpan := startMongoSpan(ctx, "updateOne", stateCollection)
+	_, err := stateCollection.UpdateOne(
+		updateCtx,
+		bson.M{"identifier": specialID},
+		bson.M{"$set": existingAlert},
+	)
+	endSpan(updateSpan, err)
 
-		if err != nil {
-			log.Error("Failed to update special alert", "id", specialID, "worker", workerId, "err", err)
-			return SIREN.SirenAlert{}, "Error", err
-		}
-		log.Debug("Special alert updated", "id", specialID, "worker", workerId)
-		return existingAlert, "Continued", nil
+	if err != nil {
+		log.Error("Failed to update special alert", "id", specialID, "worker", workerId, "err", err)
+		return SIREN.SirenAlert{}, "Error", err
 	}
+	log.Debug("Special alert updated", "id", specialID, "state", existingAlert.State, "worker", workerId)
+	return existingAlert, action, nil
+}
+
+// Whether the CAP has already been processed and stored
+func isStoredCap(ctx context.Context, identifier string) bool {
+	findCtx, findSpan := startMongoSpan(ctx, "countDocuments", alertsCollection)
+	count, err := alertsCollection.CountDocuments(findCtx, bson.M{"identifier": identifier}, options.Count().SetLimit(1))
+	endSpan(findSpan, err)
+	if err != nil {
+		// Processing a duplicate is better than dropping an alert
+		log.Warn("Failed to check for a stored CAP", "id", identifier, "err", err)
+		return false
+	}
+	return count > 0
 }
 
 // Stores the CAP alert in the database
-func storeCap(alert NWS.Alert, shortId string, workerId int) {
+func storeCap(ctx context.Context, alert NWS.Alert, shortId string, workerId int) {
 	var existingAlert NWS.Alert
-	err := alertsCollection.FindOne(context.TODO(), bson.M{"identifier": alert.Identifier}).Decode(&existingAlert)
+	findCtx, findSpan := startMongoSpan(ctx, "findOne", alertsCollection)
+	err := alertsCollection.FindOne(findCtx, bson.M{"identifier": alert.Identifier}).Decode(&existingAlert)
+	endSpan(findSpan, err)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			//Insert the alert into the database
-			_, err = alertsCollection.InsertOne(context.TODO(), alert)
+			insertCtx, insertSpan := startMongoSpan(ctx, "insertOne", alertsCollection)
+			_, err = alertsCollection.InsertOne(insertCtx, alert)
+			endSpan(insertSpan, err)
 			//Handle the error
 			if err != nil {
 				log.Error("Failed to insert the alert into the database", "id", shortId, "worker", workerId, "err", err)
@@ -752,52 +1064,115 @@ func deleteExpiredAlerts(expiration time.Duration) {
 
 // Handles parsing the alert JSON and processing it.
 // This is the main entry point for the alert processing logic.
-func handleAlertMessage(msg []byte, workerId int) {
+func handleAlertMessage(d ampq.Delivery, workerId int) {
 	alertsReceived.Inc()
+
+	// Continue the trace noaa-service started when the stanza arrived
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), amqpHeaderCarrier(d.Headers))
+	ctx, span := tracer.Start(ctx, "amqp.process tracking",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("worker", workerId)))
+	defer span.End()
+
 	var alert NWS.Alert
 	// Unmarshal the message
-	err := msgpack.Unmarshal(msg, &alert)
+	_, decodeSpan := tracer.Start(ctx, "cap.decode")
+	err := msgpack.Unmarshal(d.Body, &alert)
+	decodeSpan.End()
 	if err != nil {
 		log.Error("Failed to unmarshal the alert", "worker", workerId, "err", err)
+		span.SetStatus(codes.Error, "failed to unmarshal alert")
 		return
 	}
+	span.SetAttributes(attribute.String("cap.identifier", alert.Identifier))
 
 	shortId := SIREN.GetShortenedId(alert)
 	log.Debug("Received message", "id", shortId, "worker", workerId)
 
+	// Redundant noaa-service instances publish the same CAP, hold the CAP's own lock
+	// until it is stored so a second copy waits and is then skipped
+	capLock := getLock(alert.Identifier)
+	capLock.lockTraced(ctx, alert.Identifier)
+	defer capLock.mu.Unlock()
+
+	if isStoredCap(ctx, alert.Identifier) {
+		log.Debug("CAP was already processed, skipping", "id", shortId, "messageId", d.MessageId, "worker", workerId)
+		span.SetAttributes(attribute.Bool("cap.duplicate", true))
+		alertsDuplicate.Inc()
+		return
+	}
+
 	vtec, err := NWS.ParseVTEC(alert.Info.Parameters.VTEC)
 	if err != nil {
 		log.Debug("Failed to parse VTEC, skipping alert processing", "id", shortId, "worker", workerId, "err", err)
 	}
 
-	//TODO: Handle SPS (Special Weather Statements) processing
 	var action string
 	var sirenAlert SIREN.SirenAlert
 	if vtec != nil {
 		// It's sort of hidden, but this is where the alert is actually processed
-		sirenAlert, err = handleAlert(alert, vtec, workerId)
+		sirenAlert, err = handleAlert(ctx, alert, vtec, workerId)
 		action = NWS.GetLongStateName(vtec.Action)
 	} else {
-		sirenAlert, action, err = handleSpecialAlert(alert, workerId)
+		sirenAlert, action, err = handleSpecialAlert(ctx, alert, workerId)
 	}
 	if err != nil {
 		log.Error("Failed to process the alert", "id", shortId, "worker", workerId, "err", err)
+		span.SetStatus(codes.Error, "failed to process alert")
 		return
 	}
+	span.SetAttributes(attribute.String("siren.id", sirenAlert.Identifier))
 	// Save the CAP alert to the database
-	storeCap(alert, shortId, workerId)
+	storeCap(ctx, alert, shortId, workerId)
 
-	serializedAlert, err := msgpack.Marshal(SIREN.SirenAlertPushNotification{
+	push := SIREN.SirenAlertPushNotification{
 		Identifier: sirenAlert.Identifier,
 		Event:      alert.Info.Event,
 		Areas:      sirenAlert.Areas,
 		Sender:     alert.Info.SenderName,
 		EventCode:  alert.Info.EventCode.NWS,
 		Action:     action,
-	})
+		Severity:   alert.Info.Severity,
+		SAMECodes:  alert.Info.Area.Geocodes.SAME,
+		Expires:    alert.Info.Expires,
+	}
+	if params := alert.Info.Parameters; params != nil {
+		push.Motion = params.EventMotionDescription
+	}
+	if polygon := alert.Info.Area.Polygon; polygon != nil && len(polygon.Coordinates) > 0 {
+		for _, pt := range polygon.Coordinates[0] {
+			if len(pt) >= 2 {
+				push.Polygon = append(push.Polygon, [2]float64{pt[0], pt[1]})
+			}
+		}
+	}
+	if sirenAlert.Impact != nil {
+		push.Tier = string(sirenAlert.Impact.Tier)
+		push.Label = sirenAlert.Impact.Label
+	}
+	// An escalation, like an upgrade to a Tornado Emergency, is its own action rather than a CON
+	if previous, ok := SIREN.EscalationFrom(sirenAlert, alert.Identifier); ok {
+		push.Action = "Escalated"
+		push.Escalated = true
+		push.PreviousTier = string(previous)
+		alertsEscalated.WithLabelValues(push.Tier).Inc()
+		span.SetAttributes(attribute.String("siren.escalated_to", push.Tier))
+	}
+	if messages, err := WEA.Render(alert); err == nil {
+		push.WEA = messages
+	}
+	if headers, err := SAME.FromAlert(alert); err == nil {
+		for _, header := range headers {
+			push.SAME = append(push.SAME, header.String())
+		}
+	}
+	serializedAlert, err := msgpack.Marshal(push)
 	if err != nil {
 		log.Warn("Failed to marshal the alert to msgpack", "id", shortId, "worker", workerId, "err", err)
 	} else {
+		publishCtx, publishSpan := tracer.Start(ctx, "amqp.publish push", trace.WithSpanKind(trace.SpanKindProducer))
+		headers := ampq.Table{}
+		otel.GetTextMapPropagator().Inject(publishCtx, amqpHeaderCarrier(headers))
 		err = ch.Publish(
 			"",
 			liveQueue.Name,
@@ -805,15 +1180,219 @@ func handleAlertMessage(msg []byte, workerId int) {
 			false,
 			ampq.Publishing{
 				ContentType: "application/msgpack",
+				Headers:     headers,
 				Body:        serializedAlert,
 			},
 		)
+		endSpan(publishSpan, err)
 		if err != nil {
 			log.Error("Failed to publish the alert to the live queue", "id", shortId, "worker", workerId, "err", err)
 		}
 		log.Info("Published alert to the live queue", "id", sirenAlert.Identifier, "worker", workerId)
+
+		if matchQueue != "" {
+			err = ch.Publish("", matchQueue, false, false, ampq.Publishing{
+				ContentType: "application/msgpack",
+				Headers:     headers,
+				Body:        serializedAlert,
+			})
+			if err != nil {
+				log.Error("Failed to publish the alert to the match queue", "id", shortId, "worker", workerId, "err", err)
+			}
+		}
+	}
+
+	if audioDir != "" {
+		queueAlertAudio(ctx, sirenAlert.Identifier, alert)
 	}
 
 	log.Debug("Alert processed successfully, worker is free", "id", shortId, "worker", workerId)
 	alertsProcessed.Inc()
 }
+
+/**============================================
+ *              Broadcast Audio
+ *=============================================**/
+
+// AUDIO_DIR turns on a NOAA Weather Radio style WAV file for every alert, for radio partners
+// testing their encoders. The spoken part is a placeholder until a TTS engine is plugged in.
+// Files are kept for AUDIO_RETENTION, a day by default.
+var audioDir string
+var audioSpeaker Audio.Speaker = Audio.PlaceholderSpeaker{}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type audioJob struct {
+	ctx     context.Context
+	sirenId string
+	alert   NWS.Alert
+}
+
+// Alerts waiting for the audio worker. A burst beyond this is dropped rather than holding
+// up the alert workers, the audio is for testing and a missed file is no harm.
+var audioJobs = make(chan audioJob, 32)
+
+func queueAlertAudio(ctx context.Context, sirenId string, alert NWS.Alert) {
+	// A cancel is never broadcast with a SAME header
+	if alert.MsgType == "Cancel" {
+		return
+	}
+	select {
+	case audioJobs <- audioJob{ctx: ctx, sirenId: sirenId, alert: alert}:
+	default:
+		audioDropped.Inc()
+		log.Warn("Audio queue is full, skipping alert audio", "id", sirenId)
+	}
+}
+
+// Deletes alert audio older than the retention, checking every tenth of it
+func pruneAlertAudio(retention time.Duration) {
+	timer := time.NewTicker(max(retention/10, time.Minute))
+	defer timer.Stop()
+	for range timer.C {
+		entries, err := os.ReadDir(audioDir)
+		if err != nil {
+			log.Error("Failed to read the audio directory", "dir", audioDir, "err", err)
+			continue
+		}
+		removed := 0
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".wav" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < retention {
+				continue
+			}
+			if err := os.Remove(filepath.Join(audioDir, entry.Name())); err != nil {
+				log.Warn("Failed to delete old alert audio", "file", entry.Name(), "err", err)
+				continue
+			}
+			removed++
+		}
+		if removed > 0 {
+			log.Info("Deleted old alert audio", "files", removed)
+		}
+	}
+}
+
+func writeAlertAudio(ctx context.Context, sirenId string, alert NWS.Alert) {
+	ctx, span := tracer.Start(ctx, "audio.synthesize")
+	defer span.End()
+
+	messages, err := Audio.MessagesFromAlert(alert)
+	if err != nil {
+		// Blocked alerts and alerts without SAME codes aren't broadcast
+		log.Debug("No broadcast audio for alert", "id", sirenId, "reason", err)
+		return
+	}
+
+	// An alert for more locations than a header holds is broadcast once for each header
+	base := unsafeFileChars.ReplaceAllString(sirenId+"_"+alert.Identifier, "_")
+	for i, msg := range messages {
+		name := base + ".wav"
+		if i > 0 {
+			name = fmt.Sprintf("%s_%d.wav", base, i+1)
+		}
+		if err := writeMessageAudio(ctx, msg, name); err != nil {
+			log.Error("Failed to write alert audio", "id", sirenId, "file", name, "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		log.Debug("Wrote alert audio", "id", sirenId, "file", name, "header", msg.Header)
+	}
+}
+
+func writeMessageAudio(ctx context.Context, msg Audio.Message, name string) error {
+	samples, err := Audio.Synthesize(ctx, msg, audioSpeaker)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(audioDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := Audio.WriteWAV(writer, samples, Audio.SampleRate); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+/**============================================
+ *           Text Product Processing
+ *=============================================**/
+
+// Stores a text product and matches any storm reports in it against the tracked events
+func handleProductMessage(d ampq.Delivery) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), amqpHeaderCarrier(d.Headers))
+	ctx, span := tracer.Start(ctx, "amqp.process products", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	var product NWS.Product
+	if err := msgpack.Unmarshal(d.Body, &product); err != nil {
+		log.Error("Failed to unmarshal the product", "err", err)
+		span.SetStatus(codes.Error, "failed to unmarshal product")
+		return
+	}
+	productsReceived.WithLabelValues(product.Category).Inc()
+	span.SetAttributes(attribute.String("product.awipsid", product.AWIPSID))
+
+	// The message id is the product's WMO heading, the same for every copy of it
+	key := d.MessageId
+	if key == "" {
+		key = fmt.Sprintf("%s %s", product.AWIPSID, product.WMO.Issued.Format(time.RFC3339))
+	}
+
+	// Kept so storm reports can be replayed against the events later
+	storeCtx, storeSpan := startMongoSpan(ctx, "updateOne", productsCollection)
+	_, err := productsCollection.UpdateOne(
+		storeCtx,
+		bson.M{"key": key},
+		bson.M{"$setOnInsert": bson.M{"key": key, "product": product, "receivedAt": time.Now()}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	endSpan(storeSpan, err)
+	if err != nil {
+		log.Error("Failed to store the product", "key", key, "err", err)
+	}
+
+	for _, report := range product.LSRs {
+		stormReportsReceived.Inc()
+		matched, err := correlator.Correlate(ctx, key, report)
+		if err != nil {
+			log.Error("Failed to correlate storm report", "key", key, "event", report.Event, "err", err)
+			continue
+		}
+		stormReportMatches.WithLabelValues(strconv.FormatBool(len(matched) > 0)).Inc()
+		if len(matched) > 0 {
+			log.Info("Storm report matched events", "event", report.Event, "location", report.Location, "events", matched)
+		}
+	}
+
+	if product.Watch != nil {
+		watchCtx, watchSpan := startMongoSpan(ctx, "findOneAndUpdate", watchStore.Watches)
+		watch, err := watchStore.Record(watchCtx, key, product.Issued, *product.Watch)
+		endSpan(watchSpan, err)
+		if err != nil {
+			log.Error("Failed to record the watch", "key", key, "number", product.Watch.Number, "err", err)
+		} else {
+			watchesUpdated.WithLabelValues(product.Category).Inc()
+			log.Info("Watch updated", "id", watch.Identifier, "headline", watch.Headline, "product", product.AWIPSID)
+		}
+	}
+
+	if product.MCD != nil && len(product.MCD.Watches) > 0 {
+		mcdCtx, mcdSpan := startMongoSpan(ctx, "updateOne", watchStore.Watches)
+		watches, err := watchStore.RecordDiscussion(mcdCtx, key, product.Issued, *product.MCD)
+		endSpan(mcdSpan, err)
+		if err != nil {
+			log.Error("Failed to record the mesoscale discussion", "key", key, "number", product.MCD.Number, "err", err)
+		} else if len(watches) > 0 {
+			log.Info("Mesoscale discussion added to watches", "number", product.MCD.Number, "watches", watches)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/charmbracelet/log"
+	ampq "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("trackingService")
+
+// Sets up trace export to the collector at OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318.
+// Without an endpoint nothing is exported, but spans still get ids so the trace context
+// passed to the other services links their spans to ours.
+func initTracing(serviceName string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			log.Error("Failed to create trace exporter, traces will not be exported", "err", err)
+		} else {
+			options = append(options, sdktrace.WithBatcher(exporter))
+			log.Info("Exporting traces to the OpenTelemetry collector")
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// Carries trace context in the headers of AMQP messages
+type amqpHeaderCarrier ampq.Table
+
+func (c amqpHeaderCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c amqpHeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c amqpHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// The W3C traceparent of the span in ctx, stored on the state document so the geo
+// service can link its rebuild to the alert that caused it
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+func startMongoSpan(ctx context.Context, operation string, collection *mongo.Collection) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "mongo."+operation+" "+collection.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.collection", collection.Name()),
+		))
+}
+
+// Ends the span, marking it failed for any error other than a missing document
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}